@@ -0,0 +1,253 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// AI 配置的解析顺序：用户在 user_settings 中配置了自己的 API 密钥时使用用户配置；
+// 否则使用实例级共享配置（ai_settings 集合中的第一条记录，未设置的字段回退到 AI_* 环境变量）。
+// 使用共享密钥时忽略用户的 Base URL（避免把共享密钥发往用户指定的地址），并按用户限制每日请求数。
+// 另外可以为所有调用（包括用户自己的密钥）设置每日请求数和 token 数限制，用量从 ai_usage 集合统计。
+
+const (
+	defaultAIApiBaseUrl = "https://generativelanguage.googleapis.com/v1beta/openai/"
+	defaultAIModelName  = "gemini-2.0-flash"
+)
+
+const aiNotConfiguredMessage = "AI service is not configured. Add your own API key in the settings or ask the administrator to configure a shared key."
+
+var (
+	errAINotConfigured       = errors.New("AI service is not configured")
+	errAIQuotaExceeded       = errors.New("daily AI usage limit reached")
+	errAISharedQuotaExceeded = errors.New("daily AI request limit reached")
+)
+
+// AIConfig is the provider configuration used for one AI call.
+type AIConfig struct {
+	BaseURL string
+	APIKey  string
+	Model   string
+	// Shared is true when the instance-wide key is used instead of the user's own key.
+	Shared bool
+}
+
+// instanceAISettings is the instance-wide AI configuration.
+type instanceAISettings struct {
+	BaseURL string
+	APIKey  string
+	Model   string
+	// SharedRequestLimit limits the shared-key requests of each user per day, 0 means unlimited.
+	SharedRequestLimit      int
+	SharedUserRequestLimits map[string]int
+	// DailyLimits applies to every AI call of a user, whichever key is used.
+	DailyLimits aiDailyLimits
+	UserLimits  map[string]aiDailyLimits
+}
+
+// aiDailyLimits holds the per-user daily limits, 0 means unlimited.
+type aiDailyLimits struct {
+	Requests int `json:"requests"`
+	Tokens   int `json:"tokens"`
+}
+
+// limitsFor returns the daily limits of userId, applying its overrides.
+func (s instanceAISettings) limitsFor(userId string) aiDailyLimits {
+	limits := s.DailyLimits
+	if override, ok := s.UserLimits[userId]; ok {
+		limits = override
+	}
+	return limits
+}
+
+// sharedLimitFor returns the shared-key request limit of userId.
+func (s instanceAISettings) sharedLimitFor(userId string) int {
+	if limit, ok := s.SharedUserRequestLimits[userId]; ok {
+		return limit
+	}
+	return s.SharedRequestLimit
+}
+
+// loadInstanceAISettings reads the AI_* environment variables and overrides them with the
+// non-empty fields of the first ai_settings record.
+func loadInstanceAISettings(app core.App) instanceAISettings {
+	settings := instanceAISettings{
+		BaseURL: os.Getenv("AI_API_BASE_URL"),
+		APIKey:  os.Getenv("AI_API_KEY"),
+		Model:   os.Getenv("AI_MODEL_NAME"),
+	}
+	if limit, err := strconv.Atoi(os.Getenv("AI_SHARED_DAILY_REQUEST_LIMIT")); err == nil && limit > 0 {
+		settings.SharedRequestLimit = limit
+	}
+	if limit, err := strconv.Atoi(os.Getenv("AI_DAILY_REQUEST_LIMIT")); err == nil && limit > 0 {
+		settings.DailyLimits.Requests = limit
+	}
+	if limit, err := strconv.Atoi(os.Getenv("AI_DAILY_TOKEN_LIMIT")); err == nil && limit > 0 {
+		settings.DailyLimits.Tokens = limit
+	}
+
+	records, err := app.FindRecordsByFilter("ai_settings", "", "createdAt", 1, 0)
+	if err != nil || len(records) == 0 {
+		return settings
+	}
+	record := records[0]
+
+	if v := record.GetString("apiBaseUrl"); v != "" {
+		settings.BaseURL = v
+	}
+	if v := record.GetString("modelName"); v != "" {
+		settings.Model = v
+	}
+	if record.GetString("apiKey") != "" {
+		apiKey, err := decryptUserSetting(record, "apiKey")
+		if err != nil {
+			log.Printf("Error decrypting shared AI API key: %v", err)
+		} else {
+			settings.APIKey = apiKey
+		}
+	}
+	if limit := record.GetInt("sharedDailyRequestLimit"); limit > 0 {
+		settings.SharedRequestLimit = limit
+	}
+	if _, err := decodeJSONField(record, "userDailyRequestLimits", &settings.SharedUserRequestLimits); err != nil {
+		log.Printf("Error reading ai_settings.userDailyRequestLimits: %v", err)
+	}
+	if limit := record.GetInt("dailyRequestLimit"); limit > 0 {
+		settings.DailyLimits.Requests = limit
+	}
+	if limit := record.GetInt("dailyTokenLimit"); limit > 0 {
+		settings.DailyLimits.Tokens = limit
+	}
+	if _, err := decodeJSONField(record, "userDailyLimits", &settings.UserLimits); err != nil {
+		log.Printf("Error reading ai_settings.userDailyLimits: %v", err)
+	}
+
+	return settings
+}
+
+// resolveAIConfig returns the AI configuration for a user. userSettings may be nil.
+func resolveAIConfig(app core.App, userSettings *core.Record) (AIConfig, error) {
+	if userSettings != nil && userSettings.GetString("geminiApiKey") != "" {
+		apiKey, err := decryptUserSetting(userSettings, "geminiApiKey")
+		if err != nil {
+			return AIConfig{}, fmt.Errorf("failed to decrypt Gemini API key: %w", err)
+		}
+		baseURL, err := decryptUserSetting(userSettings, "geminiApiBaseUrl")
+		if err != nil {
+			return AIConfig{}, fmt.Errorf("failed to decrypt Gemini API base URL: %w", err)
+		}
+		config := AIConfig{BaseURL: baseURL, APIKey: apiKey, Model: userSettings.GetString("geminiModelName")}
+		if config.BaseURL == "" {
+			config.BaseURL = defaultAIApiBaseUrl
+		}
+		if config.Model == "" {
+			config.Model = defaultAIModelName
+		}
+		return config, nil
+	}
+
+	instance := loadInstanceAISettings(app)
+	if instance.APIKey == "" {
+		return AIConfig{}, errAINotConfigured
+	}
+	config := AIConfig{BaseURL: instance.BaseURL, APIKey: instance.APIKey, Model: instance.Model, Shared: true}
+	if config.BaseURL == "" {
+		config.BaseURL = defaultAIApiBaseUrl
+	}
+	if config.Model == "" {
+		config.Model = defaultAIModelName
+	}
+	return config, nil
+}
+
+// --- Daily quotas ---
+
+// checkAIQuota fails with errAIQuotaExceeded or errAISharedQuotaExceeded when userId has used up
+// one of its daily limits. Usage is counted from today's ai_usage records (UTC).
+// 并发请求可能略微超出限制，这里不做加锁。
+func checkAIQuota(app core.App, userId string, shared bool) error {
+	instance := loadInstanceAISettings(app)
+	limits := instance.limitsFor(userId)
+	sharedLimit := 0
+	if shared {
+		sharedLimit = instance.sharedLimitFor(userId)
+	}
+	if limits.Requests <= 0 && limits.Tokens <= 0 && sharedLimit <= 0 {
+		return nil
+	}
+
+	usage, err := loadAIUsageToday(app, userId)
+	if err != nil {
+		return fmt.Errorf("failed to load AI usage: %w", err)
+	}
+
+	if sharedLimit > 0 && usage.SharedRequests >= sharedLimit {
+		return fmt.Errorf("%w (%d requests per day on the shared key)", errAISharedQuotaExceeded, sharedLimit)
+	}
+	if limits.Requests > 0 && usage.Requests >= limits.Requests {
+		return fmt.Errorf("%w (%d requests per day)", errAIQuotaExceeded, limits.Requests)
+	}
+	if limits.Tokens > 0 && usage.TotalTokens >= limits.Tokens {
+		return fmt.Errorf("%w (%d tokens per day)", errAIQuotaExceeded, limits.Tokens)
+	}
+	return nil
+}
+
+// prepareAICall resolves the AI configuration for userId and checks its daily quotas.
+func prepareAICall(app core.App, userId string, userSettings *core.Record) (AIConfig, error) {
+	config, err := resolveAIConfig(app, userSettings)
+	if err != nil {
+		return config, err
+	}
+	if err := checkAIQuota(app, userId, config.Shared); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// aiConfigErrorResponse maps errors from prepareAICall to a status code and message.
+func aiConfigErrorResponse(err error) (int, string) {
+	switch {
+	case errors.Is(err, errAINotConfigured):
+		return http.StatusServiceUnavailable, aiNotConfiguredMessage
+	case errors.Is(err, errAISharedQuotaExceeded):
+		return http.StatusTooManyRequests, "Daily AI request limit reached for the shared API key. Try again tomorrow or add your own API key in the settings."
+	case errors.Is(err, errAIQuotaExceeded):
+		return http.StatusTooManyRequests, "Daily AI usage limit reached. Try again tomorrow."
+	default:
+		return http.StatusInternalServerError, "Failed to load AI configuration."
+	}
+}
+
+// registerAISettingsHooks keeps the shared API key encrypted at rest and masked in responses.
+func registerAISettingsHooks(app *pocketbase.PocketBase) {
+	encryptAPIKey := func(e *core.RecordRequestEvent) error {
+		apiKey := e.Record.GetString("apiKey")
+		originalAPIKey := e.Record.Original().GetString("apiKey")
+		switch {
+		case isMaskedSecret(apiKey, originalAPIKey):
+			e.Record.Set("apiKey", originalAPIKey)
+		case apiKey != "" && !isEncryptedSecret(apiKey):
+			encrypted, err := encryptSensitiveData(apiKey)
+			if err != nil {
+				return fmt.Errorf("failed to encrypt shared AI API key: %w", err)
+			}
+			e.Record.Set("apiKey", encrypted)
+		}
+		return e.Next()
+	}
+	app.OnRecordCreateRequest("ai_settings").BindFunc(encryptAPIKey)
+	app.OnRecordUpdateRequest("ai_settings").BindFunc(encryptAPIKey)
+
+	app.OnRecordEnrich("ai_settings").BindFunc(func(e *core.RecordEnrichEvent) error {
+		e.Record.Set("apiKey", maskedSecretValue(e.Record.GetString("apiKey")))
+		return e.Next()
+	})
+}
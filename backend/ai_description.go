@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// AI 生成的书签描述：根据抓取的 PageData 和用户语言对应的提示模板生成简短描述，保存到 bookmarks.description。
+// 支持单个书签、批量补全没有描述的书签，以及新建书签时自动生成（user_settings.aiAutoDescribe）。
+
+const aiFeatureDescribe = "describe"
+
+// maxAIDescriptionLength limits the stored description, in characters.
+const maxAIDescriptionLength = 500
+
+const (
+	defaultAIDescribeBatchSize = 10
+	maxAIDescribeBatchSize     = 25
+)
+
+// errAIRequestFailed is returned when the provider call fails or its answer can't be used.
+var errAIRequestFailed = errors.New("AI request failed")
+
+// parseAIJSONContent decodes the JSON object in the message content of a chat completion,
+// tolerating Markdown code fences around it.
+func parseAIJSONContent(responseBody []byte, dst any) error {
+	var result struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal(responseBody, &result); err != nil {
+		return fmt.Errorf("failed to parse AI API response: %w", err)
+	}
+	if len(result.Choices) == 0 {
+		return fmt.Errorf("AI API response has no choices")
+	}
+
+	cleanContent := strings.TrimSpace(result.Choices[0].Message.Content)
+	if strings.HasPrefix(cleanContent, "```json") {
+		cleanContent = strings.TrimPrefix(cleanContent, "```json")
+		cleanContent = strings.TrimSuffix(cleanContent, "```")
+	} else if strings.HasPrefix(cleanContent, "```") {
+		cleanContent = strings.TrimPrefix(cleanContent, "```")
+		cleanContent = strings.TrimSuffix(cleanContent, "```")
+	}
+	cleanContent = strings.TrimSpace(cleanContent)
+
+	if err := json.Unmarshal([]byte(cleanContent), dst); err != nil {
+		return fmt.Errorf("failed to parse AI response content %q: %w", cleanContent, err)
+	}
+	return nil
+}
+
+// generateBookmarkDescription asks the model for a description of bookmark, counting the call
+// against the user's quotas. Errors from prepareAICall are returned unchanged; provider errors
+// wrap errAIRequestFailed.
+func generateBookmarkDescription(app *pocketbase.PocketBase, userId string, userSettings *core.Record, bookmark *core.Record) (string, error) {
+	aiConfig, err := prepareAICall(app, userId, userSettings)
+	if err != nil {
+		return "", err
+	}
+
+	url := bookmark.GetString("url")
+	pageData, err := fetchPageContent(url, app, userSettings)
+	if err != nil {
+		log.Printf("Describe: failed to fetch page content for URL %s: %v. Proceeding with title and URL only.", url, err)
+	}
+
+	promptData := newAIPromptData(aiPromptDescription, bookmark.GetString("title"), url, pageData)
+	prompt := renderAIPrompt(userSettings, aiPromptDescription, promptData)
+
+	requestBody, _ := json.Marshal(map[string]interface{}{
+		"model": aiConfig.Model,
+		"messages": []map[string]interface{}{
+			{"role": "system", "content": prompt.System},
+			{"role": "user", "content": prompt.User},
+		},
+		"temperature":     0.3,
+		"max_tokens":      300,
+		"response_format": map[string]string{"type": "json_object"},
+	})
+
+	responseBodyBytes, statusCode, err := callAIChatCompletion(app, userId, aiFeatureDescribe, aiConfig, requestBody, 30*time.Second)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errAIRequestFailed, err)
+	}
+	if statusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: AI API returned status %d", errAIRequestFailed, statusCode)
+	}
+
+	var descriptionResponse struct {
+		Description string `json:"description"`
+	}
+	if err := parseAIJSONContent(responseBodyBytes, &descriptionResponse); err != nil {
+		return "", fmt.Errorf("%w: %v", errAIRequestFailed, err)
+	}
+
+	description := strings.TrimSpace(descriptionResponse.Description)
+	if description == "" {
+		return "", fmt.Errorf("%w: AI response did not contain a description", errAIRequestFailed)
+	}
+	if runes := []rune(description); len(runes) > maxAIDescriptionLength {
+		description = string(runes[:maxAIDescriptionLength])
+	}
+	return description, nil
+}
+
+// aiDescribeErrorResponse maps errors from generateBookmarkDescription to a status code and message.
+func aiDescribeErrorResponse(err error) (int, string) {
+	if errors.Is(err, errAIRequestFailed) {
+		return http.StatusBadGateway, "AI service failed to generate a description for this bookmark."
+	}
+	return aiConfigErrorResponse(err)
+}
+
+// aiDescribeBookmarkHandler generates and stores the description of one bookmark, replacing
+// any existing description.
+// API Endpoint: POST /api/custom/bookmarks/{bookmarkId}/ai-describe
+// Response: { "success": true, "bookmarkId", "description" }
+func aiDescribeBookmarkHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		authRecord := e.Auth
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("User not authenticated.", nil)
+		}
+		userId := authRecord.Id
+
+		bookmarkId := e.Request.PathValue("bookmarkId")
+		if bookmarkId == "" {
+			return e.BadRequestError("Bookmark ID is required", nil)
+		}
+
+		bookmark, err := app.FindRecordById("bookmarks", bookmarkId)
+		if err != nil {
+			return e.NotFoundError("Bookmark not found", err)
+		}
+		if !canEditBookmark(app, userId, bookmark) {
+			return apis.NewForbiddenError("Access denied to this bookmark.", nil)
+		}
+		if bookmark.GetString("url") == "" {
+			return e.BadRequestError("Bookmark URL is required for AI description", nil)
+		}
+
+		userSettings, err := app.FindFirstRecordByFilter(
+			"user_settings",
+			"userId = {:userId}",
+			dbx.Params{"userId": userId},
+		)
+		if err != nil {
+			return e.NotFoundError("User settings not found", err)
+		}
+
+		description, err := generateBookmarkDescription(app, userId, userSettings, bookmark)
+		if err != nil {
+			status, message := aiDescribeErrorResponse(err)
+			return e.Error(status, message, err)
+		}
+
+		bookmark.Set("description", description)
+		if err := app.SaveWithContext(auditContext(e, auditSourceAI), bookmark); err != nil {
+			return e.InternalServerError("Failed to save bookmark description", err)
+		}
+
+		return e.JSON(http.StatusOK, map[string]interface{}{
+			"success":     true,
+			"bookmarkId":  bookmark.Id,
+			"description": description,
+		})
+	}
+}
+
+// aiDescribeMissingHandler generates descriptions for bookmarks that have none, oldest first.
+// Bookmarks are processed one by one; the batch stops early when the AI service is not
+// available or a quota is reached, so the client can call it again to continue. Bookmarks whose
+// description could not be generated are marked with descriptionFailedAt and skipped by later
+// batches unless retryFailed is set.
+// API Endpoint: POST /api/custom/bookmarks/ai-describe-missing
+// Request: { "limit": 10, "retryFailed"?: bool }
+// Response: { "success": true, "processed", "updated", "failed": [{ "bookmarkId", "error" }], "remaining", "previouslyFailed", "stopped"?: string }
+func aiDescribeMissingHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		authRecord := e.Auth
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("User not authenticated.", nil)
+		}
+		userId := authRecord.Id
+
+		var requestData struct {
+			Limit       int  `json:"limit"`
+			RetryFailed bool `json:"retryFailed"`
+		}
+		if err := e.BindBody(&requestData); err != nil {
+			return e.BadRequestError("Failed to parse request data.", err)
+		}
+		limit := requestData.Limit
+		if limit <= 0 {
+			limit = defaultAIDescribeBatchSize
+		}
+		if limit > maxAIDescribeBatchSize {
+			limit = maxAIDescribeBatchSize
+		}
+
+		// 处理所选空间（个人或工作区）中的书签
+		space, err := resolveSpaceContext(app, e)
+		if err != nil {
+			return err
+		}
+		if !space.canEdit() {
+			return apis.NewForbiddenError("You need editor access to this workspace.", nil)
+		}
+
+		userSettings, err := app.FindFirstRecordByFilter(
+			"user_settings",
+			"userId = {:userId}",
+			dbx.Params{"userId": userId},
+		)
+		if err != nil {
+			return e.NotFoundError("User settings not found", err)
+		}
+		if _, err := resolveAIConfig(app, userSettings); err != nil {
+			status, message := aiConfigErrorResponse(err)
+			return e.Error(status, message, err)
+		}
+
+		spaceFilter, spaceParams := space.recordFilter()
+		missingFilter := spaceFilter + " && description = '' && url != ''"
+		if !requestData.RetryFailed {
+			missingFilter += " && descriptionFailedAt = ''"
+		}
+		bookmarks, err := app.FindRecordsByFilter("bookmarks", missingFilter, "createdAt", limit, 0, spaceParams)
+		if err != nil {
+			return e.InternalServerError("Failed to fetch bookmarks", err)
+		}
+
+		type describeFailure struct {
+			BookmarkID string `json:"bookmarkId"`
+			Error      string `json:"error"`
+		}
+		updated := 0
+		failed := []describeFailure{}
+		stopped := ""
+		processed := 0
+		auditCtx := auditContext(e, auditSourceAI)
+		for _, bookmark := range bookmarks {
+			description, err := generateBookmarkDescription(app, userId, userSettings, bookmark)
+			if err != nil {
+				if !errors.Is(err, errAIRequestFailed) {
+					// 未配置或超出限额，后续书签也会失败
+					_, stopped = aiConfigErrorResponse(err)
+					break
+				}
+				processed++
+				log.Printf("Describe: failed to describe bookmark %s: %v", bookmark.Id, err)
+				failed = append(failed, describeFailure{BookmarkID: bookmark.Id, Error: err.Error()})
+
+				// 标记失败，避免之后的批次反复处理同一批书签
+				bookmark.Set("descriptionFailedAt", types.NowDateTime())
+				if err := app.SaveWithContext(auditCtx, bookmark); err != nil {
+					log.Printf("Describe: failed to mark bookmark %s as failed: %v", bookmark.Id, err)
+				}
+				continue
+			}
+			processed++
+
+			bookmark.Set("description", description)
+			bookmark.Set("descriptionFailedAt", "")
+			if err := app.SaveWithContext(auditCtx, bookmark); err != nil {
+				log.Printf("Describe: failed to save description of bookmark %s: %v", bookmark.Id, err)
+				failed = append(failed, describeFailure{BookmarkID: bookmark.Id, Error: "failed to save bookmark"})
+				continue
+			}
+			updated++
+		}
+
+		remaining, err := app.CountRecords("bookmarks", space.recordExp(), dbx.NewExp("description = '' AND url != '' AND descriptionFailedAt = ''"))
+		if err != nil {
+			log.Printf("Describe: failed to count remaining bookmarks for user %s: %v", userId, err)
+		}
+		previouslyFailed, err := app.CountRecords("bookmarks", space.recordExp(), dbx.NewExp("description = '' AND url != '' AND descriptionFailedAt != ''"))
+		if err != nil {
+			log.Printf("Describe: failed to count failed bookmarks for user %s: %v", userId, err)
+		}
+
+		response := map[string]interface{}{
+			"success":          true,
+			"processed":        processed,
+			"updated":          updated,
+			"failed":           failed,
+			"remaining":        remaining,
+			"previouslyFailed": previouslyFailed,
+		}
+		if stopped != "" {
+			response["stopped"] = stopped
+		}
+		return e.JSON(http.StatusOK, response)
+	}
+}
+
+// registerAIDescriptionHooks describes new bookmarks in the background when the user enabled
+// user_settings.aiAutoDescribe and the bookmark was created without a description. It also clears
+// descriptionFailedAt when the URL of a bookmark changes.
+func registerAIDescriptionHooks(app *pocketbase.PocketBase) {
+	// 修改 URL 后重新参与批量生成描述
+	app.OnRecordUpdate("bookmarks").BindFunc(func(e *core.RecordEvent) error {
+		if e.Record.GetString("url") != e.Record.Original().GetString("url") {
+			e.Record.Set("descriptionFailedAt", "")
+		}
+		return e.Next()
+	})
+
+	app.OnRecordCreateRequest("bookmarks").BindFunc(func(e *core.RecordRequestEvent) error {
+		if err := e.Next(); err != nil {
+			return err
+		}
+
+		bookmark := e.Record
+		userId := bookmark.GetString("userId")
+		if userId == "" || bookmark.GetString("description") != "" || bookmark.GetString("url") == "" {
+			return nil
+		}
+
+		userSettings, err := app.FindFirstRecordByFilter(
+			"user_settings",
+			"userId = {:userId}",
+			dbx.Params{"userId": userId},
+		)
+		if err != nil || !userSettings.GetBool("aiAutoDescribe") {
+			return nil
+		}
+		if _, err := resolveAIConfig(app, userSettings); err != nil {
+			return nil
+		}
+
+		bookmarkId := bookmark.Id
+		go func() {
+			// 重新读取书签，避免覆盖创建后已经被修改的字段
+			bookmark, err := app.FindRecordById("bookmarks", bookmarkId)
+			if err != nil {
+				return
+			}
+			description, err := generateBookmarkDescription(app, userId, userSettings, bookmark)
+			if err != nil {
+				log.Printf("Describe: automatic description of bookmark %s failed: %v", bookmarkId, err)
+				return
+			}
+
+			// 生成期间用户可能已经填写了描述
+			bookmark, err = app.FindRecordById("bookmarks", bookmarkId)
+			if err != nil || bookmark.GetString("description") != "" {
+				return
+			}
+			bookmark.Set("description", description)
+			if err := app.SaveWithContext(withAuditActor(context.Background(), "", auditSourceAI), bookmark); err != nil {
+				log.Printf("Describe: failed to save automatic description of bookmark %s: %v", bookmarkId, err)
+			}
+		}()
+
+		return nil
+	})
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// AI 提示模板：每种提示（文件夹建议、标签建议、书签描述）由 system 和 user 两个 text/template 模板组成。
+// 用户可以在 user_settings.aiPromptTemplates 中覆盖任意一个模板，未覆盖的使用与 user_settings.language
+// 对应的默认模板。模板在保存时校验，运行时渲染失败则回退到默认模板。
+
+// Prompt kinds, used as keys of user_settings.aiPromptTemplates.
+const (
+	aiPromptFolder      = "folder"
+	aiPromptTags        = "tags"
+	aiPromptDescription = "description"
+)
+
+// maxAIPromptTemplateLength limits the size of one custom template.
+const maxAIPromptTemplateLength = 10000
+
+// Content excerpt limits of each prompt kind.
+var aiPromptContentLimits = map[string]int{
+	aiPromptFolder:      10000,
+	aiPromptTags:        15000,
+	aiPromptDescription: 8000,
+}
+
+// AIPromptTemplate is one system/user template pair. Empty parts use the default.
+type AIPromptTemplate struct {
+	System string `json:"system,omitempty"`
+	User   string `json:"user,omitempty"`
+}
+
+// AIPromptData holds the variables available to prompt templates.
+type AIPromptData struct {
+	Title           string
+	URL             string
+	MetaTitle       string
+	MetaDescription string
+	OGTitle         string
+	OGDescription   string
+	// Content is the page text, truncated to the excerpt limit of the prompt kind.
+	Content string
+	// Folders are the candidate folder names (folder prompt).
+	Folders []string
+	// Tags are the candidate tags (tags prompt).
+	Tags     []string
+	Language string
+}
+
+// newAIPromptData builds the template variables for a page.
+func newAIPromptData(kind string, title string, url string, pageData PageData) AIPromptData {
+	content := pageData.Content
+	if maxContentLength := aiPromptContentLimits[kind]; len(content) > maxContentLength {
+		content = strings.ToValidUTF8(content[:maxContentLength], "")
+	}
+	return AIPromptData{
+		Title:           title,
+		URL:             url,
+		MetaTitle:       pageData.MetaTitle,
+		MetaDescription: pageData.MetaDescription,
+		OGTitle:         pageData.OGTitle,
+		OGDescription:   pageData.OGDescription,
+		Content:         content,
+	}
+}
+
+var aiPromptFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
+// defaultAIPromptTemplates holds the default templates by language and kind.
+var defaultAIPromptTemplates = map[string]map[string]AIPromptTemplate{
+	"en": {
+		aiPromptFolder: {
+			System: `You are a professional bookmark organization assistant. Your ONLY task is to select the most appropriate folder for a bookmark from the user's existing folders. You MUST select ONE folder from the provided list - creating new folder names is STRICTLY FORBIDDEN. Analyze the webpage's title, URL, and content, then return ONLY a JSON response in the format {"folder_name": "ChosenFolderName"}. If multiple folders seem appropriate, choose the single best match. You CANNOT suggest a new folder name or return an empty result - you MUST select from the provided list only.`,
+			User: `Analyze the following webpage to choose a suitable folder:
+
+Bookmark title: {{.Title}}
+URL: {{.URL}}
+{{- if .MetaTitle}}
+Meta title: {{.MetaTitle}}{{end}}
+{{- if .MetaDescription}}
+Meta description: {{.MetaDescription}}{{end}}
+{{- if .OGTitle}}
+OG title: {{.OGTitle}}{{end}}
+{{- if .OGDescription}}
+OG description: {{.OGDescription}}{{end}}
+{{- if .Content}}
+
+Main content excerpt:
+{{.Content}}{{end}}
+
+The user's existing folders are: {{join .Folders ", "}}.
+
+IMPORTANT: You must select one folder from this list. Do not create new folder names and do not return an empty result. Pick the single most appropriate folder, even if the match seems weak.`,
+		},
+		aiPromptTags: {
+			System: `You are a professional bookmark tagging assistant. Your ONLY task is to select relevant tags from the user's existing tag collection. You MUST ONLY choose from the tags provided in the existing user tags list. DO NOT create new tags. If no existing tags are relevant, return an empty array in the format {"tags": []}. Return ONLY a JSON response in the format {"tags": ["tag1", "tag2"]}. Choose 2-3 tags maximum if relevant ones exist.`,
+			User: `Analyze the following webpage to choose relevant tags.
+
+Bookmark title: {{.Title}}
+URL: {{.URL}}
+{{- if .MetaTitle}}
+Meta title: {{.MetaTitle}}{{end}}
+{{- if .MetaDescription}}
+Meta description: {{.MetaDescription}}{{end}}
+{{- if .OGTitle}}
+OG title: {{.OGTitle}}{{end}}
+{{- if .OGDescription}}
+OG description: {{.OGDescription}}{{end}}
+{{- if .Content}}
+
+Main content excerpt:
+{{.Content}}{{end}}
+{{if .Tags}}
+CRITICAL INSTRUCTION: You must choose tags from the user's existing tags: {{join .Tags ", "}}. Do not create new tags. If none are relevant, return an empty array {"tags": []}. Choose at most 2-3 of the most relevant tags.
+{{- else}}
+No existing user tags were provided. Since you may only choose from existing tags, return an empty array {"tags": []}.
+{{- end}}`,
+		},
+		aiPromptDescription: {
+			System: `You are a professional bookmark assistant. Write a concise, neutral description of the webpage in English: one or two sentences, at most 40 words, saying what the page is about and why it may be useful. Do not start with "This page" or repeat the URL. Return ONLY a JSON response in the format {"description": "..."}.`,
+			User: `Describe the following webpage.
+
+Bookmark title: {{.Title}}
+URL: {{.URL}}
+{{- if .MetaTitle}}
+Meta title: {{.MetaTitle}}{{end}}
+{{- if .MetaDescription}}
+Meta description: {{.MetaDescription}}{{end}}
+{{- if .OGTitle}}
+OG title: {{.OGTitle}}{{end}}
+{{- if .OGDescription}}
+OG description: {{.OGDescription}}{{end}}
+{{- if .Content}}
+
+Main content excerpt:
+{{.Content}}{{end}}`,
+		},
+	},
+	"zh": {
+		aiPromptFolder: {
+			System: `你是一名专业的书签整理助手。你唯一的任务是从用户现有的文件夹中为书签选择最合适的一个。你必须从提供的列表中选择一个文件夹，严禁创建新的文件夹名称。分析网页的标题、URL 和内容，然后只返回格式为 {"folder_name": "所选文件夹名称"} 的 JSON。如果有多个文件夹都合适，选择最匹配的一个。不能建议新的文件夹名称，也不能返回空结果。`,
+			User: `分析以下网页信息以选择合适的文件夹：
+
+原始书签标题: {{.Title}}
+网页URL: {{.URL}}
+{{- if .MetaTitle}}
+网页Meta标题: {{.MetaTitle}}{{end}}
+{{- if .MetaDescription}}
+网页Meta描述: {{.MetaDescription}}{{end}}
+{{- if .OGTitle}}
+网页OG标题: {{.OGTitle}}{{end}}
+{{- if .OGDescription}}
+网页OG描述: {{.OGDescription}}{{end}}
+{{- if .Content}}
+
+网页主要内容摘要:
+{{.Content}}{{end}}
+
+这是用户现有的文件夹列表: {{join .Folders "、"}}。
+
+重要提示：您必须从此列表中选择一个文件夹。请勿创建新的文件夹名称。请勿返回空结果。请从列表中选择最合适的单个文件夹，即使相关性看起来一般。`,
+		},
+		aiPromptTags: {
+			System: `你是一名专业的书签标签助手。你唯一的任务是从用户现有的标签中选择相关的标签。你只能从提供的现有标签列表中选择，不要创建新标签。如果没有相关的标签，返回空数组 {"tags": []}。只返回格式为 {"tags": ["标签1", "标签2"]} 的 JSON。如果存在相关标签，最多选择 2-3 个。`,
+			User: `分析以下网页信息以生成相关标签。
+
+原始书签标题: {{.Title}}
+网页URL: {{.URL}}
+{{- if .MetaTitle}}
+网页Meta标题: {{.MetaTitle}}{{end}}
+{{- if .MetaDescription}}
+网页Meta描述: {{.MetaDescription}}{{end}}
+{{- if .OGTitle}}
+网页OG标题: {{.OGTitle}}{{end}}
+{{- if .OGDescription}}
+网页OG描述: {{.OGDescription}}{{end}}
+{{- if .Content}}
+
+网页主要内容摘要:
+{{.Content}}{{end}}
+{{if .Tags}}
+重要提示：您必须从用户现有的标签列表 {{join .Tags "、"}} 中选择标签。请勿创建任何新标签。如果没有相关的标签，请返回空数组 {"tags": []}。最多选择2-3个最相关的标签。
+{{- else}}
+未提供现有用户标签。由于您只能从现有标签中选择，且没有提供标签，请返回空数组 {"tags": []}。
+{{- end}}`,
+		},
+		aiPromptDescription: {
+			System: `你是一名专业的书签助手。请用简体中文为网页写一段简洁、客观的描述：一到两句话，不超过 80 个字，说明网页的主要内容和用途。不要以“本页面”开头，也不要重复 URL。只返回格式为 {"description": "..."} 的 JSON。`,
+			User: `请描述以下网页。
+
+原始书签标题: {{.Title}}
+网页URL: {{.URL}}
+{{- if .MetaTitle}}
+网页Meta标题: {{.MetaTitle}}{{end}}
+{{- if .MetaDescription}}
+网页Meta描述: {{.MetaDescription}}{{end}}
+{{- if .OGTitle}}
+网页OG标题: {{.OGTitle}}{{end}}
+{{- if .OGDescription}}
+网页OG描述: {{.OGDescription}}{{end}}
+{{- if .Content}}
+
+网页主要内容摘要:
+{{.Content}}{{end}}`,
+		},
+	},
+}
+
+// aiPromptLanguage maps user_settings.language to a language with default templates.
+func aiPromptLanguage(language string) string {
+	if strings.HasPrefix(strings.ToLower(language), "zh") {
+		return "zh"
+	}
+	return "en"
+}
+
+func isAIPromptKind(kind string) bool {
+	_, ok := aiPromptContentLimits[kind]
+	return ok
+}
+
+// loadAIPromptTemplates reads the custom templates of a user. userSettings may be nil.
+func loadAIPromptTemplates(userSettings *core.Record) (map[string]AIPromptTemplate, error) {
+	var templates map[string]AIPromptTemplate
+	if userSettings == nil {
+		return templates, nil
+	}
+	if _, err := decodeJSONField(userSettings, "aiPromptTemplates", &templates); err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+// executeAIPromptTemplate renders one template with data.
+func executeAIPromptTemplate(name string, text string, data AIPromptData) (string, error) {
+	tmpl, err := template.New(name).Funcs(aiPromptFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// renderAIPromptTemplate renders custom over the language defaults of kind. It returns whether
+// a custom template was used.
+func renderAIPromptTemplate(kind string, custom AIPromptTemplate, data AIPromptData) (AIPromptTemplate, bool, error) {
+	defaults := defaultAIPromptTemplates[aiPromptLanguage(data.Language)][kind]
+	systemText, userText := defaults.System, defaults.User
+	if custom.System != "" {
+		systemText = custom.System
+	}
+	if custom.User != "" {
+		userText = custom.User
+	}
+
+	var rendered AIPromptTemplate
+	var err error
+	if rendered.System, err = executeAIPromptTemplate(kind+".system", systemText, data); err != nil {
+		return rendered, false, fmt.Errorf("invalid %s system template: %w", kind, err)
+	}
+	if rendered.User, err = executeAIPromptTemplate(kind+".user", userText, data); err != nil {
+		return rendered, false, fmt.Errorf("invalid %s user template: %w", kind, err)
+	}
+	return rendered, custom.System != "" || custom.User != "", nil
+}
+
+// renderAIPrompt renders the system and user prompts of kind for a user, falling back to the
+// default templates when the user's templates fail to render.
+func renderAIPrompt(userSettings *core.Record, kind string, data AIPromptData) AIPromptTemplate {
+	if userSettings != nil {
+		data.Language = userSettings.GetString("language")
+	}
+
+	templates, err := loadAIPromptTemplates(userSettings)
+	if err != nil {
+		log.Printf("Error reading aiPromptTemplates, using default prompts: %v", err)
+	}
+	rendered, _, err := renderAIPromptTemplate(kind, templates[kind], data)
+	if err != nil {
+		log.Printf("Error rendering custom %s prompt, using default prompts: %v", kind, err)
+		rendered, _, _ = renderAIPromptTemplate(kind, AIPromptTemplate{}, data)
+	}
+	return rendered
+}
+
+// sampleAIPromptData is used to validate templates when they are saved.
+var sampleAIPromptData = AIPromptData{
+	Title:           "Example",
+	URL:             "https://example.com/",
+	MetaTitle:       "Example Domain",
+	MetaDescription: "Example description",
+	OGTitle:         "Example Domain",
+	OGDescription:   "Example description",
+	Content:         "Example content",
+	Folders:         []string{"Work", "Reading"},
+	Tags:            []string{"go", "news"},
+}
+
+// validateAIPromptTemplates checks that every custom template is known, not too long and renders.
+func validateAIPromptTemplates(templates map[string]AIPromptTemplate) error {
+	for kind, custom := range templates {
+		if !isAIPromptKind(kind) {
+			return fmt.Errorf("unknown prompt template %q", kind)
+		}
+		if len(custom.System) > maxAIPromptTemplateLength || len(custom.User) > maxAIPromptTemplateLength {
+			return fmt.Errorf("%s prompt template is longer than %d characters", kind, maxAIPromptTemplateLength)
+		}
+		if _, _, err := renderAIPromptTemplate(kind, custom, sampleAIPromptData); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// registerAIPromptTemplateHooks rejects user_settings updates with invalid prompt templates.
+func registerAIPromptTemplateHooks(app *pocketbase.PocketBase) {
+	validateTemplates := func(e *core.RecordRequestEvent) error {
+		templates, err := loadAIPromptTemplates(e.Record)
+		if err != nil {
+			return e.BadRequestError("Invalid aiPromptTemplates.", err)
+		}
+		if err := validateAIPromptTemplates(templates); err != nil {
+			return e.BadRequestError(fmt.Sprintf("Invalid aiPromptTemplates: %v", err), nil)
+		}
+		return e.Next()
+	}
+	app.OnRecordCreateRequest("user_settings").BindFunc(validateTemplates)
+	app.OnRecordUpdateRequest("user_settings").BindFunc(validateTemplates)
+}
+
+// aiPromptPreviewHandler renders the prompts that would be sent to the model, without calling it.
+// API Endpoint: POST /api/custom/ai/prompt-preview
+// Request: { "kind": "folder"|"tags"|"description", "title", "url", "fetchPage": bool, "existingUserTags": [], "template": { "system", "user" } }
+// "template" previews unsaved templates; otherwise the saved ones are used.
+// Response: { "success": true, "kind", "language", "custom": bool, "system", "user" }
+func aiPromptPreviewHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		authRecord := e.Auth
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("User not authenticated.", nil)
+		}
+		userId := authRecord.Id
+
+		var requestData struct {
+			Kind             string            `json:"kind"`
+			Title            string            `json:"title"`
+			URL              string            `json:"url"`
+			FetchPage        bool              `json:"fetchPage"`
+			ExistingUserTags []string          `json:"existingUserTags"`
+			Template         *AIPromptTemplate `json:"template"`
+		}
+		if err := e.BindBody(&requestData); err != nil {
+			return e.BadRequestError("Failed to parse request data.", err)
+		}
+		if !isAIPromptKind(requestData.Kind) {
+			return e.BadRequestError(fmt.Sprintf("Unknown prompt kind: %q (expected %q, %q or %q).", requestData.Kind, aiPromptFolder, aiPromptTags, aiPromptDescription), nil)
+		}
+
+		userSettings, err := app.FindFirstRecordByFilter(
+			"user_settings",
+			"userId = {:userId}",
+			dbx.Params{"userId": userId},
+		)
+		if err != nil {
+			return e.NotFoundError("User settings not found.", err)
+		}
+
+		var pageData PageData
+		if requestData.FetchPage && requestData.URL != "" {
+			pageData, err = fetchPageContent(requestData.URL, app, userSettings)
+			if err != nil {
+				log.Printf("Prompt preview: failed to fetch page content for URL %s: %v", requestData.URL, err)
+			}
+		}
+
+		// 文件夹和标签来自所选空间（个人或工作区）
+		space, err := resolveSpaceContext(app, e)
+		if err != nil {
+			return err
+		}
+
+		data := newAIPromptData(requestData.Kind, requestData.Title, requestData.URL, pageData)
+		data.Language = userSettings.GetString("language")
+		switch requestData.Kind {
+		case aiPromptFolder:
+			folderFilter, folderParams := space.recordFilter()
+			folderRecords, err := app.FindRecordsByFilter("folders", folderFilter, "name", 0, 0, folderParams)
+			if err != nil {
+				return e.InternalServerError("Failed to fetch user folders.", err)
+			}
+			for _, record := range folderRecords {
+				data.Folders = append(data.Folders, record.GetString("name"))
+			}
+		case aiPromptTags:
+			data.Tags = requestData.ExistingUserTags
+			if data.Tags == nil {
+				if tagRecord, err := space.tagListRecord(app); err == nil {
+					data.Tags = tagRecord.GetStringSlice("tagList")
+				}
+			}
+		}
+
+		custom := AIPromptTemplate{}
+		if requestData.Template != nil {
+			custom = *requestData.Template
+			if err := validateAIPromptTemplates(map[string]AIPromptTemplate{requestData.Kind: custom}); err != nil {
+				return e.BadRequestError(err.Error(), nil)
+			}
+		} else {
+			templates, err := loadAIPromptTemplates(userSettings)
+			if err != nil {
+				return e.InternalServerError("Failed to read prompt templates.", err)
+			}
+			custom = templates[requestData.Kind]
+		}
+
+		rendered, isCustom, err := renderAIPromptTemplate(requestData.Kind, custom, data)
+		if err != nil {
+			return e.BadRequestError(err.Error(), nil)
+		}
+
+		return e.JSON(http.StatusOK, map[string]interface{}{
+			"success":  true,
+			"kind":     requestData.Kind,
+			"language": aiPromptLanguage(data.Language),
+			"custom":   isCustom,
+			"system":   rendered.System,
+			"user":     rendered.User,
+		})
+	}
+}
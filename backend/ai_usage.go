@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// 每次 AI 调用都记录到 ai_usage 集合：功能、模型、token 用量（来自响应中的 usage 字段）、耗时和是否成功。
+// 每日限额（见 ai_config.go）也从这些记录统计。
+
+// AI features recorded in ai_usage.feature.
+const (
+	aiFeatureSuggestFolder = "suggest_folder"
+	aiFeatureSuggestTags   = "suggest_tags"
+	aiFeatureAutoTag       = "auto_tag"
+)
+
+// maxAIResponseSize limits the chat completion response read from the provider.
+const maxAIResponseSize = 10 << 20
+
+// maxAIUsageErrorLength limits the error text stored with a failed call.
+const maxAIUsageErrorLength = 500
+
+// AIUsage is the token usage block of an OpenAI-compatible chat completion response.
+type AIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// callAIChatCompletion posts requestBody to the chat/completions endpoint of config and records
+// the call in ai_usage. It returns the response body and status code; err is only set when no
+// response could be read. Non-200 responses are returned to the caller as-is.
+func callAIChatCompletion(app core.App, userId string, feature string, config AIConfig, requestBody []byte, timeout time.Duration) ([]byte, int, error) {
+	finalApiUrl := config.BaseURL
+	if !strings.HasSuffix(finalApiUrl, "/") {
+		finalApiUrl += "/"
+	}
+	req, err := http.NewRequest("POST", finalApiUrl+"chat/completions", bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create AI HTTP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+config.APIKey)
+
+	// 用户自己配置的 API 地址按外部地址处理，不允许访问内网；管理员配置的共享地址不受限制
+	client := &http.Client{Timeout: timeout}
+	if !config.Shared {
+		client = newOutboundHTTPClient(timeout)
+	}
+	startTime := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		recordAIUsage(app, userId, feature, config, AIUsage{}, time.Since(startTime), err)
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	responseBodyBytes, err := readLimitedBody(resp.Body, maxAIResponseSize)
+	latency := time.Since(startTime)
+	if err != nil {
+		recordAIUsage(app, userId, feature, config, AIUsage{}, latency, err)
+		return nil, resp.StatusCode, err
+	}
+
+	// 即使请求失败，提供商也可能已经计费，因此尽量解析 usage
+	var usageResponse struct {
+		Usage AIUsage `json:"usage"`
+	}
+	json.Unmarshal(responseBodyBytes, &usageResponse)
+	usage := usageResponse.Usage
+	if usage.TotalTokens == 0 {
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+
+	var callErr error
+	if resp.StatusCode != http.StatusOK {
+		callErr = fmt.Errorf("AI API returned status %d: %s", resp.StatusCode, string(responseBodyBytes))
+	}
+	recordAIUsage(app, userId, feature, config, usage, latency, callErr)
+
+	return responseBodyBytes, resp.StatusCode, nil
+}
+
+// recordAIUsage saves one ai_usage record. Failures are only logged so that they never
+// break the AI feature itself.
+func recordAIUsage(app core.App, userId string, feature string, config AIConfig, usage AIUsage, latency time.Duration, callErr error) {
+	collection, err := app.FindCollectionByNameOrId("ai_usage")
+	if err != nil {
+		log.Printf("Error finding ai_usage collection: %v", err)
+		return
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("userId", userId)
+	record.Set("feature", feature)
+	record.Set("model", config.Model)
+	record.Set("promptTokens", usage.PromptTokens)
+	record.Set("completionTokens", usage.CompletionTokens)
+	record.Set("totalTokens", usage.TotalTokens)
+	record.Set("latencyMs", latency.Milliseconds())
+	record.Set("success", callErr == nil)
+	record.Set("shared", config.Shared)
+	if callErr != nil {
+		errorText := callErr.Error()
+		if len(errorText) > maxAIUsageErrorLength {
+			errorText = errorText[:maxAIUsageErrorLength]
+		}
+		record.Set("error", errorText)
+	}
+
+	if err := app.Save(record); err != nil {
+		log.Printf("Error saving AI usage for user %s: %v", userId, err)
+	}
+}
+
+// AIUsageTotals sums the AI usage of a user over a period.
+type AIUsageTotals struct {
+	Requests         int `db:"requests" json:"requests"`
+	SharedRequests   int `db:"sharedRequests" json:"sharedRequests"`
+	PromptTokens     int `db:"promptTokens" json:"promptTokens"`
+	CompletionTokens int `db:"completionTokens" json:"completionTokens"`
+	TotalTokens      int `db:"totalTokens" json:"totalTokens"`
+}
+
+// aiUsageDayStart returns the ai_usage.createdAt value at the start of the UTC day of t.
+func aiUsageDayStart(t time.Time) string {
+	return t.UTC().Format("2006-01-02") + " 00:00:00.000Z"
+}
+
+// loadAIUsageToday sums the AI usage of userId for the current UTC day.
+func loadAIUsageToday(app core.App, userId string) (AIUsageTotals, error) {
+	var totals AIUsageTotals
+	err := app.DB().NewQuery(`
+		SELECT
+			COUNT(*) AS requests,
+			COALESCE(SUM(CASE WHEN shared THEN 1 ELSE 0 END), 0) AS sharedRequests,
+			COALESCE(SUM(promptTokens), 0) AS promptTokens,
+			COALESCE(SUM(completionTokens), 0) AS completionTokens,
+			COALESCE(SUM(totalTokens), 0) AS totalTokens
+		FROM ai_usage
+		WHERE userId = {:userId} AND createdAt >= {:since}
+	`).Bind(dbx.Params{"userId": userId, "since": aiUsageDayStart(time.Now())}).One(&totals)
+	return totals, err
+}
+
+// AIUsageDay sums the AI usage of a user for one day and feature.
+type AIUsageDay struct {
+	Date             string  `db:"date" json:"date"`
+	Feature          string  `db:"feature" json:"feature"`
+	Requests         int     `db:"requests" json:"requests"`
+	Failed           int     `db:"failed" json:"failed"`
+	PromptTokens     int     `db:"promptTokens" json:"promptTokens"`
+	CompletionTokens int     `db:"completionTokens" json:"completionTokens"`
+	TotalTokens      int     `db:"totalTokens" json:"totalTokens"`
+	AvgLatencyMs     float64 `db:"avgLatencyMs" json:"avgLatencyMs"`
+}
+
+const (
+	defaultAIUsageSummaryDays = 7
+	maxAIUsageSummaryDays     = 90
+)
+
+// aiUsageSummaryHandler returns the AI usage of the authenticated user.
+// API Endpoint: GET /api/custom/ai/usage?days=7
+// Response: { "success": true, "days": 7, "today": {...}, "limits": {...}, "daily": [{ "date", "feature", ... }] }
+func aiUsageSummaryHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		authRecord := e.Auth
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("User not authenticated.", nil)
+		}
+		userId := authRecord.Id
+
+		days := defaultAIUsageSummaryDays
+		if v := e.Request.URL.Query().Get("days"); v != "" {
+			parsed, err := strconv.Atoi(v)
+			if err != nil || parsed < 1 || parsed > maxAIUsageSummaryDays {
+				return e.BadRequestError(fmt.Sprintf("days must be between 1 and %d.", maxAIUsageSummaryDays), err)
+			}
+			days = parsed
+		}
+
+		today, err := loadAIUsageToday(app, userId)
+		if err != nil {
+			return e.InternalServerError("Failed to load AI usage.", err)
+		}
+
+		daily := []AIUsageDay{}
+		since := aiUsageDayStart(time.Now().AddDate(0, 0, -(days - 1)))
+		err = app.DB().NewQuery(`
+			SELECT
+				substr(createdAt, 1, 10) AS date,
+				feature,
+				COUNT(*) AS requests,
+				COALESCE(SUM(CASE WHEN success THEN 0 ELSE 1 END), 0) AS failed,
+				COALESCE(SUM(promptTokens), 0) AS promptTokens,
+				COALESCE(SUM(completionTokens), 0) AS completionTokens,
+				COALESCE(SUM(totalTokens), 0) AS totalTokens,
+				COALESCE(AVG(latencyMs), 0) AS avgLatencyMs
+			FROM ai_usage
+			WHERE userId = {:userId} AND createdAt >= {:since}
+			GROUP BY date, feature
+			ORDER BY date DESC, feature
+		`).Bind(dbx.Params{"userId": userId, "since": since}).All(&daily)
+		if err != nil {
+			return e.InternalServerError("Failed to load AI usage.", err)
+		}
+
+		instance := loadInstanceAISettings(app)
+		limits := instance.limitsFor(userId)
+
+		return e.JSON(http.StatusOK, map[string]interface{}{
+			"success": true,
+			"days":    days,
+			"today":   today,
+			"limits": map[string]interface{}{
+				"requests":       limits.Requests,
+				"tokens":         limits.Tokens,
+				"sharedRequests": instance.sharedLimitFor(userId),
+			},
+			"daily": daily,
+		})
+	}
+}
@@ -0,0 +1,513 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// bookmarks、folders 和 user_settings 的每次新增、修改和删除都记录到 audit_logs 集合：
+// 操作者、来源和字段的前后值。操作者和来源按以下顺序确定：
+//   1. 服务端代码通过 SaveWithContext/DeleteWithContext 传入的 auditContext（恢复、AI、批量删除标签等）
+//   2. PocketBase 记录接口的请求：当前登录用户，来源由 X-Client-Source 请求头决定（扩展发送 "extension"）
+//   3. 删除文件夹时级联删除的子文件夹和书签，沿用父文件夹的操作者和来源，并记录 cascadeFrom
+// 其余情况（后台任务、迁移等）记为 system。
+
+// Sources recorded in audit_logs.source.
+const (
+	auditSourceAPI       = "api"
+	auditSourceExtension = "extension"
+	auditSourceAdmin     = "admin"
+	auditSourceRestore   = "restore"
+	auditSourceAI        = "ai"
+	auditSourceTags      = "tags"
+	auditSourceSystem    = "system"
+)
+
+// Actions recorded in audit_logs.action.
+const (
+	auditActionCreate = "create"
+	auditActionUpdate = "update"
+	auditActionDelete = "delete"
+)
+
+// clientSourceHeader lets clients identify themselves; the browser extension sends "extension".
+const clientSourceHeader = "X-Client-Source"
+
+// auditedCollections lists the collections whose changes are recorded.
+var auditedCollections = []string{"bookmarks", "folders", "user_settings"}
+
+// defaultAuditLogRetentionDays is used when AUDIT_LOG_RETENTION_DAYS is not set.
+const defaultAuditLogRetentionDays = 90
+
+// maxAuditValueLength limits the length of a single string value stored in a diff.
+const maxAuditValueLength = 2000
+
+const (
+	defaultAuditLogsPerPage = 50
+	maxAuditLogsPerPage     = 200
+)
+
+// auditActor describes who changed a record and through which path.
+type auditActor struct {
+	actorId     string
+	source      string
+	cascadeFrom string
+}
+
+type auditContextKey struct{}
+
+// withAuditActor returns a context attributing the changes saved with it to actorId and source.
+func withAuditActor(ctx context.Context, actorId string, source string) context.Context {
+	return context.WithValue(ctx, auditContextKey{}, auditActor{actorId: actorId, source: source})
+}
+
+// auditContext attributes changes made by a custom handler to the authenticated user of e.
+// An empty source uses the source of the request (api, extension or admin).
+// The context is not derived from the request so that a client disconnect does not abort the writes.
+func auditContext(e *core.RequestEvent, source string) context.Context {
+	if source == "" {
+		source = requestAuditSource(e)
+	}
+	actorId := ""
+	if e.Auth != nil {
+		actorId = e.Auth.Id
+	}
+	return withAuditActor(context.Background(), actorId, source)
+}
+
+// requestAuditSource returns the source of changes made through the request e.
+func requestAuditSource(e *core.RequestEvent) string {
+	if e.Auth != nil && e.Auth.IsSuperuser() {
+		return auditSourceAdmin
+	}
+	if strings.EqualFold(strings.TrimSpace(e.Request.Header.Get(clientSourceHeader)), auditSourceExtension) {
+		return auditSourceExtension
+	}
+	return auditSourceAPI
+}
+
+var (
+	// pendingAuditActors holds the actor of records saved through the record API (and of cascaded
+	// deletes) until their after-success hook runs; the key is the *core.Record being saved.
+	pendingAuditActors sync.Map
+	// deletingAuditFolders holds the actor of folders whose delete (and cascade) is in progress, by id.
+	deletingAuditFolders sync.Map
+)
+
+// auditActorOf returns the actor of the change in e and forgets the pending entry of the record.
+func auditActorOf(e *core.RecordEvent) auditActor {
+	pending, hasPending := pendingAuditActors.LoadAndDelete(e.Record)
+	if e.Context != nil {
+		if actor, ok := e.Context.Value(auditContextKey{}).(auditActor); ok {
+			return actor
+		}
+	}
+	if hasPending {
+		return pending.(auditActor)
+	}
+	return auditActor{source: auditSourceSystem}
+}
+
+// peekAuditActor is auditActorOf without forgetting the pending entry.
+func peekAuditActor(e *core.RecordEvent) (auditActor, bool) {
+	if e.Context != nil {
+		if actor, ok := e.Context.Value(auditContextKey{}).(auditActor); ok {
+			return actor, true
+		}
+	}
+	if pending, ok := pendingAuditActors.Load(e.Record); ok {
+		return pending.(auditActor), true
+	}
+	return auditActor{}, false
+}
+
+// auditValue returns the JSON form of a field value, with secrets masked and long strings truncated.
+// raw is the unmasked JSON used to detect changes.
+func auditValue(record *core.Record, field core.Field) (value interface{}, raw string) {
+	name := field.GetName()
+	rawBytes, err := json.Marshal(record.Get(name))
+	if err != nil {
+		return nil, ""
+	}
+	json.Unmarshal(rawBytes, &value)
+
+	if field.GetHidden() || (record.Collection().Name == "user_settings" && isUserSettingsSecretField(name)) {
+		if isZeroAuditValue(value) {
+			return nil, string(rawBytes)
+		}
+		return secretMask, string(rawBytes)
+	}
+	if secretKeys, ok := secretJSONFields[name]; ok && record.Collection().Name == "user_settings" {
+		if config, isMap := value.(map[string]interface{}); isMap {
+			for _, secretKey := range secretKeys {
+				if secretStr, _ := config[secretKey].(string); secretStr != "" {
+					config[secretKey] = secretMask
+				}
+			}
+		}
+	}
+	if str, ok := value.(string); ok && len(str) > maxAuditValueLength {
+		value = str[:maxAuditValueLength] + "…"
+	}
+	return value, string(rawBytes)
+}
+
+func isUserSettingsSecretField(name string) bool {
+	for _, field := range userSettingsSecretFields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+func isZeroAuditValue(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case bool:
+		return !v
+	case float64:
+		return v == 0
+	case []interface{}:
+		return len(v) == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+// auditChanges returns the {"<field>": {"before": ..., "after": ...}} diff of record for action.
+// New and deleted records list their non-empty fields; timestamps are never included.
+func auditChanges(record *core.Record, action string) map[string]map[string]interface{} {
+	original := record.Original()
+	changes := map[string]map[string]interface{}{}
+
+	for _, field := range record.Collection().Fields {
+		name := field.GetName()
+		if name == core.FieldNameId || name == "createdAt" || name == "updatedAt" {
+			continue
+		}
+
+		switch action {
+		case auditActionCreate:
+			if after, _ := auditValue(record, field); !isZeroAuditValue(after) {
+				changes[name] = map[string]interface{}{"before": nil, "after": after}
+			}
+		case auditActionDelete:
+			if before, _ := auditValue(original, field); !isZeroAuditValue(before) {
+				changes[name] = map[string]interface{}{"before": before, "after": nil}
+			}
+		default:
+			before, beforeRaw := auditValue(original, field)
+			after, afterRaw := auditValue(record, field)
+			if beforeRaw == afterRaw || (isZeroAuditValue(before) && isZeroAuditValue(after)) {
+				continue
+			}
+			changes[name] = map[string]interface{}{"before": before, "after": after}
+		}
+	}
+
+	return changes
+}
+
+// recordAuditLog saves the audit_logs entry for the change in e. Failures are only logged so that
+// they never break the change itself.
+func recordAuditLog(e *core.RecordEvent, action string) {
+	actor := auditActorOf(e)
+
+	ownerId := e.Record.GetString("userId")
+	if ownerId == "" {
+		return
+	}
+	workspaceId := e.Record.GetString("workspaceId")
+
+	// 删除用户或工作区时级联删除的记录不再需要日志
+	if action == auditActionDelete {
+		if _, err := e.App.FindRecordById("users", ownerId); err != nil {
+			return
+		}
+		if workspaceId != "" {
+			if _, err := e.App.FindRecordById("workspaces", workspaceId); err != nil {
+				return
+			}
+		}
+	}
+
+	changes := auditChanges(e.Record, action)
+	if action == auditActionUpdate && len(changes) == 0 {
+		return
+	}
+
+	collection, err := e.App.FindCachedCollectionByNameOrId("audit_logs")
+	if err != nil {
+		log.Printf("Error finding audit_logs collection: %v", err)
+		return
+	}
+
+	entry := core.NewRecord(collection)
+	entry.Set("userId", ownerId)
+	entry.Set("workspaceId", workspaceId)
+	entry.Set("actorId", actor.actorId)
+	entry.Set("collection", e.Record.Collection().Name)
+	entry.Set("recordId", e.Record.Id)
+	entry.Set("action", action)
+	entry.Set("source", actor.source)
+	entry.Set("cascadeFrom", actor.cascadeFrom)
+	entry.Set("changes", changes)
+
+	if err := e.App.Save(entry); err != nil {
+		log.Printf("Error saving audit log for %s/%s: %v", e.Record.Collection().Name, e.Record.Id, err)
+	}
+}
+
+// auditLogRetentionDays returns how long audit logs are kept; 0 keeps them forever.
+func auditLogRetentionDays() int {
+	value := strings.TrimSpace(os.Getenv("AUDIT_LOG_RETENTION_DAYS"))
+	if value == "" {
+		return defaultAuditLogRetentionDays
+	}
+	days, err := strconv.Atoi(value)
+	if err != nil || days < 0 {
+		log.Printf("Warning: invalid AUDIT_LOG_RETENTION_DAYS %q, using %d", value, defaultAuditLogRetentionDays)
+		return defaultAuditLogRetentionDays
+	}
+	return days
+}
+
+// pruneAuditLogs deletes the audit logs older than the retention period.
+func pruneAuditLogs(app core.App) {
+	days := auditLogRetentionDays()
+	if days == 0 {
+		return
+	}
+	before := types.NowDateTime().AddDate(0, 0, -days).String()
+	result, err := app.DB().NewQuery("DELETE FROM {{audit_logs}} WHERE [[createdAt]] < {:before}").
+		Bind(dbx.Params{"before": before}).Execute()
+	if err != nil {
+		log.Printf("[AuditLog] Failed to prune audit logs: %v", err)
+		return
+	}
+	if deleted, _ := result.RowsAffected(); deleted > 0 {
+		log.Printf("[AuditLog] Pruned %d audit logs older than %d days", deleted, days)
+	}
+}
+
+// registerAuditLogHooks records the changes of the audited collections and prunes old logs daily.
+func registerAuditLogHooks(app *pocketbase.PocketBase) {
+	// 记录接口的请求：保存期间记住当前用户和来源
+	trackRequest := func(e *core.RecordRequestEvent) error {
+		actor := auditActor{source: requestAuditSource(e.RequestEvent)}
+		if e.Auth != nil {
+			actor.actorId = e.Auth.Id
+		}
+		pendingAuditActors.Store(e.Record, actor)
+		defer pendingAuditActors.Delete(e.Record)
+		return e.Next()
+	}
+	app.OnRecordCreateRequest(auditedCollections...).BindFunc(trackRequest)
+	app.OnRecordUpdateRequest(auditedCollections...).BindFunc(trackRequest)
+	app.OnRecordDeleteRequest(auditedCollections...).BindFunc(trackRequest)
+
+	// 级联：删除文件夹时，子文件夹和书签在其删除过程中被删除或解除关联，沿用父文件夹的操作者
+	trackCascade := func(e *core.RecordEvent) error {
+		actor, ok := peekAuditActor(e)
+		if !ok {
+			parentField := "folderId"
+			if e.Record.Collection().Name == "folders" {
+				parentField = "parentId"
+			}
+			if parentId := e.Record.Original().GetString(parentField); parentId != "" {
+				if parent, deleting := deletingAuditFolders.Load(parentId); deleting {
+					actor = parent.(auditActor)
+					actor.cascadeFrom = "folders/" + parentId
+					ok = true
+					pendingAuditActors.Store(e.Record, actor)
+				}
+			}
+		}
+		if !ok || e.Type != core.ModelEventTypeDelete || e.Record.Collection().Name != "folders" {
+			return e.Next()
+		}
+		deletingAuditFolders.Store(e.Record.Id, actor)
+		defer deletingAuditFolders.Delete(e.Record.Id)
+		return e.Next()
+	}
+	app.OnRecordUpdate("folders", "bookmarks").BindFunc(trackCascade)
+	app.OnRecordDelete("folders", "bookmarks").BindFunc(trackCascade)
+
+	// 其他 after-success 钩子可能还要读取 Original()，因此先执行它们，再记录日志并更新原始值，
+	// 使同一个记录对象再次保存时的差异是相对于这次保存的
+	app.OnRecordAfterCreateSuccess(auditedCollections...).BindFunc(func(e *core.RecordEvent) error {
+		err := e.Next()
+		recordAuditLog(e, auditActionCreate)
+		e.Record.PostScan()
+		return err
+	})
+	app.OnRecordAfterUpdateSuccess(auditedCollections...).BindFunc(func(e *core.RecordEvent) error {
+		err := e.Next()
+		recordAuditLog(e, auditActionUpdate)
+		e.Record.PostScan()
+		return err
+	})
+	app.OnRecordAfterDeleteSuccess(auditedCollections...).BindFunc(func(e *core.RecordEvent) error {
+		err := e.Next()
+		recordAuditLog(e, auditActionDelete)
+		return err
+	})
+
+	// 失败的保存不会触发 after-success 钩子，清除记住的操作者
+	forgetActor := func(e *core.RecordErrorEvent) error {
+		pendingAuditActors.Delete(e.Record)
+		return e.Next()
+	}
+	app.OnRecordAfterCreateError(auditedCollections...).BindFunc(forgetActor)
+	app.OnRecordAfterUpdateError(auditedCollections...).BindFunc(forgetActor)
+	app.OnRecordAfterDeleteError(auditedCollections...).BindFunc(forgetActor)
+
+	app.Cron().MustAdd("pruneAuditLogs", "30 3 * * *", func() {
+		pruneAuditLogs(app)
+	})
+}
+
+// auditLogResponse converts an audit_logs record for the API response.
+func auditLogResponse(record *core.Record, actorEmails map[string]string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          record.Id,
+		"collection":  record.GetString("collection"),
+		"recordId":    record.GetString("recordId"),
+		"action":      record.GetString("action"),
+		"source":      record.GetString("source"),
+		"actorId":     record.GetString("actorId"),
+		"actorEmail":  actorEmails[record.GetString("actorId")],
+		"workspaceId": record.GetString("workspaceId"),
+		"cascadeFrom": record.GetString("cascadeFrom"),
+		"changes":     record.Get("changes"),
+		"createdAt":   record.GetDateTime("createdAt"),
+	}
+}
+
+// auditLogsHandler lists the audit log of the current space, newest first. Workspace logs
+// (X-Workspace-Id header or workspaceId query parameter) are visible to workspace owners and admins.
+// API Endpoint: GET /api/custom/audit-logs?collection=bookmarks&recordId=...&action=delete&source=extension&actorId=...&from=2024-01-01&to=2024-02-01&page=1&perPage=50
+// Response: { "success": true, "page": 1, "perPage": 50, "totalItems": 123, "items": [{ "id", "collection", "recordId", "action", "source", "actorId", "actorEmail", "cascadeFrom", "changes", "createdAt" }] }
+func auditLogsHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		authRecord := e.Auth
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("User not authenticated.", nil)
+		}
+
+		space, err := resolveSpaceContext(app, e)
+		if err != nil {
+			return err
+		}
+		if space.isWorkspace() && !space.canManage() {
+			return apis.NewForbiddenError("Only workspace owners and admins can view the audit log.", nil)
+		}
+
+		query := e.Request.URL.Query()
+		var exprs []dbx.Expression
+		if space.isWorkspace() {
+			exprs = append(exprs, dbx.HashExp{"workspaceId": space.workspaceId})
+		} else {
+			exprs = append(exprs, dbx.HashExp{"userId": space.userId, "workspaceId": ""})
+		}
+		for _, name := range []string{"collection", "recordId", "action", "source", "actorId"} {
+			if value := strings.TrimSpace(query.Get(name)); value != "" {
+				exprs = append(exprs, dbx.HashExp{name: value})
+			}
+		}
+		for _, bound := range []struct{ param, op string }{{"from", ">="}, {"to", "<"}} {
+			value := strings.TrimSpace(query.Get(bound.param))
+			if value == "" {
+				continue
+			}
+			date, err := types.ParseDateTime(value)
+			if err != nil || date.IsZero() {
+				return e.BadRequestError("Invalid "+bound.param+" date, expected e.g. 2024-01-31 or 2024-01-31T12:00:00Z.", err)
+			}
+			exprs = append(exprs, dbx.NewExp("[[createdAt]] "+bound.op+" {:"+bound.param+"}", dbx.Params{bound.param: date.String()}))
+		}
+
+		page := 1
+		if v := query.Get("page"); v != "" {
+			parsed, err := strconv.Atoi(v)
+			if err != nil || parsed < 1 {
+				return e.BadRequestError("page must be a positive number.", err)
+			}
+			page = parsed
+		}
+		perPage := defaultAuditLogsPerPage
+		if v := query.Get("perPage"); v != "" {
+			parsed, err := strconv.Atoi(v)
+			if err != nil || parsed < 1 || parsed > maxAuditLogsPerPage {
+				return e.BadRequestError("perPage must be between 1 and "+strconv.Itoa(maxAuditLogsPerPage)+".", err)
+			}
+			perPage = parsed
+		}
+
+		totalItems, err := app.CountRecords("audit_logs", exprs...)
+		if err != nil {
+			return e.InternalServerError("Failed to count audit logs.", err)
+		}
+
+		var records []*core.Record
+		err = app.RecordQuery("audit_logs").
+			AndWhere(dbx.And(exprs...)).
+			OrderBy("createdAt DESC", "id DESC").
+			Limit(int64(perPage)).
+			Offset(int64((page - 1) * perPage)).
+			All(&records)
+		if err != nil {
+			return e.InternalServerError("Failed to load audit logs.", err)
+		}
+
+		// 操作者可能是共享文件夹的编辑者或工作区成员，返回邮箱便于识别
+		actorEmails := map[string]string{}
+		var actorIds []string
+		for _, record := range records {
+			if actorId := record.GetString("actorId"); actorId != "" {
+				if _, seen := actorEmails[actorId]; !seen {
+					actorEmails[actorId] = ""
+					actorIds = append(actorIds, actorId)
+				}
+			}
+		}
+		if len(actorIds) > 0 {
+			users, err := app.FindRecordsByIds("users", actorIds)
+			if err == nil {
+				for _, user := range users {
+					actorEmails[user.Id] = user.Email()
+				}
+			}
+		}
+
+		items := make([]map[string]interface{}, 0, len(records))
+		for _, record := range records {
+			items = append(items, auditLogResponse(record, actorEmails))
+		}
+
+		return e.JSON(http.StatusOK, map[string]interface{}{
+			"success":    true,
+			"page":       page,
+			"perPage":    perPage,
+			"totalItems": totalItems,
+			"items":      items,
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// 备份压缩格式。压缩在加密之前进行（密文无法压缩），恢复时按相反顺序处理。
+const (
+	backupCompressionNone = ""
+	backupCompressionGzip = "gzip"
+	backupCompressionZip  = "zip"
+
+	gzipBackupExtension = ".gz"
+	zipBackupExtension  = ".zip"
+
+	// maxDecompressedBackupSize guards restore against decompression bombs.
+	maxDecompressedBackupSize = 256 << 20
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zipMagic  = []byte("PK\x03\x04")
+)
+
+// normalizeBackupCompression validates a user supplied compression name.
+func normalizeBackupCompression(compression string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(compression)) {
+	case "", "none":
+		return backupCompressionNone, nil
+	case "gzip", "gz":
+		return backupCompressionGzip, nil
+	case "zip":
+		return backupCompressionZip, nil
+	default:
+		return "", fmt.Errorf("unsupported backup compression: %s", compression)
+	}
+}
+
+// compressBackupPayload compresses the serialized backup. innerName is the name of the
+// JSON entry inside zip archives. It returns the file extension to append to the backup name.
+func compressBackupPayload(data []byte, compression string, innerName string) ([]byte, string, error) {
+	var buf bytes.Buffer
+
+	switch compression {
+	case backupCompressionNone:
+		return data, "", nil
+	case backupCompressionGzip:
+		gw := gzip.NewWriter(&buf)
+		gw.Name = innerName
+		if _, err := gw.Write(data); err != nil {
+			return nil, "", fmt.Errorf("failed to gzip backup: %w", err)
+		}
+		if err := gw.Close(); err != nil {
+			return nil, "", fmt.Errorf("failed to finish gzip stream: %w", err)
+		}
+		return buf.Bytes(), gzipBackupExtension, nil
+	case backupCompressionZip:
+		zw := zip.NewWriter(&buf)
+		w, err := zw.Create(innerName)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to create zip entry: %w", err)
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, "", fmt.Errorf("failed to zip backup: %w", err)
+		}
+		if err := zw.Close(); err != nil {
+			return nil, "", fmt.Errorf("failed to finish zip archive: %w", err)
+		}
+		return buf.Bytes(), zipBackupExtension, nil
+	default:
+		return nil, "", fmt.Errorf("unsupported backup compression: %s", compression)
+	}
+}
+
+// detectBackupCompression determines the compression of a backup from its magic bytes,
+// falling back to the file extension.
+func detectBackupCompression(fileName string, data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, gzipMagic):
+		return backupCompressionGzip
+	case bytes.HasPrefix(data, zipMagic):
+		return backupCompressionZip
+	}
+
+	name := strings.TrimSuffix(fileName, encryptedBackupExtension)
+	switch {
+	case strings.HasSuffix(name, gzipBackupExtension):
+		return backupCompressionGzip
+	case strings.HasSuffix(name, zipBackupExtension):
+		return backupCompressionZip
+	}
+	return backupCompressionNone
+}
+
+// decompressBackupPayload returns the JSON document contained in a (possibly compressed) backup.
+func decompressBackupPayload(fileName string, data []byte) ([]byte, error) {
+	switch detectBackupCompression(fileName, data) {
+	case backupCompressionGzip:
+		gr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open gzip backup: %w", err)
+		}
+		defer gr.Close()
+		return readLimitedBackup(gr)
+	case backupCompressionZip:
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open zip backup: %w", err)
+		}
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() || !strings.HasSuffix(f.Name, ".json") {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return nil, fmt.Errorf("failed to open %s in zip backup: %w", f.Name, err)
+			}
+			defer rc.Close()
+			return readLimitedBackup(rc)
+		}
+		return nil, fmt.Errorf("zip backup does not contain a JSON file")
+	default:
+		return data, nil
+	}
+}
+
+func readLimitedBackup(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxDecompressedBackupSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress backup: %w", err)
+	}
+	if len(data) > maxDecompressedBackupSize {
+		return nil, fmt.Errorf("decompressed backup exceeds %d bytes", maxDecompressedBackupSize)
+	}
+	return data, nil
+}
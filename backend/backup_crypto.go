@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// 加密备份文件格式:
+//
+//	MARKHUB-ENC\n
+//	{"version":1,"alg":"AES-256-GCM","kdf":"scrypt",...}\n
+//	nonce || ciphertext
+//
+// 头部 JSON 同时作为 GCM 的附加认证数据，篡改头部会导致解密失败。
+const (
+	encryptedBackupMagic      = "MARKHUB-ENC\n"
+	encryptedBackupExtension  = ".enc"
+	backupEncryptionVersion   = 1
+	backupEncryptionAlgorithm = "AES-256-GCM"
+	backupKDFScrypt           = "scrypt"
+
+	backupScryptN      = 32768
+	backupScryptR      = 8
+	backupScryptP      = 1
+	backupKeyLength    = 32
+	backupSaltLength   = 16
+	maxBackupHeaderLen = 1024
+)
+
+var (
+	errBackupPassphraseRequired = errors.New("backup is encrypted and requires a passphrase")
+	errBackupPassphraseInvalid  = errors.New("invalid passphrase or corrupted backup")
+)
+
+// EncryptedBackupHeader records the algorithm and KDF parameters used to encrypt a backup.
+type EncryptedBackupHeader struct {
+	Version   int    `json:"version"`
+	Algorithm string `json:"alg"`
+	KDF       string `json:"kdf"`
+	Salt      string `json:"salt"`
+	N         int    `json:"n"`
+	R         int    `json:"r"`
+	P         int    `json:"p"`
+}
+
+// isEncryptedBackup reports whether data starts with the encrypted backup header.
+func isEncryptedBackup(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptedBackupMagic))
+}
+
+// deriveBackupKey derives the AES key for a backup from the user's passphrase.
+func deriveBackupKey(passphrase string, header EncryptedBackupHeader) ([]byte, error) {
+	if header.KDF != backupKDFScrypt {
+		return nil, fmt.Errorf("unsupported backup KDF: %s", header.KDF)
+	}
+	salt, err := base64.StdEncoding.DecodeString(header.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode backup salt: %w", err)
+	}
+	return scrypt.Key([]byte(passphrase), salt, header.N, header.R, header.P, backupKeyLength)
+}
+
+// encryptBackupPayload encrypts a serialized backup with a key derived from passphrase.
+func encryptBackupPayload(plaintext []byte, passphrase string) ([]byte, error) {
+	if passphrase == "" {
+		return nil, errBackupPassphraseRequired
+	}
+
+	salt := make([]byte, backupSaltLength)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	header := EncryptedBackupHeader{
+		Version:   backupEncryptionVersion,
+		Algorithm: backupEncryptionAlgorithm,
+		KDF:       backupKDFScrypt,
+		Salt:      base64.StdEncoding.EncodeToString(salt),
+		N:         backupScryptN,
+		R:         backupScryptR,
+		P:         backupScryptP,
+	}
+	headerBytes, err := json.Marshal(header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal backup header: %w", err)
+	}
+
+	key, err := deriveBackupKey(passphrase, header)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(encryptedBackupMagic)
+	buf.Write(headerBytes)
+	buf.WriteByte('\n')
+	buf.Write(gcm.Seal(nonce, nonce, plaintext, headerBytes))
+	return buf.Bytes(), nil
+}
+
+// decryptBackupPayload reverses encryptBackupPayload.
+func decryptBackupPayload(data []byte, passphrase string) ([]byte, error) {
+	if !isEncryptedBackup(data) {
+		return nil, fmt.Errorf("backup is not encrypted")
+	}
+	if passphrase == "" {
+		return nil, errBackupPassphraseRequired
+	}
+
+	rest := data[len(encryptedBackupMagic):]
+	headerEnd := bytes.IndexByte(rest, '\n')
+	if headerEnd < 0 || headerEnd > maxBackupHeaderLen {
+		return nil, fmt.Errorf("malformed encrypted backup header")
+	}
+	headerBytes := rest[:headerEnd]
+	sealed := rest[headerEnd+1:]
+
+	var header EncryptedBackupHeader
+	if err := json.Unmarshal(headerBytes, &header); err != nil {
+		return nil, fmt.Errorf("failed to parse encrypted backup header: %w", err)
+	}
+	if header.Version != backupEncryptionVersion || header.Algorithm != backupEncryptionAlgorithm {
+		return nil, fmt.Errorf("unsupported backup encryption: version %d, algorithm %s", header.Version, header.Algorithm)
+	}
+
+	key, err := deriveBackupKey(passphrase, header)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	nonceSize := gcm.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:nonceSize], sealed[nonceSize:]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, headerBytes)
+	if err != nil {
+		return nil, errBackupPassphraseInvalid
+	}
+	return plaintext, nil
+}
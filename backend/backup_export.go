@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// maxBackupUploadSize limits uploaded backup files. Uncompressed backups share the
+// decompression limit so that everything the export produces can be imported again.
+const maxBackupUploadSize = maxDecompressedBackupSize
+
+// backupContentType returns the MIME type of an exported backup file.
+func backupContentType(fileName string, data []byte) string {
+	if isEncryptedBackup(data) {
+		return "application/octet-stream"
+	}
+	switch detectBackupCompression(fileName, data) {
+	case backupCompressionGzip:
+		return "application/gzip"
+	case backupCompressionZip:
+		return "application/zip"
+	default:
+		return "application/json"
+	}
+}
+
+// backupExportHandler returns the same document as webdavBackupHandler as a file download,
+// for users without a backup target.
+// API Endpoint: GET|POST /api/custom/backup/export
+// Query / Request Body: { "compression": "" | "gzip" | "zip", "passphrase": string (body only) }
+func backupExportHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		authRecord := e.Auth
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("User not authenticated.", nil)
+		}
+		userId := authRecord.Id
+
+		// The passphrase is only accepted in the body so it does not end up in access logs
+		var requestData struct {
+			Passphrase  string `json:"passphrase"`
+			Compression string `json:"compression"`
+		}
+		if err := e.BindBody(&requestData); err != nil {
+			return e.BadRequestError("Failed to parse request data.", err)
+		}
+		if requestData.Compression == "" {
+			requestData.Compression = e.Request.URL.Query().Get("compression")
+		}
+
+		compression, err := normalizeBackupCompression(requestData.Compression)
+		if err != nil {
+			return e.BadRequestError(err.Error(), nil)
+		}
+
+		backupData, err := buildBackupData(app, userId)
+		if err != nil {
+			return e.InternalServerError("Failed to collect data for export.", err)
+		}
+
+		fileName, payload, err := encodeBackupPayload(backupData, compression, requestData.Passphrase)
+		if err != nil {
+			return e.InternalServerError("Failed to encode export data.", err)
+		}
+
+		log.Printf("Exported %d bookmarks and %d folders for user %s as %s", len(backupData.Bookmarks), len(backupData.Folders), userId, fileName)
+
+		e.Response.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fileName))
+		e.Response.Header().Set("Cache-Control", "no-store")
+		return e.Blob(http.StatusOK, backupContentType(fileName, payload), payload)
+	}
+}
+
+// backupImportHandler restores an uploaded backup file exactly like webdavRestoreHandler
+// restores one read from the backup target.
+// API Endpoint: POST /api/custom/backup/import
+// Request Body (multipart/form-data): file, passphrase (optional)
+func backupImportHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		authRecord := e.Auth
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("User not authenticated.", nil)
+		}
+		userId := authRecord.Id
+
+		file, header, err := e.Request.FormFile("file")
+		if err != nil {
+			return e.BadRequestError("Missing backup file (expected multipart field 'file').", err)
+		}
+		defer file.Close()
+
+		data, err := io.ReadAll(io.LimitReader(file, maxBackupUploadSize+1))
+		if err != nil {
+			return e.BadRequestError("Failed to read uploaded backup file.", err)
+		}
+		if len(data) > maxBackupUploadSize {
+			return e.BadRequestError(fmt.Sprintf("Backup file exceeds %d bytes.", maxBackupUploadSize), nil)
+		}
+		if len(data) == 0 {
+			return e.BadRequestError("Uploaded backup file is empty.", nil)
+		}
+
+		// Fall back to the passphrase stored with the backup target, like a regular restore
+		passphrase := e.Request.FormValue("passphrase")
+		if passphrase == "" && isEncryptedBackup(data) {
+			userSettings, err := app.FindFirstRecordByFilter(
+				"user_settings",
+				"userId = {:userId}",
+				dbx.Params{"userId": userId},
+			)
+			if err == nil {
+				options, err := loadBackupOptions(userSettings)
+				if err != nil {
+					log.Printf("Backup import: failed to read backup options for user %s: %v", userId, err)
+				} else if passphrase, err = resolveBackupPassphrase(options, ""); err != nil {
+					return e.InternalServerError("Failed to decrypt backup passphrase.", err)
+				}
+			}
+		}
+
+		backupData, err := decodeBackupPayload(header.Filename, data, passphrase)
+		if errors.Is(err, errBackupPassphraseRequired) || errors.Is(err, errBackupPassphraseInvalid) {
+			return backupPassphraseRequiredResponse(e, err, header.Filename)
+		}
+		if err != nil {
+			return e.BadRequestError("Failed to parse backup data.", err)
+		}
+
+		restoredBookmarks := restoreBackupData(auditContext(e, auditSourceRestore), app, userId, backupData)
+
+		log.Printf("Imported backup %s for user %s", header.Filename, userId)
+		return e.JSON(http.StatusOK, map[string]interface{}{
+			"success":            true,
+			"message":            fmt.Sprintf("Restore successful from %s", header.Filename),
+			"restored_bookmarks": restoredBookmarks,
+			"restored_folders":   len(backupData.Folders),
+		})
+	}
+}
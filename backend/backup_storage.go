@@ -0,0 +1,332 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/studio-b12/gowebdav"
+)
+
+// Supported backup target types for BackupTargetConfig.Type.
+const (
+	backupTargetWebDAV = "webdav"
+	backupTargetS3     = "s3"
+	backupTargetLocal  = "local"
+	backupTargetSFTP   = "sftp"
+)
+
+var errBackupTargetNotConfigured = errors.New("backup target is not configured")
+
+// BackupOptions are the payload options shared by every backup target.
+type BackupOptions struct {
+	// EncryptBackups makes every backup encrypted with BackupPassphrase (stored encrypted like Password).
+	EncryptBackups   bool   `json:"EncryptBackups,omitempty"`
+	BackupPassphrase string `json:"BackupPassphrase,omitempty"`
+	// Compression is "", "gzip" or "zip".
+	Compression string `json:"Compression,omitempty"`
+}
+
+// BackupTargetConfig is stored in user_settings.backup_target and selects where backups go.
+// When it is empty or Type is "webdav", webdav_config is used.
+type BackupTargetConfig struct {
+	Type string `json:"Type"`
+
+	// S3-compatible object storage (AWS S3, MinIO, ...). S3Endpoint may include a scheme, https is assumed otherwise.
+	S3Endpoint        string `json:"S3Endpoint,omitempty"`
+	S3Region          string `json:"S3Region,omitempty"`
+	S3Bucket          string `json:"S3Bucket,omitempty"`
+	S3AccessKeyID     string `json:"S3AccessKeyId,omitempty"`
+	S3SecretAccessKey string `json:"S3SecretAccessKey,omitempty"`
+	S3Prefix          string `json:"S3Prefix,omitempty"`
+	S3PathStyle       bool   `json:"S3PathStyle,omitempty"`
+
+	// Directory on the server, relative to BACKUP_LOCAL_ROOT/<userId>.
+	LocalPath string `json:"LocalPath,omitempty"`
+
+	// SFTP host. SFTPHostKey is the required authorized_keys formatted public key used to verify the server.
+	SFTPHost       string `json:"SFTPHost,omitempty"`
+	SFTPPort       int    `json:"SFTPPort,omitempty"`
+	SFTPUsername   string `json:"SFTPUsername,omitempty"`
+	SFTPPassword   string `json:"SFTPPassword,omitempty"`
+	SFTPPrivateKey string `json:"SFTPPrivateKey,omitempty"`
+	SFTPHostKey    string `json:"SFTPHostKey,omitempty"`
+	SFTPPath       string `json:"SFTPPath,omitempty"`
+
+	BackupOptions
+}
+
+// backupTargetSecretFields lists the backup_target keys that are stored encrypted.
+var backupTargetSecretFields = []string{"S3SecretAccessKey", "SFTPPassword", "SFTPPrivateKey", "BackupPassphrase"}
+
+// BackupFileInfo describes a backup file stored on a target.
+type BackupFileInfo struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// BackupStorage is a place backup files can be written to and restored from.
+// File names are plain base names; each implementation maps them into its configured directory.
+type BackupStorage interface {
+	// Prepare makes sure the backup directory exists.
+	Prepare() error
+	Write(name string, data []byte) error
+	Read(name string) ([]byte, error)
+	List() ([]BackupFileInfo, error)
+	Delete(name string) error
+	// Describe returns a human readable location used in logs and messages.
+	Describe(name string) string
+	Close() error
+}
+
+// jsonFieldBytes converts the value of a PocketBase JSON field into raw bytes.
+func jsonFieldBytes(raw any) ([]byte, error) {
+	switch v := raw.(type) {
+	case nil:
+		return nil, nil
+	case types.JSONRaw:
+		return []byte(v), nil
+	case json.RawMessage:
+		return []byte(v), nil
+	case string:
+		return []byte(v), nil
+	case []byte:
+		return v, nil
+	default:
+		return json.Marshal(v)
+	}
+}
+
+// decodeJSONField unmarshals a JSON field of record into dst. It returns false when the field is empty.
+func decodeJSONField(record *core.Record, field string, dst any) (bool, error) {
+	data, err := jsonFieldBytes(record.Get(field))
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", field, err)
+	}
+	trimmed := strings.TrimSpace(string(data))
+	if trimmed == "" || trimmed == "null" || trimmed == "{}" {
+		return false, nil
+	}
+	if err := json.Unmarshal(data, dst); err != nil {
+		return false, fmt.Errorf("failed to parse %s: %w", field, err)
+	}
+	return true, nil
+}
+
+// loadBackupTarget opens the backup storage configured in the given user_settings record.
+// The caller must Close the returned storage.
+func loadBackupTarget(userSettings *core.Record) (BackupStorage, BackupOptions, error) {
+	var target BackupTargetConfig
+	hasTarget, err := decodeJSONField(userSettings, "backup_target", &target)
+	if err != nil {
+		return nil, BackupOptions{}, err
+	}
+
+	if !hasTarget || target.Type == "" || target.Type == backupTargetWebDAV {
+		var webdavConfig WebDAVConfig
+		hasWebDAV, err := decodeJSONField(userSettings, "webdav_config", &webdavConfig)
+		if err != nil {
+			return nil, BackupOptions{}, err
+		}
+		if !hasWebDAV || webdavConfig.Url == "" {
+			return nil, BackupOptions{}, errBackupTargetNotConfigured
+		}
+		storage, err := newWebDAVBackupStorage(webdavConfig)
+		return storage, webdavConfig.BackupOptions, err
+	}
+
+	var storage BackupStorage
+	switch target.Type {
+	case backupTargetS3:
+		storage, err = newS3BackupStorage(target)
+	case backupTargetLocal:
+		storage, err = newLocalBackupStorage(userSettings.GetString("userId"), target)
+	case backupTargetSFTP:
+		storage, err = newSFTPBackupStorage(target)
+	default:
+		err = fmt.Errorf("unsupported backup target type: %s", target.Type)
+	}
+	return storage, target.BackupOptions, err
+}
+
+// loadBackupOptions returns the payload options of the configured backup target without connecting to it.
+func loadBackupOptions(userSettings *core.Record) (BackupOptions, error) {
+	var target BackupTargetConfig
+	hasTarget, err := decodeJSONField(userSettings, "backup_target", &target)
+	if err != nil {
+		return BackupOptions{}, err
+	}
+	if hasTarget && target.Type != "" && target.Type != backupTargetWebDAV {
+		return target.BackupOptions, nil
+	}
+
+	var webdavConfig WebDAVConfig
+	if _, err := decodeJSONField(userSettings, "webdav_config", &webdavConfig); err != nil {
+		return BackupOptions{}, err
+	}
+	return webdavConfig.BackupOptions, nil
+}
+
+// --- WebDAV ---
+
+type webdavBackupStorage struct {
+	client *gowebdav.Client
+	dir    string
+}
+
+// newWebDAVClient returns a WebDAV client that connects through the outbound transport, so that a
+// user-supplied server URL cannot reach loopback or internal addresses.
+func newWebDAVClient(url string, username string, password string) *gowebdav.Client {
+	client := gowebdav.NewClient(url, username, password)
+	client.SetTransport(outboundTransport)
+	return client
+}
+
+func newWebDAVBackupStorage(config WebDAVConfig) (*webdavBackupStorage, error) {
+	decryptedPassword, err := decryptPassword(config.Password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt WebDAV password: %w", err)
+	}
+	return &webdavBackupStorage{
+		client: newWebDAVClient(config.Url, config.Username, decryptedPassword),
+		dir:    config.Path,
+	}, nil
+}
+
+func (s *webdavBackupStorage) Prepare() error {
+	return s.client.MkdirAll(s.dir, 0755)
+}
+
+func (s *webdavBackupStorage) Write(name string, data []byte) error {
+	return s.client.Write(path.Join(s.dir, name), data, 0644)
+}
+
+func (s *webdavBackupStorage) Read(name string) ([]byte, error) {
+	return s.client.Read(path.Join(s.dir, name))
+}
+
+func (s *webdavBackupStorage) List() ([]BackupFileInfo, error) {
+	files, err := s.client.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfosToBackupFiles(files), nil
+}
+
+func (s *webdavBackupStorage) Delete(name string) error {
+	return s.client.Remove(path.Join(s.dir, name))
+}
+
+func (s *webdavBackupStorage) Describe(name string) string {
+	return "WebDAV " + path.Join(s.dir, name)
+}
+
+func (s *webdavBackupStorage) Close() error {
+	return nil
+}
+
+// --- Local filesystem ---
+
+// localBackupStorage writes backups below the directory configured by the
+// BACKUP_LOCAL_ROOT environment variable. Each user is confined to their own sub directory.
+type localBackupStorage struct {
+	dir string
+}
+
+func newLocalBackupStorage(userId string, target BackupTargetConfig) (*localBackupStorage, error) {
+	root := os.Getenv("BACKUP_LOCAL_ROOT")
+	if root == "" {
+		return nil, fmt.Errorf("local backups are disabled on this server (BACKUP_LOCAL_ROOT is not set)")
+	}
+	if userId == "" {
+		return nil, fmt.Errorf("missing user id for local backup target")
+	}
+	// Cleaning against "/" strips any ".." so the path cannot escape the user's directory
+	subDir := filepath.Clean("/" + filepath.FromSlash(target.LocalPath))
+	return &localBackupStorage{dir: filepath.Join(root, userId, subDir)}, nil
+}
+
+func (s *localBackupStorage) filePath(name string) (string, error) {
+	if name == "" || filepath.Base(name) != name {
+		return "", fmt.Errorf("invalid backup file name: %q", name)
+	}
+	return filepath.Join(s.dir, name), nil
+}
+
+func (s *localBackupStorage) Prepare() error {
+	return os.MkdirAll(s.dir, 0755)
+}
+
+func (s *localBackupStorage) Write(name string, data []byte) error {
+	p, err := s.filePath(name)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p, data, 0600)
+}
+
+func (s *localBackupStorage) Read(name string) ([]byte, error) {
+	p, err := s.filePath(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(p)
+}
+
+func (s *localBackupStorage) List() ([]BackupFileInfo, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			log.Printf("Local backup: failed to stat %s: %v", entry.Name(), err)
+			continue
+		}
+		files = append(files, info)
+	}
+	return fileInfosToBackupFiles(files), nil
+}
+
+func (s *localBackupStorage) Delete(name string) error {
+	p, err := s.filePath(name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(p)
+}
+
+func (s *localBackupStorage) Describe(name string) string {
+	return "local " + filepath.Join(s.dir, name)
+}
+
+func (s *localBackupStorage) Close() error {
+	return nil
+}
+
+// fileInfosToBackupFiles keeps the regular files of a directory listing.
+func fileInfosToBackupFiles(files []os.FileInfo) []BackupFileInfo {
+	result := make([]BackupFileInfo, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		result = append(result, BackupFileInfo{
+			Name:    file.Name(),
+			Size:    file.Size(),
+			ModTime: file.ModTime(),
+		})
+	}
+	return result
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const s3BackupTimeout = 60 * time.Second
+
+// s3BackupStorage stores backups as objects in an S3-compatible bucket.
+type s3BackupStorage struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func newS3BackupStorage(target BackupTargetConfig) (*s3BackupStorage, error) {
+	if target.S3Endpoint == "" || target.S3Bucket == "" {
+		return nil, fmt.Errorf("S3 endpoint and bucket are required")
+	}
+
+	secretKey, err := decryptSensitiveData(target.S3SecretAccessKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt S3 secret access key: %w", err)
+	}
+
+	// minio expects "host[:port]" and a separate TLS flag
+	endpoint := target.S3Endpoint
+	secure := true
+	if strings.Contains(endpoint, "://") {
+		parsed, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid S3 endpoint: %w", err)
+		}
+		endpoint = parsed.Host
+		secure = parsed.Scheme != "http"
+	}
+
+	options := &minio.Options{
+		Creds:  credentials.NewStaticV4(target.S3AccessKeyID, secretKey, ""),
+		Secure: secure,
+		Region: target.S3Region,
+	}
+	if target.S3PathStyle {
+		options.BucketLookup = minio.BucketLookupPath
+	}
+
+	client, err := minio.New(endpoint, options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+
+	return &s3BackupStorage{
+		client: client,
+		bucket: target.S3Bucket,
+		prefix: strings.Trim(target.S3Prefix, "/"),
+	}, nil
+}
+
+func (s *s3BackupStorage) key(name string) string {
+	if s.prefix == "" {
+		return name
+	}
+	return path.Join(s.prefix, name)
+}
+
+func (s *s3BackupStorage) Prepare() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s3BackupTimeout)
+	defer cancel()
+
+	exists, err := s.client.BucketExists(ctx, s.bucket)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("S3 bucket %s does not exist", s.bucket)
+	}
+	return nil
+}
+
+func (s *s3BackupStorage) Write(name string, data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s3BackupTimeout)
+	defer cancel()
+
+	_, err := s.client.PutObject(ctx, s.bucket, s.key(name), bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+	})
+	return err
+}
+
+func (s *s3BackupStorage) Read(name string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s3BackupTimeout)
+	defer cancel()
+
+	object, err := s.client.GetObject(ctx, s.bucket, s.key(name), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+	return io.ReadAll(object)
+}
+
+func (s *s3BackupStorage) List() ([]BackupFileInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s3BackupTimeout)
+	defer cancel()
+
+	listPrefix := ""
+	if s.prefix != "" {
+		listPrefix = s.prefix + "/"
+	}
+
+	var files []BackupFileInfo
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: listPrefix}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		name := strings.TrimPrefix(object.Key, listPrefix)
+		if name == "" || strings.Contains(name, "/") {
+			continue
+		}
+		files = append(files, BackupFileInfo{
+			Name:    name,
+			Size:    object.Size,
+			ModTime: object.LastModified,
+		})
+	}
+	return files, nil
+}
+
+func (s *s3BackupStorage) Delete(name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s3BackupTimeout)
+	defer cancel()
+
+	return s.client.RemoveObject(ctx, s.bucket, s.key(name), minio.RemoveObjectOptions{})
+}
+
+func (s *s3BackupStorage) Describe(name string) string {
+	return fmt.Sprintf("s3://%s/%s", s.bucket, s.key(name))
+}
+
+func (s *s3BackupStorage) Close() error {
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const sftpDialTimeout = 15 * time.Second
+
+// sftpBackupStorage stores backups in a directory on an SFTP host.
+type sftpBackupStorage struct {
+	conn   *ssh.Client
+	client *sftp.Client
+	host   string
+	dir    string
+}
+
+func newSFTPBackupStorage(target BackupTargetConfig) (*sftpBackupStorage, error) {
+	if target.SFTPHost == "" || target.SFTPUsername == "" {
+		return nil, fmt.Errorf("SFTP host and username are required")
+	}
+
+	var authMethods []ssh.AuthMethod
+	if target.SFTPPrivateKey != "" {
+		privateKey, err := decryptSensitiveData(target.SFTPPrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt SFTP private key: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey([]byte(privateKey))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse SFTP private key: %w", err)
+		}
+		authMethods = append(authMethods, ssh.PublicKeys(signer))
+	}
+	if target.SFTPPassword != "" {
+		password, err := decryptSensitiveData(target.SFTPPassword)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt SFTP password: %w", err)
+		}
+		authMethods = append(authMethods, ssh.Password(password))
+	}
+	if len(authMethods) == 0 {
+		return nil, fmt.Errorf("SFTP password or private key is required")
+	}
+
+	// 不校验服务器身份时，中间人可以截获密码、私钥和所有备份，因此必须配置主机公钥
+	if target.SFTPHostKey == "" {
+		return nil, fmt.Errorf("SFTP host key is required (e.g. the output of ssh-keyscan %s)", target.SFTPHost)
+	}
+	hostKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(target.SFTPHostKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SFTP host key: %w", err)
+	}
+	hostKeyCallback := ssh.FixedHostKey(hostKey)
+
+	port := target.SFTPPort
+	if port == 0 {
+		port = 22
+	}
+	address := net.JoinHostPort(target.SFTPHost, strconv.Itoa(port))
+
+	conn, err := ssh.Dial("tcp", address, &ssh.ClientConfig{
+		User:            target.SFTPUsername,
+		Auth:            authMethods,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         sftpDialTimeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SFTP host %s: %w", address, err)
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to start SFTP session on %s: %w", address, err)
+	}
+
+	dir := target.SFTPPath
+	if dir == "" {
+		dir = "."
+	}
+
+	return &sftpBackupStorage{conn: conn, client: client, host: address, dir: dir}, nil
+}
+
+func (s *sftpBackupStorage) Prepare() error {
+	return s.client.MkdirAll(s.dir)
+}
+
+func (s *sftpBackupStorage) Write(name string, data []byte) error {
+	f, err := s.client.OpenFile(path.Join(s.dir, name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *sftpBackupStorage) Read(name string) ([]byte, error) {
+	f, err := s.client.Open(path.Join(s.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func (s *sftpBackupStorage) List() ([]BackupFileInfo, error) {
+	files, err := s.client.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfosToBackupFiles(files), nil
+}
+
+func (s *sftpBackupStorage) Delete(name string) error {
+	return s.client.Remove(path.Join(s.dir, name))
+}
+
+func (s *sftpBackupStorage) Describe(name string) string {
+	return fmt.Sprintf("sftp://%s/%s", s.host, path.Join(s.dir, name))
+}
+
+func (s *sftpBackupStorage) Close() error {
+	s.client.Close()
+	return s.conn.Close()
+}
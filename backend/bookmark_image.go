@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+)
+
+// 书签预览图片：从页面的 og:image、twitter:image 或 <link rel="image_src"> 中提取，保存到 bookmarks.img；
+// 可选地下载到 bookmarks.imgFile（PocketBase 文件字段，支持缩略图），源站失效后预览仍然可用。
+// BOOKMARK_PREVIEW_IMAGES 控制新建书签时的行为：off（默认，只能通过接口获取）、link（只保存 URL）、download（同时下载）。
+
+// Preview image modes of BOOKMARK_PREVIEW_IMAGES.
+const (
+	previewImageModeOff      = "off"
+	previewImageModeLink     = "link"
+	previewImageModeDownload = "download"
+)
+
+const (
+	defaultPreviewImageMaxSize = 2 << 20
+	// maxPreviewImageMaxSize matches the MaxSize of the bookmarks.imgFile field.
+	maxPreviewImageMaxSize = 5 << 20
+	previewImageThumb      = "320x180"
+)
+
+// previewImageTypes maps the accepted image content types to file extensions.
+// SVG is not accepted because it can contain scripts.
+var previewImageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// pageImagePriority ranks the preview image sources; og:image wins over twitter:image over image_src.
+var pageImagePriority = map[string]int{
+	"og:image":            3,
+	"og:image:url":        3,
+	"og:image:secure_url": 3,
+	"twitter:image":       2,
+	"twitter:image:src":   2,
+	"image_src":           1,
+}
+
+// setPageImage records value as the page image when key is an image source ranked higher
+// than the one already found.
+func setPageImage(pageData *PageData, key string, value string) {
+	priority := pageImagePriority[strings.ToLower(key)]
+	value = strings.TrimSpace(value)
+	if priority == 0 || value == "" || priority <= pageData.imagePriority {
+		return
+	}
+	pageData.Image = value
+	pageData.imagePriority = priority
+}
+
+// resolvePageURL resolves ref against the page URL, returning "" unless the result is an
+// absolute http(s) URL.
+func resolvePageURL(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return ""
+	}
+	refURL, err := url.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	if base != nil {
+		refURL = base.ResolveReference(refURL)
+	}
+	if refURL.Scheme != "http" && refURL.Scheme != "https" || refURL.Host == "" {
+		return ""
+	}
+	return refURL.String()
+}
+
+// previewImageMode returns the configured BOOKMARK_PREVIEW_IMAGES mode.
+func previewImageMode() string {
+	switch mode := strings.ToLower(strings.TrimSpace(os.Getenv("BOOKMARK_PREVIEW_IMAGES"))); mode {
+	case previewImageModeLink, previewImageModeDownload:
+		return mode
+	default:
+		return previewImageModeOff
+	}
+}
+
+// previewImageMaxSize returns BOOKMARK_PREVIEW_IMAGE_MAX_SIZE (bytes), capped at the imgFile field limit.
+func previewImageMaxSize() int64 {
+	size, err := strconv.ParseInt(os.Getenv("BOOKMARK_PREVIEW_IMAGE_MAX_SIZE"), 10, 64)
+	if err != nil || size <= 0 {
+		return defaultPreviewImageMaxSize
+	}
+	if size > maxPreviewImageMaxSize {
+		return maxPreviewImageMaxSize
+	}
+	return size
+}
+
+// downloadPreviewImage downloads imageURL as a file for bookmarks.imgFile.
+func downloadPreviewImage(imageURL string) (*filesystem.File, error) {
+	httpClient := newOutboundHTTPClient(15 * time.Second)
+	req, err := http.NewRequest("GET", imageURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create image request: %w", err)
+	}
+	req.Header.Set("User-Agent", "MarkHubBookmarkProcessor/1.0")
+	req.Header.Set("Accept", "image/webp,image/png,image/jpeg,image/gif;q=0.9,*/*;q=0.5")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download image: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("image request returned status %s", resp.Status)
+	}
+
+	maxSize := previewImageMaxSize()
+	if resp.ContentLength > maxSize {
+		return nil, fmt.Errorf("image is larger than %d bytes", maxSize)
+	}
+	data, err := readLimitedBody(resp.Body, maxSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+
+	// 以实际内容为准，不信任响应头中的 Content-Type
+	contentType := http.DetectContentType(data)
+	ext, ok := previewImageTypes[contentType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported image type %s", contentType)
+	}
+
+	return filesystem.NewFileFromBytes(data, "preview"+ext)
+}
+
+// applyBookmarkPreviewImage stores the preview image of pageData on bookmark (without saving it),
+// downloading it into imgFile when download is set. It returns an error only for a failed download;
+// the img URL is set either way.
+func applyBookmarkPreviewImage(bookmark *core.Record, pageData PageData, download bool) error {
+	if pageData.Image == "" {
+		return nil
+	}
+	bookmark.Set("img", pageData.Image)
+	if !download {
+		return nil
+	}
+
+	file, err := downloadPreviewImage(pageData.Image)
+	if err != nil {
+		return err
+	}
+	bookmark.Set("imgFile", file)
+	return nil
+}
+
+// bookmarkPreviewImageThumbURL returns the thumbnail URL of the stored preview image, or "".
+func bookmarkPreviewImageThumbURL(bookmark *core.Record) string {
+	fileName := bookmark.GetString("imgFile")
+	if fileName == "" {
+		return ""
+	}
+	return fmt.Sprintf("/api/files/%s/%s/%s?thumb=%s", bookmark.Collection().Id, bookmark.Id, url.PathEscape(fileName), previewImageThumb)
+}
+
+// bookmarkPreviewImageHandler extracts (and optionally downloads) the preview image of a bookmark.
+// API Endpoint: POST /api/custom/bookmarks/{bookmarkId}/preview-image
+// Request: { "download": bool } (optional, defaults to BOOKMARK_PREVIEW_IMAGES=download)
+// Response: { "success": true, "bookmarkId", "img", "imgFile", "thumbUrl", "downloadError"? }
+func bookmarkPreviewImageHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		authRecord := e.Auth
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("User not authenticated.", nil)
+		}
+
+		bookmarkId := e.Request.PathValue("bookmarkId")
+		if bookmarkId == "" {
+			return e.BadRequestError("Bookmark ID is required", nil)
+		}
+
+		var requestData struct {
+			Download *bool `json:"download"`
+		}
+		if err := e.BindBody(&requestData); err != nil {
+			return e.BadRequestError("Failed to parse request data.", err)
+		}
+		download := previewImageMode() == previewImageModeDownload
+		if requestData.Download != nil {
+			download = *requestData.Download
+		}
+
+		bookmark, err := app.FindRecordById("bookmarks", bookmarkId)
+		if err != nil || !canEditBookmark(app, authRecord.Id, bookmark) {
+			return e.NotFoundError("Bookmark not found or access denied.", err)
+		}
+		pageURL := bookmark.GetString("url")
+		if pageURL == "" {
+			return e.BadRequestError("Bookmark URL is required for preview image extraction", nil)
+		}
+
+		// 用户设置只用于判断是否允许备用抓取服务，不存在时按默认处理
+		userSettings, _ := app.FindFirstRecordByFilter(
+			"user_settings",
+			"userId = {:userId}",
+			dbx.Params{"userId": authRecord.Id},
+		)
+		pageData, err := fetchPageContent(pageURL, app, userSettings)
+		if err != nil {
+			log.Printf("PreviewImage: failed to fetch page %s: %v", pageURL, err)
+		}
+		if pageData.Image == "" {
+			return e.NotFoundError("No preview image found on the page.", err)
+		}
+
+		response := map[string]interface{}{"success": true, "bookmarkId": bookmark.Id}
+		if err := applyBookmarkPreviewImage(bookmark, pageData, download); err != nil {
+			log.Printf("PreviewImage: failed to download %s for bookmark %s: %v", pageData.Image, bookmark.Id, err)
+			response["downloadError"] = err.Error()
+		}
+		if err := app.SaveWithContext(auditContext(e, ""), bookmark); err != nil {
+			return e.InternalServerError("Failed to save bookmark preview image", err)
+		}
+
+		response["img"] = bookmark.GetString("img")
+		response["imgFile"] = bookmark.GetString("imgFile")
+		response["thumbUrl"] = bookmarkPreviewImageThumbURL(bookmark)
+		return e.JSON(http.StatusOK, response)
+	}
+}
+
+// registerBookmarkPreviewImageHooks fills the preview image of new bookmarks in the background
+// according to BOOKMARK_PREVIEW_IMAGES.
+func registerBookmarkPreviewImageHooks(app *pocketbase.PocketBase) {
+	app.OnRecordCreateRequest("bookmarks").BindFunc(func(e *core.RecordRequestEvent) error {
+		if err := e.Next(); err != nil {
+			return err
+		}
+
+		mode := previewImageMode()
+		if mode == previewImageModeOff || e.Record.GetString("img") != "" || e.Record.GetString("url") == "" {
+			return nil
+		}
+
+		bookmarkId := e.Record.Id
+		userId := e.Record.GetString("userId")
+		pageURL := e.Record.GetString("url")
+		go func() {
+			userSettings, _ := app.FindFirstRecordByFilter(
+				"user_settings",
+				"userId = {:userId}",
+				dbx.Params{"userId": userId},
+			)
+			pageData, err := fetchPageContent(pageURL, app, userSettings)
+			if pageData.Image == "" {
+				if err != nil {
+					log.Printf("PreviewImage: failed to fetch page %s: %v", pageURL, err)
+				}
+				return
+			}
+
+			var file *filesystem.File
+			if mode == previewImageModeDownload {
+				if file, err = downloadPreviewImage(pageData.Image); err != nil {
+					log.Printf("PreviewImage: failed to download %s for bookmark %s: %v", pageData.Image, bookmarkId, err)
+				}
+			}
+
+			// 下载完成后再重新读取书签，避免覆盖期间的其他修改
+			bookmark, err := app.FindRecordById("bookmarks", bookmarkId)
+			if err != nil || bookmark.GetString("img") != "" {
+				return
+			}
+			bookmark.Set("img", pageData.Image)
+			if file != nil {
+				bookmark.Set("imgFile", file)
+			}
+			if err := app.Save(bookmark); err != nil {
+				log.Printf("PreviewImage: failed to save preview image of bookmark %s: %v", bookmarkId, err)
+			}
+		}()
+
+		return nil
+	})
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// 正文提取（参考 Readability 的思路）：忽略导航、页眉页脚、侧栏、Cookie 横幅等区域，
+// 按段落文本长度和逗号数给所在容器打分，按链接密度降权，取得分最高的容器（及得分相近的兄弟节点），
+// 输出保留标题和段落结构的纯文本。提取结果过短时回退到 extractTextFromHTML。
+// 不修改文档树，extractMetadata 仍可使用同一个文档。
+
+// minMainContentLength is the length below which the extracted main content is considered
+// a failure and the whole page text is used instead.
+const minMainContentLength = 200
+
+// minParagraphLength is the shortest text that counts as a paragraph when scoring.
+const minParagraphLength = 25
+
+var (
+	unlikelyContentPattern = regexp.MustCompile(`(?i)banner|breadcrumb|combx|comment|community|consent|cookie|disqus|extra|footer|gdpr|header|legends|menu|modal|nav|newsletter|popup|related|remark|replies|rss|share|shoutbox|sidebar|skyscraper|social|sponsor|subscribe|tags|toolbar|tweet|twitter|ad-break|advert|agegate|pagination|pager`)
+	likelyContentPattern   = regexp.MustCompile(`(?i)and|article|body|column|content|main|shadow|post|entry|story|text|blog`)
+	positiveContentPattern = regexp.MustCompile(`(?i)article|body|content|entry|hentry|h-entry|main|page|post|text|blog|story`)
+	negativeContentPattern = regexp.MustCompile(`(?i)-ad-|hidden|^hid$| hid$| hid |^hid |banner|combx|comment|com-|contact|footer|gdpr|masthead|media|meta|outbrain|promo|related|scroll|share|shoutbox|sidebar|skyscraper|sponsor|shopping|tags|widget`)
+	whitespacePattern      = regexp.MustCompile(`[ \t\r\n\f\v\x{00a0}]+`)
+)
+
+// skippedContentTags are never part of the main content.
+var skippedContentTags = map[string]bool{
+	"script": true, "style": true, "noscript": true, "template": true,
+	"nav": true, "header": true, "footer": true, "aside": true,
+	"form": true, "button": true, "select": true, "input": true, "textarea": true,
+	"iframe": true, "svg": true, "canvas": true, "dialog": true, "menu": true,
+}
+
+// skippedContentRoles are ARIA landmark roles outside the main content.
+var skippedContentRoles = map[string]bool{
+	"navigation": true, "banner": true, "contentinfo": true, "complementary": true,
+	"dialog": true, "alertdialog": true, "menu": true, "menubar": true, "search": true,
+}
+
+// paragraphTags are scored as paragraphs.
+var paragraphTags = map[string]bool{
+	"p": true, "pre": true, "td": true, "blockquote": true, "li": true, "dd": true,
+}
+
+// blockTags start a new paragraph when rendering text.
+var blockTags = map[string]bool{
+	"p": true, "div": true, "section": true, "article": true, "main": true,
+	"pre": true, "blockquote": true, "ul": true, "ol": true, "li": true, "dl": true, "dt": true, "dd": true,
+	"table": true, "tr": true, "figure": true, "figcaption": true, "hr": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+}
+
+// isSkippedContentNode reports whether n and its subtree are boilerplate.
+func isSkippedContentNode(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return n.Type == html.CommentNode
+	}
+	if skippedContentTags[n.Data] {
+		return true
+	}
+	if skippedContentRoles[strings.ToLower(htmlAttr(n, "role"))] {
+		return true
+	}
+	if strings.EqualFold(htmlAttr(n, "aria-hidden"), "true") || hasHTMLAttr(n, "hidden") {
+		return true
+	}
+	if n.Data == "body" || n.Data == "html" || n.Data == "article" || n.Data == "main" {
+		return false
+	}
+	matchString := htmlAttr(n, "class") + " " + htmlAttr(n, "id")
+	return unlikelyContentPattern.MatchString(matchString) && !likelyContentPattern.MatchString(matchString)
+}
+
+func hasHTMLAttr(n *html.Node, key string) bool {
+	for _, attr := range n.Attr {
+		if strings.EqualFold(attr.Key, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// contentText returns the normalized text of n, skipping boilerplate subtrees.
+func contentText(n *html.Node) string {
+	var buf strings.Builder
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if isSkippedContentNode(n) {
+			return
+		}
+		if n.Type == html.TextNode {
+			buf.WriteString(n.Data)
+			buf.WriteString(" ")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(n)
+	return strings.TrimSpace(whitespacePattern.ReplaceAllString(buf.String(), " "))
+}
+
+// linkDensity is the share of the text of n inside links.
+func linkDensity(n *html.Node) float64 {
+	textLength := len(contentText(n))
+	if textLength == 0 {
+		return 0
+	}
+	linkLength := 0
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if isSkippedContentNode(n) {
+			return
+		}
+		if n.Type == html.ElementNode && n.Data == "a" {
+			linkLength += len(contentText(n))
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(n)
+	return float64(linkLength) / float64(textLength)
+}
+
+// classWeight scores the class and id of n.
+func classWeight(n *html.Node) float64 {
+	weight := 0.0
+	for _, value := range []string{htmlAttr(n, "class"), htmlAttr(n, "id")} {
+		if value == "" {
+			continue
+		}
+		if negativeContentPattern.MatchString(value) {
+			weight -= 25
+		}
+		if positiveContentPattern.MatchString(value) {
+			weight += 25
+		}
+	}
+	return weight
+}
+
+// initialContentScore is the score of a container before its paragraphs are counted.
+func initialContentScore(n *html.Node) float64 {
+	score := classWeight(n)
+	switch n.Data {
+	case "article", "main":
+		score += 10
+	case "div":
+		score += 5
+	case "pre", "td", "blockquote":
+		score += 3
+	case "address", "ol", "ul", "dl", "dd", "dt", "li", "form":
+		score -= 3
+	case "h1", "h2", "h3", "h4", "h5", "h6", "th":
+		score -= 5
+	}
+	return score
+}
+
+// extractMainContent returns the main text of a page, or the whole page text when no main
+// content could be found.
+func extractMainContent(doc *html.Node) string {
+	scores := map[*html.Node]float64{}
+	var candidates []*html.Node
+	addScore := func(n *html.Node, score float64) {
+		if n == nil || n.Type != html.ElementNode {
+			return
+		}
+		if _, ok := scores[n]; !ok {
+			scores[n] = initialContentScore(n)
+			candidates = append(candidates, n)
+		}
+		scores[n] += score
+	}
+
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if isSkippedContentNode(n) {
+			return
+		}
+		if n.Type == html.ElementNode && paragraphTags[n.Data] {
+			if text := contentText(n); len([]rune(text)) >= minParagraphLength {
+				// 基础分 1，每个逗号 1 分，每 100 字符 1 分（最多 3 分）
+				score := 1 + float64(strings.Count(text, ",")+strings.Count(text, "，"))
+				score += float64(min(len([]rune(text))/100, 3))
+				addScore(n.Parent, score)
+				if n.Parent != nil {
+					addScore(n.Parent.Parent, score/2)
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	var top *html.Node
+	topScore := 0.0
+	for _, candidate := range candidates {
+		score := scores[candidate] * (1 - linkDensity(candidate))
+		scores[candidate] = score
+		if top == nil || score > topScore {
+			top, topScore = candidate, score
+		}
+	}
+
+	text := ""
+	if top != nil {
+		text = renderContentNodes(contentSiblings(top, topScore, scores))
+	}
+	if len([]rune(text)) < minMainContentLength {
+		return extractTextFromHTML(doc)
+	}
+	return text
+}
+
+// contentSiblings returns top together with its siblings that look like part of the same article.
+func contentSiblings(top *html.Node, topScore float64, scores map[*html.Node]float64) []*html.Node {
+	if top.Parent == nil {
+		return []*html.Node{top}
+	}
+	threshold := max(10, topScore*0.2)
+	var nodes []*html.Node
+	for sibling := top.Parent.FirstChild; sibling != nil; sibling = sibling.NextSibling {
+		if sibling == top {
+			nodes = append(nodes, sibling)
+			continue
+		}
+		if sibling.Type != html.ElementNode || isSkippedContentNode(sibling) {
+			continue
+		}
+		if score, ok := scores[sibling]; ok && score >= threshold {
+			nodes = append(nodes, sibling)
+			continue
+		}
+		if sibling.Data == "p" {
+			text := contentText(sibling)
+			if len([]rune(text)) > 80 && linkDensity(sibling) < 0.25 {
+				nodes = append(nodes, sibling)
+			}
+		}
+	}
+	return nodes
+}
+
+// renderContentNodes renders nodes as plain text, one paragraph or heading per block.
+func renderContentNodes(nodes []*html.Node) string {
+	var blocks []string
+	var current strings.Builder
+	flush := func() {
+		if text := strings.TrimSpace(whitespacePattern.ReplaceAllString(current.String(), " ")); text != "" {
+			blocks = append(blocks, text)
+		}
+		current.Reset()
+	}
+
+	var render func(*html.Node)
+	render = func(n *html.Node) {
+		if isSkippedContentNode(n) {
+			return
+		}
+		switch n.Type {
+		case html.TextNode:
+			current.WriteString(n.Data)
+			return
+		case html.ElementNode:
+			if n.Data == "br" {
+				current.WriteString(" ")
+				return
+			}
+			if blockTags[n.Data] {
+				flush()
+				if n.Data == "li" {
+					current.WriteString("- ")
+				}
+				defer flush()
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			render(c)
+		}
+	}
+	for _, n := range nodes {
+		render(n)
+		flush()
+	}
+
+	return strings.Join(blocks, "\n\n")
+}
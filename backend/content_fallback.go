@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// 直接请求页面失败时的备用抓取服务，由管理员通过环境变量配置，默认不启用（页面 URL 不会发送给任何第三方）。
+//
+// CONTENT_FETCH_FALLBACKS 按顺序列出要尝试的服务，逗号分隔：
+//
+//   - reader：自建的正文提取服务。请求 GET CONTENT_READER_URL，其中的 {url} 替换为转义后的页面 URL
+//     （没有 {url} 时追加 ?url=<页面URL>）；设置了 CONTENT_READER_TOKEN 时带 "Authorization: Bearer <token>"。
+//     响应为 JSON {"content": "正文", "title"?: "...", "description"?: "..."}，
+//     或者 text/plain、text/markdown 格式的正文。
+//   - headless：无头浏览器渲染服务（如 browserless 的 /content）。请求 POST CONTENT_HEADLESS_URL，
+//     请求体为 {"url": "<页面URL>"}，同样支持 CONTENT_HEADLESS_TOKEN；响应为渲染后的 HTML，
+//     按直接请求的方式提取正文和元数据。
+//
+// 用户可以在 user_settings.disableContentFetchFallback 中关闭备用抓取。
+
+// Content fetch fallback names used in CONTENT_FETCH_FALLBACKS.
+const (
+	contentFallbackReader   = "reader"
+	contentFallbackHeadless = "headless"
+)
+
+const defaultContentFallbackTimeout = 30 * time.Second
+
+// ContentFetchFallback fetches a page through a service when the direct request fails.
+type ContentFetchFallback interface {
+	Name() string
+	Fetch(pageURL string) (PageData, error)
+}
+
+// contentFallbackTimeout returns CONTENT_FETCH_FALLBACK_TIMEOUT (seconds).
+func contentFallbackTimeout() time.Duration {
+	if seconds, err := strconv.Atoi(os.Getenv("CONTENT_FETCH_FALLBACK_TIMEOUT")); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return defaultContentFallbackTimeout
+}
+
+// loadContentFetchFallbacks returns the fallback chain configured in CONTENT_FETCH_FALLBACKS,
+// skipping unknown or incompletely configured entries.
+func loadContentFetchFallbacks() []ContentFetchFallback {
+	var fallbacks []ContentFetchFallback
+	timeout := contentFallbackTimeout()
+	for _, name := range strings.Split(os.Getenv("CONTENT_FETCH_FALLBACKS"), ",") {
+		switch name = strings.ToLower(strings.TrimSpace(name)); name {
+		case "", "none", "disabled":
+			continue
+		case contentFallbackReader:
+			endpoint := strings.TrimSpace(os.Getenv("CONTENT_READER_URL"))
+			if endpoint == "" {
+				log.Printf("Content fetch fallback %q is enabled but CONTENT_READER_URL is not set, skipping", name)
+				continue
+			}
+			fallbacks = append(fallbacks, &readerContentFallback{
+				endpoint: endpoint,
+				token:    os.Getenv("CONTENT_READER_TOKEN"),
+				client:   &http.Client{Timeout: timeout},
+			})
+		case contentFallbackHeadless:
+			endpoint := strings.TrimSpace(os.Getenv("CONTENT_HEADLESS_URL"))
+			if endpoint == "" {
+				log.Printf("Content fetch fallback %q is enabled but CONTENT_HEADLESS_URL is not set, skipping", name)
+				continue
+			}
+			fallbacks = append(fallbacks, &headlessContentFallback{
+				endpoint: endpoint,
+				token:    os.Getenv("CONTENT_HEADLESS_TOKEN"),
+				client:   &http.Client{Timeout: timeout},
+			})
+		default:
+			log.Printf("Unknown content fetch fallback %q in CONTENT_FETCH_FALLBACKS, skipping", name)
+		}
+	}
+	return fallbacks
+}
+
+// contentFetchFallbackAllowed reports whether the user allows sending page URLs to the
+// fallback services. userSettings may be nil.
+func contentFetchFallbackAllowed(userSettings *core.Record) bool {
+	return userSettings == nil || !userSettings.GetBool("disableContentFetchFallback")
+}
+
+// mergePageData fills the empty fields of dst with the ones fetched by a fallback.
+func mergePageData(dst *PageData, src PageData) {
+	dst.Content = src.Content
+	for _, field := range []struct {
+		dst *string
+		src string
+	}{
+		{&dst.MetaTitle, src.MetaTitle},
+		{&dst.MetaDescription, src.MetaDescription},
+		{&dst.OGTitle, src.OGTitle},
+		{&dst.OGDescription, src.OGDescription},
+		{&dst.Image, src.Image},
+		{&dst.CanonicalURL, src.CanonicalURL},
+		{&dst.SiteName, src.SiteName},
+		{&dst.Author, src.Author},
+		{&dst.PublishedAt, src.PublishedAt},
+		{&dst.Language, src.Language},
+		{&dst.Charset, src.Charset},
+	} {
+		if *field.dst == "" {
+			*field.dst = field.src
+		}
+	}
+	if len(dst.Keywords) == 0 {
+		dst.Keywords = src.Keywords
+	}
+	if dst.Article == nil {
+		dst.Article = src.Article
+	}
+	if len(dst.Favicons) == 0 {
+		dst.Favicons = src.Favicons
+	}
+}
+
+// --- Self-hosted reader ---
+
+type readerContentFallback struct {
+	endpoint string
+	token    string
+	client   *http.Client
+}
+
+func (f *readerContentFallback) Name() string { return contentFallbackReader }
+
+func (f *readerContentFallback) requestURL(pageURL string) string {
+	if strings.Contains(f.endpoint, "{url}") {
+		return strings.ReplaceAll(f.endpoint, "{url}", url.QueryEscape(pageURL))
+	}
+	separator := "?"
+	if strings.Contains(f.endpoint, "?") {
+		separator = "&"
+	}
+	return f.endpoint + separator + "url=" + url.QueryEscape(pageURL)
+}
+
+func (f *readerContentFallback) Fetch(pageURL string) (PageData, error) {
+	var pageData PageData
+
+	req, err := http.NewRequest("GET", f.requestURL(pageURL), nil)
+	if err != nil {
+		return pageData, fmt.Errorf("failed to create reader request: %w", err)
+	}
+	req.Header.Set("User-Agent", "MarkHubBookmarkProcessor/1.0")
+	req.Header.Set("Accept", "application/json, text/markdown;q=0.9, text/plain;q=0.8")
+	if f.token != "" {
+		req.Header.Set("Authorization", "Bearer "+f.token)
+	}
+
+	body, contentType, err := doContentFallbackRequest(f.client, req)
+	if err != nil {
+		return pageData, err
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType != "application/json" {
+		pageData.Content = strings.TrimSpace(string(body))
+		return pageData, nil
+	}
+
+	var result struct {
+		Content     string `json:"content"`
+		Title       string `json:"title"`
+		Description string `json:"description"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return pageData, fmt.Errorf("failed to decode reader response: %w", err)
+	}
+	pageData.Content = strings.TrimSpace(result.Content)
+	pageData.MetaTitle = result.Title
+	pageData.MetaDescription = result.Description
+	return pageData, nil
+}
+
+// --- Headless browser ---
+
+type headlessContentFallback struct {
+	endpoint string
+	token    string
+	client   *http.Client
+}
+
+func (f *headlessContentFallback) Name() string { return contentFallbackHeadless }
+
+func (f *headlessContentFallback) Fetch(pageURL string) (PageData, error) {
+	requestBody, _ := json.Marshal(map[string]string{"url": pageURL})
+	req, err := http.NewRequest("POST", f.endpoint, bytes.NewReader(requestBody))
+	if err != nil {
+		return PageData{}, fmt.Errorf("failed to create headless request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("User-Agent", "MarkHubBookmarkProcessor/1.0")
+	if f.token != "" {
+		req.Header.Set("Authorization", "Bearer "+f.token)
+	}
+
+	body, contentType, err := doContentFallbackRequest(f.client, req)
+	if err != nil {
+		return PageData{}, err
+	}
+
+	base, _ := url.Parse(pageURL)
+	return parsePageHTML(body, contentType, base)
+}
+
+// doContentFallbackRequest performs req and returns the body (up to maxPageHTMLSize) and content type.
+func doContentFallbackRequest(client *http.Client, req *http.Request) ([]byte, string, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("request failed with status %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxPageHTMLSize))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read response: %w", err)
+	}
+	return body, resp.Header.Get("Content-Type"), nil
+}
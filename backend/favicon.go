@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// 网站图标：服务端从网站本身发现图标（<link rel="icon">、apple-touch-icon、/favicon.ico），
+// 下载后按主机保存到 favicons 集合，通过 /api/custom/favicons/{host} 提供，浏览器查看图标时不再请求第三方服务。
+// 只有设置了 FAVICON_FALLBACK_SERVICES（google、duckduckgo）时，网站本身没有可用图标才会使用外部服务。
+
+const (
+	// faviconCacheTTL is how long a fetched icon is used before it is fetched again.
+	faviconCacheTTL = 30 * 24 * time.Hour
+	// faviconFailureTTL is how long to wait before retrying a host whose icon could not be fetched.
+	faviconFailureTTL = 24 * time.Hour
+	// maxFaviconSize matches the MaxSize of the favicons.file field.
+	maxFaviconSize = 512 << 10
+	// minFaviconSize rejects empty or placeholder responses.
+	minFaviconSize = 32
+	// faviconFileMaxAge is the Cache-Control max-age of the served icons, in seconds.
+	faviconFileMaxAge = 7 * 24 * 60 * 60
+)
+
+// Favicon fallback services of FAVICON_FALLBACK_SERVICES.
+const (
+	faviconServiceGoogle     = "google"
+	faviconServiceDuckDuckGo = "duckduckgo"
+)
+
+// faviconTypes maps the accepted icon content types to file extensions.
+var faviconTypes = map[string]string{
+	"image/png":                ".png",
+	"image/jpeg":               ".jpg",
+	"image/gif":                ".gif",
+	"image/webp":               ".webp",
+	"image/x-icon":             ".ico",
+	"image/vnd.microsoft.icon": ".ico",
+	"image/svg+xml":            ".svg",
+}
+
+// faviconLocks serializes fetching per host, so that concurrent requests share one download.
+var faviconLocks sync.Map
+
+func lockFaviconHost(host string) func() {
+	value, _ := faviconLocks.LoadOrStore(host, &sync.Mutex{})
+	mu := value.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// faviconHost returns the cache key of a page URL: the lower-cased host name, with the port
+// unless it is the default one of the scheme.
+func faviconHost(pageURL *url.URL) string {
+	host := strings.TrimSuffix(strings.ToLower(pageURL.Hostname()), ".")
+	port := pageURL.Port()
+	if port == "" || (pageURL.Scheme == "http" && port == "80") || (pageURL.Scheme == "https" && port == "443") {
+		return host
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// publicBackendURL returns the URL the backend is reachable at: POCKETBASE_URL when set,
+// otherwise the scheme and host of the request.
+func publicBackendURL(e *core.RequestEvent) string {
+	if baseURL := strings.TrimSpace(os.Getenv("POCKETBASE_URL")); baseURL != "" {
+		return strings.TrimSuffix(baseURL, "/")
+	}
+	scheme := "http"
+	if e.Request.TLS != nil || strings.EqualFold(e.Request.Header.Get("X-Forwarded-Proto"), "https") {
+		scheme = "https"
+	}
+	return scheme + "://" + e.Request.Host
+}
+
+// faviconFileURL returns the URL of the cached icon of host.
+func faviconFileURL(baseURL string, host string) string {
+	return baseURL + "/api/custom/favicons/" + url.PathEscape(host)
+}
+
+// faviconCandidateScore ranks a declared icon; higher is better, negative means unusable.
+// Icons around 64px are preferred, then scalable SVG icons.
+func faviconCandidateScore(icon PageIcon) int {
+	rels := strings.Fields(icon.Rel)
+	for _, rel := range rels {
+		if rel == "mask-icon" {
+			// 单色蒙版图标，不适合直接显示
+			return -1
+		}
+	}
+
+	largest := 0
+	for _, size := range strings.Fields(strings.ToLower(icon.Sizes)) {
+		if size == "any" {
+			return 480
+		}
+		if width, _, ok := strings.Cut(size, "x"); ok {
+			if n, err := strconv.Atoi(width); err == nil && n > largest {
+				largest = n
+			}
+		}
+	}
+	if largest > 0 {
+		distance := largest - 64
+		if distance < 0 {
+			distance = -distance * 2 // 小图标放大后模糊，比大图标扣分更多
+		}
+		return max(500-distance, 0)
+	}
+	if strings.Contains(icon.Type, "svg") || strings.HasSuffix(strings.ToLower(icon.Href), ".svg") {
+		return 480
+	}
+	for _, rel := range rels {
+		if strings.HasPrefix(rel, "apple-touch-icon") {
+			return 350 // 通常为 180x180
+		}
+	}
+	return 300
+}
+
+// discoverFaviconURLs returns the icon URLs to try for a page, best first, ending with /favicon.ico.
+func discoverFaviconURLs(pageURL *url.URL) ([]string, error) {
+	pageData, err := fetchPageHTML(pageURL.String())
+	if errors.Is(err, errOutboundURLBlocked) {
+		return nil, err
+	}
+	if err != nil {
+		log.Printf("[Favicon] Failed to fetch %s, trying /favicon.ico only: %v", pageURL, err)
+	}
+
+	icons := make([]PageIcon, 0, len(pageData.Favicons))
+	for _, icon := range pageData.Favicons {
+		if faviconCandidateScore(icon) >= 0 {
+			icons = append(icons, icon)
+		}
+	}
+	sort.SliceStable(icons, func(i, j int) bool {
+		return faviconCandidateScore(icons[i]) > faviconCandidateScore(icons[j])
+	})
+
+	seen := map[string]bool{}
+	var candidates []string
+	for _, icon := range icons {
+		if !seen[icon.Href] {
+			seen[icon.Href] = true
+			candidates = append(candidates, icon.Href)
+		}
+	}
+	defaultIcon := (&url.URL{Scheme: pageURL.Scheme, Host: pageURL.Host, Path: "/favicon.ico"}).String()
+	if !seen[defaultIcon] {
+		candidates = append(candidates, defaultIcon)
+	}
+	return candidates, nil
+}
+
+// faviconFallbackURLs returns the URLs of the external services configured in
+// FAVICON_FALLBACK_SERVICES for host. Only the host name is sent to them.
+func faviconFallbackURLs(host string) []string {
+	var serviceURLs []string
+	for _, name := range strings.Split(os.Getenv("FAVICON_FALLBACK_SERVICES"), ",") {
+		switch name = strings.ToLower(strings.TrimSpace(name)); name {
+		case "":
+		case faviconServiceGoogle:
+			serviceURLs = append(serviceURLs, "https://www.google.com/s2/favicons?sz=64&domain="+url.QueryEscape(host))
+		case faviconServiceDuckDuckGo:
+			serviceURLs = append(serviceURLs, "https://icons.duckduckgo.com/ip3/"+url.PathEscape(host)+".ico")
+		default:
+			log.Printf("[Favicon] Unknown favicon service %q in FAVICON_FALLBACK_SERVICES, skipping", name)
+		}
+	}
+	return serviceURLs
+}
+
+// downloadFavicon downloads iconURL as a file for favicons.file, checking the actual content type.
+func downloadFavicon(iconURL string) (*filesystem.File, error) {
+	httpClient := newOutboundHTTPClient(10 * time.Second)
+	req, err := http.NewRequest("GET", iconURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create icon request: %w", err)
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36 MarkhubFaviconFetcher/1.0")
+	req.Header.Set("Accept", "image/avif,image/webp,image/png,image/svg+xml,image/*;q=0.8,*/*;q=0.5")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download icon: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("icon request returned status %s", resp.Status)
+	}
+	if resp.ContentLength > maxFaviconSize {
+		return nil, fmt.Errorf("icon is larger than %d bytes", maxFaviconSize)
+	}
+
+	data, err := readLimitedBody(resp.Body, maxFaviconSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read icon: %w", err)
+	}
+	if len(data) < minFaviconSize {
+		return nil, fmt.Errorf("icon is too small (%d bytes)", len(data))
+	}
+
+	// 以实际内容为准；SVG 无法通过 DetectContentType 识别，单独检查
+	contentType := http.DetectContentType(data)
+	if _, ok := faviconTypes[contentType]; !ok {
+		if !isSVGData(data) {
+			return nil, fmt.Errorf("unsupported icon type %s", contentType)
+		}
+		contentType = "image/svg+xml"
+	}
+
+	return filesystem.NewFileFromBytes(data, "favicon"+faviconTypes[contentType])
+}
+
+// isSVGData reports whether data looks like an SVG document.
+func isSVGData(data []byte) bool {
+	head := bytes.ToLower(data[:min(len(data), 1024)])
+	return bytes.Contains(head, []byte("<svg"))
+}
+
+// findFaviconRecord returns the cached icon record of host, or nil.
+func findFaviconRecord(app core.App, host string) *core.Record {
+	record, err := app.FindFirstRecordByFilter("favicons", "host = {:host}", dbx.Params{"host": host})
+	if err != nil {
+		return nil
+	}
+	return record
+}
+
+// faviconIsFresh reports whether the cached record can be used without fetching again.
+func faviconIsFresh(record *core.Record) bool {
+	fetchedAt := record.GetDateTime("fetchedAt")
+	if fetchedAt.IsZero() {
+		return false
+	}
+	ttl := faviconCacheTTL
+	if record.GetBool("failed") {
+		ttl = faviconFailureTTL
+	}
+	return time.Since(fetchedAt.Time()) < ttl
+}
+
+// ensureFavicon returns the cached icon record for the host of pageURL, fetching the icon when
+// there is none, the cache expired or force is set. The returned record may have no file when
+// no icon could be found; a previously fetched file is kept when fetching again fails.
+func ensureFavicon(app core.App, pageURL *url.URL, force bool) (*core.Record, error) {
+	host := faviconHost(pageURL)
+	if host == "" {
+		return nil, fmt.Errorf("%w: missing host", errOutboundURLBlocked)
+	}
+
+	unlock := lockFaviconHost(host)
+	defer unlock()
+
+	record := findFaviconRecord(app, host)
+	if record != nil && !force && faviconIsFresh(record) {
+		return record, nil
+	}
+
+	candidates, err := discoverFaviconURLs(pageURL)
+	if err != nil {
+		return nil, err
+	}
+	candidates = append(candidates, faviconFallbackURLs(host)...)
+
+	var file *filesystem.File
+	sourceURL := ""
+	for _, candidate := range candidates {
+		downloaded, downloadErr := downloadFavicon(candidate)
+		if downloadErr != nil {
+			log.Printf("[Favicon] %s: %s failed: %v", host, candidate, downloadErr)
+			continue
+		}
+		file, sourceURL = downloaded, candidate
+		break
+	}
+
+	if record == nil {
+		collection, err := app.FindCollectionByNameOrId("favicons")
+		if err != nil {
+			return nil, fmt.Errorf("failed to find favicons collection: %w", err)
+		}
+		record = core.NewRecord(collection)
+		record.Set("host", host)
+	}
+	record.Set("fetchedAt", types.NowDateTime())
+	record.Set("failed", file == nil)
+	if file != nil {
+		record.Set("file", file)
+		record.Set("sourceUrl", sourceURL)
+	}
+	if err := app.Save(record); err != nil {
+		return nil, fmt.Errorf("failed to save favicon of %s: %w", host, err)
+	}
+	return record, nil
+}
+
+// getFaviconHandler fetches and caches the favicon of a page's site and returns the URL it is
+// served at. When the site has no usable icon, faviconUrl is the generated placeholder icon.
+// API Endpoint: POST /api/custom/get-favicon
+// Request Body: { "url": "string", "refresh"?: bool }
+// Response (Success): { "requested_url": "string", "host": "string", "faviconUrl": "string", "placeholder": bool }
+// Response (Error): Standard PocketBase error response
+func getFaviconHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		authRecord := e.Auth
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("User not authenticated.", nil)
+		}
+
+		var requestData struct {
+			URL     string `json:"url"`
+			Refresh bool   `json:"refresh"`
+		}
+		if err := e.BindBody(&requestData); err != nil {
+			return e.BadRequestError("Failed to parse request data (expected 'url' string).", err)
+		}
+
+		requestedURL := strings.TrimSpace(requestData.URL)
+		if requestedURL == "" {
+			return e.BadRequestError("URL is required.", nil)
+		}
+		pageURL, err := url.ParseRequestURI(requestedURL)
+		if err != nil {
+			return e.BadRequestError("Invalid URL format provided.", err)
+		}
+		if err := checkOutboundScheme(pageURL); err != nil {
+			return e.BadRequestError("Only http(s) URLs are supported.", err)
+		}
+
+		host := faviconHost(pageURL)
+		baseURL := publicBackendURL(e)
+		response := map[string]interface{}{
+			"requested_url": requestedURL,
+			"host":          host,
+			"faviconUrl":    placeholderIconURL(baseURL, host),
+			"placeholder":   true,
+		}
+
+		record, err := ensureFavicon(app, pageURL, requestData.Refresh)
+		if errors.Is(err, errOutboundURLBlocked) {
+			// 内网地址不抓取图标，使用占位图标
+			log.Printf("[GetFavicon] User %s: not fetching favicon for %s: %v", authRecord.Id, requestedURL, err)
+			return e.JSON(http.StatusOK, response)
+		}
+		if err != nil {
+			return e.InternalServerError("Failed to fetch favicon.", err)
+		}
+
+		if record.GetString("file") != "" {
+			response["faviconUrl"] = faviconFileURL(baseURL, host)
+			response["placeholder"] = false
+		}
+		return e.JSON(http.StatusOK, response)
+	}
+}
+
+// faviconFileHandler serves a cached favicon. It is public so that it can be used in <img> tags,
+// and never fetches icons itself.
+// API Endpoint: GET /api/custom/favicons/{host}
+func faviconFileHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		host := strings.ToLower(e.Request.PathValue("host"))
+		record := findFaviconRecord(app, host)
+		if record == nil || record.GetString("file") == "" {
+			return e.NotFoundError("Favicon not found.", nil)
+		}
+
+		fileName := record.GetString("file")
+		fsys, err := app.NewFilesystem()
+		if err != nil {
+			return e.InternalServerError("Failed to open file storage.", err)
+		}
+		defer fsys.Close()
+
+		// 文件名随每次下载变化，可作为 ETag
+		header := e.Response.Header()
+		header.Set("Cache-Control", fmt.Sprintf("public, max-age=%d, stale-while-revalidate=86400", faviconFileMaxAge))
+		header.Set("ETag", strconv.Quote(fileName))
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("Content-Disposition", "inline; filename="+fileName)
+
+		if err := fsys.Serve(e.Response, e.Request, record.BaseFilesPath()+"/"+fileName, fileName); err != nil {
+			return e.NotFoundError("Favicon not found.", err)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// 批量刷新书签图标：后台任务把书签按主机分组，每个主机只获取一次图标（见 favicon.go），
+// 然后更新该主机下所有书签的 faviconUrl。进度保存在 favicon_jobs 集合中，
+// 客户端可以轮询任务接口，或通过 PocketBase realtime 订阅 favicon_jobs 记录。
+
+// Favicon refresh job statuses and scopes, as in the favicon_jobs collection.
+const (
+	faviconJobRunning   = "running"
+	faviconJobCompleted = "completed"
+	faviconJobFailed    = "failed"
+
+	faviconJobScopeMissing = "missing"
+	faviconJobScopeAll     = "all"
+)
+
+// faviconRefreshWorkers is the number of hosts fetched concurrently by one job.
+const faviconRefreshWorkers = 4
+
+// faviconHostGroup is the bookmarks of one host.
+type faviconHostGroup struct {
+	host      string
+	pageURL   *url.URL
+	bookmarks []*core.Record
+}
+
+// groupBookmarksByFaviconHost groups bookmarks by favicon host, returning the groups ordered by
+// host and the number of bookmarks without a usable URL.
+func groupBookmarksByFaviconHost(bookmarks []*core.Record) ([]*faviconHostGroup, int) {
+	groups := map[string]*faviconHostGroup{}
+	skipped := 0
+	for _, bookmark := range bookmarks {
+		pageURL, err := url.Parse(strings.TrimSpace(bookmark.GetString("url")))
+		if err != nil || checkOutboundScheme(pageURL) != nil {
+			skipped++
+			continue
+		}
+		host := faviconHost(pageURL)
+		group, ok := groups[host]
+		if !ok {
+			group = &faviconHostGroup{host: host, pageURL: pageURL}
+			groups[host] = group
+		}
+		group.bookmarks = append(group.bookmarks, bookmark)
+	}
+
+	result := make([]*faviconHostGroup, 0, len(groups))
+	for _, group := range groups {
+		result = append(result, group)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].host < result[j].host })
+	return result, skipped
+}
+
+// runFaviconRefreshJob refreshes the favicons of the job's bookmarks and records its progress.
+// baseURL is the public backend URL the cached icons are served from.
+func runFaviconRefreshJob(app core.App, job *core.Record, baseURL string) {
+	userId := job.GetString("userId")
+	force := job.GetBool("force")
+
+	var mu sync.Mutex
+	saveJob := func() {
+		if err := app.Save(job); err != nil {
+			log.Printf("[FaviconRefresh] Failed to save progress of job %s: %v", job.Id, err)
+		}
+	}
+	finish := func(status string, errMessage string) {
+		job.Set("status", status)
+		job.Set("error", errMessage)
+		job.Set("finishedAt", types.NowDateTime())
+		saveJob()
+	}
+
+	filter := "userId = {:userId} && url != '' && deletedAt = ''"
+	if job.GetString("scope") == faviconJobScopeMissing {
+		filter += " && faviconUrl = ''"
+	}
+	bookmarks, err := app.FindRecordsByFilter("bookmarks", filter, "", 0, 0, dbx.Params{"userId": userId})
+	if err != nil {
+		log.Printf("[FaviconRefresh] Job %s: failed to load bookmarks: %v", job.Id, err)
+		finish(faviconJobFailed, "Failed to load bookmarks.")
+		return
+	}
+
+	groups, skipped := groupBookmarksByFaviconHost(bookmarks)
+	job.Set("totalHosts", len(groups))
+	job.Set("totalBookmarks", len(bookmarks))
+	job.Set("skippedBookmarks", skipped)
+	saveJob()
+
+	// 后台任务的修改记为 system，操作者是启动任务的用户
+	auditCtx := withAuditActor(context.Background(), userId, auditSourceSystem)
+	queue := make(chan *faviconHostGroup)
+	var wg sync.WaitGroup
+	for i := 0; i < min(faviconRefreshWorkers, len(groups)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for group := range queue {
+				faviconURL := ""
+				record, err := ensureFavicon(app, group.pageURL, force)
+				if err != nil && !errors.Is(err, errOutboundURLBlocked) {
+					log.Printf("[FaviconRefresh] Job %s: failed to fetch favicon of %s: %v", job.Id, group.host, err)
+				}
+				if err == nil && record.GetString("file") != "" {
+					faviconURL = faviconFileURL(baseURL, group.host)
+				}
+
+				updated := 0
+				for _, bookmark := range group.bookmarks {
+					newURL := faviconURL
+					if newURL == "" {
+						// 没有图标时只给没有 faviconUrl 的书签设置占位图标，保留原来的地址
+						if bookmark.GetString("faviconUrl") != "" {
+							continue
+						}
+						newURL = placeholderIconURL(baseURL, group.host)
+					}
+					if bookmark.GetString("faviconUrl") == newURL {
+						continue
+					}
+					bookmark.Set("faviconUrl", newURL)
+					if err := app.SaveWithContext(auditCtx, bookmark); err != nil {
+						log.Printf("[FaviconRefresh] Job %s: failed to update bookmark %s: %v", job.Id, bookmark.Id, err)
+						continue
+					}
+					updated++
+				}
+
+				mu.Lock()
+				job.Set("processedHosts", job.GetInt("processedHosts")+1)
+				job.Set("updatedBookmarks", job.GetInt("updatedBookmarks")+updated)
+				if faviconURL == "" {
+					job.Set("failedHosts", job.GetInt("failedHosts")+1)
+				}
+				saveJob()
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, group := range groups {
+		queue <- group
+	}
+	close(queue)
+	wg.Wait()
+
+	finish(faviconJobCompleted, "")
+	log.Printf("[FaviconRefresh] Job %s finished: %d hosts, %d bookmarks updated", job.Id, len(groups), job.GetInt("updatedBookmarks"))
+}
+
+// faviconJobResponse is the JSON representation of a job record.
+func faviconJobResponse(job *core.Record) map[string]interface{} {
+	response := map[string]interface{}{
+		"id":               job.Id,
+		"status":           job.GetString("status"),
+		"scope":            job.GetString("scope"),
+		"force":            job.GetBool("force"),
+		"totalHosts":       job.GetInt("totalHosts"),
+		"processedHosts":   job.GetInt("processedHosts"),
+		"failedHosts":      job.GetInt("failedHosts"),
+		"totalBookmarks":   job.GetInt("totalBookmarks"),
+		"updatedBookmarks": job.GetInt("updatedBookmarks"),
+		"skippedBookmarks": job.GetInt("skippedBookmarks"),
+		"error":            job.GetString("error"),
+		"createdAt":        job.GetDateTime("createdAt"),
+		"finishedAt":       job.GetDateTime("finishedAt"),
+	}
+	if total := job.GetInt("totalHosts"); total > 0 {
+		response["progress"] = float64(job.GetInt("processedHosts")) / float64(total)
+	} else if job.GetString("status") == faviconJobCompleted {
+		response["progress"] = 1.0
+	} else {
+		response["progress"] = 0.0
+	}
+	return response
+}
+
+// refreshFaviconsHandler starts a background job that refreshes the favicons of the user's
+// bookmarks. Only one job per user can run at a time.
+// API Endpoint: POST /api/custom/bookmarks/refresh-favicons
+// Request: { "scope": "missing" | "all", "force": bool } (scope defaults to "missing"; force
+// re-fetches icons that are still cached)
+// Response (202): the job, see refreshFaviconsStatusHandler
+func refreshFaviconsHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		authRecord := e.Auth
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("User not authenticated.", nil)
+		}
+
+		var requestData struct {
+			Scope string `json:"scope"`
+			Force bool   `json:"force"`
+		}
+		if err := e.BindBody(&requestData); err != nil {
+			return e.BadRequestError("Failed to parse request data.", err)
+		}
+		scope := requestData.Scope
+		if scope == "" {
+			scope = faviconJobScopeMissing
+		}
+		if scope != faviconJobScopeMissing && scope != faviconJobScopeAll {
+			return e.BadRequestError("scope must be 'missing' or 'all'.", nil)
+		}
+
+		running, err := app.FindFirstRecordByFilter(
+			"favicon_jobs",
+			"userId = {:userId} && status = {:status}",
+			dbx.Params{"userId": authRecord.Id, "status": faviconJobRunning},
+		)
+		if err == nil {
+			return e.JSON(http.StatusConflict, map[string]interface{}{
+				"message": "A favicon refresh is already running.",
+				"job":     faviconJobResponse(running),
+			})
+		}
+
+		collection, err := app.FindCollectionByNameOrId("favicon_jobs")
+		if err != nil {
+			return e.InternalServerError("Failed to find favicon_jobs collection", err)
+		}
+		job := core.NewRecord(collection)
+		job.Set("userId", authRecord.Id)
+		job.Set("status", faviconJobRunning)
+		job.Set("scope", scope)
+		job.Set("force", requestData.Force)
+		if err := app.Save(job); err != nil {
+			return e.InternalServerError("Failed to create favicon refresh job", err)
+		}
+
+		go runFaviconRefreshJob(app, job, publicBackendURL(e))
+
+		return e.JSON(http.StatusAccepted, faviconJobResponse(job))
+	}
+}
+
+// refreshFaviconsStatusHandler returns the progress of a favicon refresh job.
+// API Endpoint: GET /api/custom/bookmarks/refresh-favicons/{jobId}
+// Response: { "id", "status", "scope", "force", "totalHosts", "processedHosts", "failedHosts",
+// "totalBookmarks", "updatedBookmarks", "skippedBookmarks", "progress", "error", "createdAt", "finishedAt" }
+func refreshFaviconsStatusHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		authRecord := e.Auth
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("User not authenticated.", nil)
+		}
+
+		job, err := app.FindFirstRecordByFilter(
+			"favicon_jobs",
+			"id = {:id} && userId = {:userId}",
+			dbx.Params{"id": e.Request.PathValue("jobId"), "userId": authRecord.Id},
+		)
+		if err != nil {
+			return e.NotFoundError("Favicon refresh job not found.", err)
+		}
+		return e.JSON(http.StatusOK, faviconJobResponse(job))
+	}
+}
+
+// registerFaviconRefreshHooks marks jobs that were running when the server stopped as failed.
+func registerFaviconRefreshHooks(app *pocketbase.PocketBase) {
+	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
+		jobs, err := app.FindRecordsByFilter("favicon_jobs", "status = {:status}", "", 0, 0, dbx.Params{"status": faviconJobRunning})
+		if err != nil {
+			log.Printf("[FaviconRefresh] Failed to load interrupted jobs: %v", err)
+			return se.Next()
+		}
+		for _, job := range jobs {
+			job.Set("status", faviconJobFailed)
+			job.Set("error", "Interrupted by a server restart.")
+			job.Set("finishedAt", types.NowDateTime())
+			if err := app.Save(job); err != nil {
+				log.Printf("[FaviconRefresh] Failed to mark job %s as interrupted: %v", job.Id, err)
+			}
+		}
+		return se.Next()
+	})
+}
@@ -0,0 +1,515 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 文件夹公开链接：所有者为文件夹生成随机令牌，持有链接的人无需账号即可只读查看文件夹中的书签（JSON 或 HTML 页面）。
+// 链接可以随时撤销（删除），可以设置过期时间和访问密码（只保存 bcrypt 哈希）。
+
+const (
+	// folderLinkTokenBytes is the number of random bytes of a link token.
+	folderLinkTokenBytes = 24
+	// maxFolderLinkPasswordLength is the longest password bcrypt accepts.
+	maxFolderLinkPasswordLength = 72
+	// folderLinkPasswordHeader carries the password of protected links in JSON requests.
+	folderLinkPasswordHeader = "X-Share-Password"
+)
+
+// 公开链接无需登录，限制错误密码的尝试次数，防止在线暴力破解（每次尝试都要做一次 bcrypt 比较）
+const (
+	// folderLinkFailureWindow is the period over which failed password attempts are counted.
+	folderLinkFailureWindow = 15 * time.Minute
+	// maxFolderLinkFailuresPerIP limits the failed attempts of one client address, over all links.
+	maxFolderLinkFailuresPerIP = 10
+	// maxFolderLinkFailuresPerToken limits the failed attempts on one link, over all addresses.
+	maxFolderLinkFailuresPerToken = 50
+)
+
+// folderLinkFailureCount is the number of failed attempts of one key in the current window.
+type folderLinkFailureCount struct {
+	windowStart time.Time
+	count       int
+}
+
+// folderLinkFailures counts failed password attempts by "ip:<address>" and "token:<token>".
+var folderLinkFailures = struct {
+	sync.Mutex
+	counts map[string]*folderLinkFailureCount
+}{counts: map[string]*folderLinkFailureCount{}}
+
+// folderLinkAttemptsBlocked reports whether the client or the link has too many recent failed
+// password attempts.
+func folderLinkAttemptsBlocked(ip string, token string) bool {
+	folderLinkFailures.Lock()
+	defer folderLinkFailures.Unlock()
+
+	now := time.Now()
+	blocked := func(key string, limit int) bool {
+		entry, ok := folderLinkFailures.counts[key]
+		return ok && now.Sub(entry.windowStart) < folderLinkFailureWindow && entry.count >= limit
+	}
+	return blocked("ip:"+ip, maxFolderLinkFailuresPerIP) || blocked("token:"+token, maxFolderLinkFailuresPerToken)
+}
+
+// recordFolderLinkFailure counts a failed password attempt of the client on the link.
+func recordFolderLinkFailure(ip string, token string) {
+	folderLinkFailures.Lock()
+	defer folderLinkFailures.Unlock()
+
+	now := time.Now()
+	// 清理过期的计数，避免内存无限增长
+	for key, entry := range folderLinkFailures.counts {
+		if now.Sub(entry.windowStart) >= folderLinkFailureWindow {
+			delete(folderLinkFailures.counts, key)
+		}
+	}
+	for _, key := range []string{"ip:" + ip, "token:" + token} {
+		entry, ok := folderLinkFailures.counts[key]
+		if !ok {
+			entry = &folderLinkFailureCount{windowStart: now}
+			folderLinkFailures.counts[key] = entry
+		}
+		entry.count++
+	}
+}
+
+// newFolderLinkToken generates a random URL-safe link token.
+func newFolderLinkToken() (string, error) {
+	buf := make([]byte, folderLinkTokenBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// publicFolderLinkURL returns the URL of the JSON endpoint of a link token.
+func publicFolderLinkURL(baseURL string, token string) string {
+	return baseURL + "/api/custom/public/folder-links/" + url.PathEscape(token)
+}
+
+// folderLinkResponse is the JSON representation of a link for its owner.
+func folderLinkResponse(link *core.Record, baseURL string) map[string]interface{} {
+	linkURL := publicFolderLinkURL(baseURL, link.GetString("token"))
+	response := map[string]interface{}{
+		"id":               link.Id,
+		"folderId":         link.GetString("folderId"),
+		"token":            link.GetString("token"),
+		"url":              linkURL,
+		"htmlUrl":          linkURL + "/html",
+		"passwordRequired": link.GetString("passwordHash") != "",
+		"expiresAt":        nil,
+		"expired":          folderLinkExpired(link),
+		"viewCount":        link.GetInt("viewCount"),
+		"lastViewedAt":     nil,
+		"createdAt":        link.GetDateTime("createdAt"),
+	}
+	if expiresAt := link.GetDateTime("expiresAt"); !expiresAt.IsZero() {
+		response["expiresAt"] = expiresAt
+	}
+	if lastViewedAt := link.GetDateTime("lastViewedAt"); !lastViewedAt.IsZero() {
+		response["lastViewedAt"] = lastViewedAt
+	}
+	return response
+}
+
+// folderLinkExpired reports whether the link has an expiry time in the past.
+func folderLinkExpired(link *core.Record) bool {
+	expiresAt := link.GetDateTime("expiresAt")
+	return !expiresAt.IsZero() && !expiresAt.Time().After(time.Now())
+}
+
+// createFolderLinkHandler creates a public read-only link for a folder. Only the folder's owner
+// can create links.
+// API Endpoint: POST /api/custom/folders/{folderId}/links
+// Request: { "password": "string", "expiresAt": "RFC 3339 time", "expiresInDays": number } (all optional;
+// expiresInDays is ignored when expiresAt is given)
+// Response (201): { "id", "folderId", "token", "url", "htmlUrl", "passwordRequired", "expiresAt", "expired",
+// "viewCount", "lastViewedAt", "createdAt" }
+func createFolderLinkHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		authRecord := e.Auth
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("User not authenticated.", nil)
+		}
+
+		var requestData struct {
+			Password      string `json:"password"`
+			ExpiresAt     string `json:"expiresAt"`
+			ExpiresInDays int    `json:"expiresInDays"`
+		}
+		if err := e.BindBody(&requestData); err != nil {
+			return e.BadRequestError("Failed to parse request data.", err)
+		}
+
+		var expiresAt types.DateTime
+		switch {
+		case requestData.ExpiresAt != "":
+			parsed, err := types.ParseDateTime(requestData.ExpiresAt)
+			if err != nil || parsed.IsZero() {
+				return e.BadRequestError("Invalid expiresAt.", err)
+			}
+			expiresAt = parsed
+		case requestData.ExpiresInDays < 0:
+			return e.BadRequestError("expiresInDays must not be negative.", nil)
+		case requestData.ExpiresInDays > 0:
+			expiresAt, _ = types.ParseDateTime(time.Now().AddDate(0, 0, requestData.ExpiresInDays))
+		}
+		if !expiresAt.IsZero() && !expiresAt.Time().After(time.Now()) {
+			return e.BadRequestError("expiresAt must be in the future.", nil)
+		}
+		if len(requestData.Password) > maxFolderLinkPasswordLength {
+			return e.BadRequestError(fmt.Sprintf("password must be at most %d bytes.", maxFolderLinkPasswordLength), nil)
+		}
+
+		folder, err := findOwnedFolder(app, e, authRecord.Id)
+		if err != nil {
+			return err
+		}
+
+		collection, err := app.FindCollectionByNameOrId("folder_links")
+		if err != nil {
+			return e.InternalServerError("Failed to find folder_links collection", err)
+		}
+		token, err := newFolderLinkToken()
+		if err != nil {
+			return e.InternalServerError("Failed to generate link token", err)
+		}
+
+		link := core.NewRecord(collection)
+		link.Set("folderId", folder.Id)
+		link.Set("userId", authRecord.Id)
+		link.Set("token", token)
+		if requestData.Password != "" {
+			hash, err := bcrypt.GenerateFromPassword([]byte(requestData.Password), bcrypt.DefaultCost)
+			if err != nil {
+				return e.InternalServerError("Failed to hash link password", err)
+			}
+			link.Set("passwordHash", string(hash))
+		}
+		if !expiresAt.IsZero() {
+			link.Set("expiresAt", expiresAt)
+		}
+		if err := app.Save(link); err != nil {
+			return e.InternalServerError("Failed to save folder link", err)
+		}
+
+		return e.JSON(http.StatusCreated, folderLinkResponse(link, publicBackendURL(e)))
+	}
+}
+
+// listFolderLinksHandler lists the public links of a folder.
+// API Endpoint: GET /api/custom/folders/{folderId}/links
+// Response: { "items": [link, ...] }
+func listFolderLinksHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		authRecord := e.Auth
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("User not authenticated.", nil)
+		}
+
+		folder, err := findOwnedFolder(app, e, authRecord.Id)
+		if err != nil {
+			return err
+		}
+
+		links, err := app.FindRecordsByFilter("folder_links", "folderId = {:folderId}", "-createdAt", 0, 0, dbx.Params{"folderId": folder.Id})
+		if err != nil {
+			return e.InternalServerError("Failed to fetch folder links", err)
+		}
+		baseURL := publicBackendURL(e)
+		items := make([]map[string]interface{}, 0, len(links))
+		for _, link := range links {
+			items = append(items, folderLinkResponse(link, baseURL))
+		}
+		return e.JSON(http.StatusOK, map[string]interface{}{"items": items})
+	}
+}
+
+// revokeFolderLinkHandler revokes a public link. The token stops working immediately.
+// API Endpoint: DELETE /api/custom/folders/{folderId}/links/{linkId}
+func revokeFolderLinkHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		authRecord := e.Auth
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("User not authenticated.", nil)
+		}
+
+		link, err := app.FindFirstRecordByFilter(
+			"folder_links",
+			"id = {:id} && folderId = {:folderId} && userId = {:userId}",
+			dbx.Params{"id": e.Request.PathValue("linkId"), "folderId": e.Request.PathValue("folderId"), "userId": authRecord.Id},
+		)
+		if err != nil {
+			return e.NotFoundError("Folder link not found.", err)
+		}
+		if err := app.Delete(link); err != nil {
+			return e.InternalServerError("Failed to revoke folder link", err)
+		}
+		return e.NoContent(http.StatusNoContent)
+	}
+}
+
+// publicFolderBookmark is a bookmark as shown through a public link.
+type publicFolderBookmark struct {
+	Title       string   `json:"title"`
+	URL         string   `json:"url"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+	FaviconURL  string   `json:"faviconUrl"`
+}
+
+// publicFolderData is the content of a folder shown through a public link.
+type publicFolderData struct {
+	Name      string                 `json:"name"`
+	Bookmarks []publicFolderBookmark `json:"bookmarks"`
+	ExpiresAt *types.DateTime        `json:"expiresAt"`
+}
+
+// folderLinkAccess resolves a public link token and checks its password, limiting the failed
+// attempts of the client at ip. On failure it returns the status, message and whether the request
+// should ask for a password.
+func folderLinkAccess(app core.App, token string, password string, ip string) (*core.Record, int, string, bool) {
+	link, err := app.FindFirstRecordByFilter("folder_links", "token = {:token}", dbx.Params{"token": token})
+	if err != nil || token == "" {
+		return nil, http.StatusNotFound, "This link does not exist or has been revoked.", false
+	}
+	if folder, err := app.FindRecordById("folders", link.GetString("folderId")); err != nil || isTrashed(folder) {
+		return nil, http.StatusNotFound, "This link does not exist or has been revoked.", false
+	}
+	if folderLinkExpired(link) {
+		return nil, http.StatusGone, "This link has expired.", false
+	}
+	if hash := link.GetString("passwordHash"); hash != "" {
+		if password == "" {
+			return nil, http.StatusUnauthorized, "This link is password protected.", true
+		}
+		if folderLinkAttemptsBlocked(ip, token) {
+			return nil, http.StatusTooManyRequests, "Too many incorrect passwords. Try again later.", true
+		}
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+			recordFolderLinkFailure(ip, token)
+			return nil, http.StatusUnauthorized, "Incorrect password.", true
+		}
+	}
+	return link, http.StatusOK, "", false
+}
+
+// loadPublicFolderData loads the folder and bookmarks of a link and records the view.
+func loadPublicFolderData(app core.App, link *core.Record) (*publicFolderData, error) {
+	folder, err := app.FindRecordById("folders", link.GetString("folderId"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load folder: %w", err)
+	}
+	records, err := app.FindRecordsByFilter("bookmarks", "folderId = {:folderId} && deletedAt = ''", "title", 0, 0, dbx.Params{"folderId": folder.Id})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load bookmarks: %w", err)
+	}
+
+	data := &publicFolderData{
+		Name:      folder.GetString("name"),
+		Bookmarks: make([]publicFolderBookmark, 0, len(records)),
+	}
+	if expiresAt := link.GetDateTime("expiresAt"); !expiresAt.IsZero() {
+		data.ExpiresAt = &expiresAt
+	}
+	for _, record := range records {
+		tags := record.GetStringSlice("tags")
+		if tags == nil {
+			tags = []string{}
+		}
+		data.Bookmarks = append(data.Bookmarks, publicFolderBookmark{
+			Title:       record.GetString("title"),
+			URL:         record.GetString("url"),
+			Description: record.GetString("description"),
+			Tags:        tags,
+			FaviconURL:  record.GetString("faviconUrl"),
+		})
+	}
+
+	// 原子递增，并发访问时不会丢失计数
+	_, err = app.DB().NewQuery("UPDATE {{folder_links}} SET [[viewCount]] = [[viewCount]] + 1, [[lastViewedAt]] = {:now} WHERE [[id]] = {:id}").
+		Bind(dbx.Params{"now": types.NowDateTime().String(), "id": link.Id}).
+		Execute()
+	if err != nil {
+		log.Printf("Failed to record view of folder link %s: %v", link.Id, err)
+	}
+	return data, nil
+}
+
+// setPublicFolderHeaders sets the caching and indexing headers of public link responses.
+func setPublicFolderHeaders(e *core.RequestEvent) {
+	header := e.Response.Header()
+	header.Set("Cache-Control", "no-store")
+	header.Set("X-Robots-Tag", "noindex, nofollow")
+	header.Set("Referrer-Policy", "no-referrer")
+	header.Set("X-Content-Type-Options", "nosniff")
+}
+
+// publicFolderLinkHandler returns the bookmarks of a folder shared through a public link as JSON.
+// Protected links need the password in the X-Share-Password header or in a POST body.
+// API Endpoint: GET|POST /api/custom/public/folder-links/{token}
+// Request (POST): { "password": "string" }
+// Response: { "name", "expiresAt", "bookmarks": [{ "title", "url", "description", "tags", "faviconUrl" }] }
+// Errors: 401 { "passwordRequired": true } for a missing or wrong password, 429 too many wrong passwords, 404 unknown or revoked, 410 expired
+func publicFolderLinkHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		setPublicFolderHeaders(e)
+
+		password := e.Request.Header.Get(folderLinkPasswordHeader)
+		if e.Request.Method == http.MethodPost {
+			var requestData struct {
+				Password string `json:"password" form:"password"`
+			}
+			if err := e.BindBody(&requestData); err != nil {
+				return e.BadRequestError("Failed to parse request data.", err)
+			}
+			if requestData.Password != "" {
+				password = requestData.Password
+			}
+		}
+
+		link, status, message, passwordRequired := folderLinkAccess(app, e.Request.PathValue("token"), password, e.RealIP())
+		if link == nil {
+			return e.JSON(status, map[string]interface{}{
+				"status":           status,
+				"message":          message,
+				"passwordRequired": passwordRequired,
+			})
+		}
+
+		data, err := loadPublicFolderData(app, link)
+		if err != nil {
+			return e.InternalServerError("Failed to load shared folder", err)
+		}
+		return e.JSON(http.StatusOK, data)
+	}
+}
+
+// publicFolderPageTemplate renders a shared folder, or the password form / error of a link.
+var publicFolderPageTemplate = template.Must(template.New("folder").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex, nofollow">
+<title>{{if .Folder}}{{.Folder.Name}}{{else}}Shared folder{{end}}</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, "Helvetica Neue", Arial, "Noto Sans", "PingFang SC", "Microsoft YaHei", sans-serif; max-width: 760px; margin: 0 auto; padding: 24px 16px; color: #1f2937; background: #f9fafb; }
+h1 { font-size: 1.5rem; margin: 0 0 4px; }
+.meta { color: #6b7280; font-size: 0.875rem; margin-bottom: 24px; }
+ul { list-style: none; padding: 0; margin: 0; }
+li { background: #fff; border: 1px solid #e5e7eb; border-radius: 8px; padding: 12px 14px; margin-bottom: 10px; }
+.title { display: flex; align-items: center; gap: 8px; font-weight: 600; }
+.title img { width: 16px; height: 16px; flex: none; }
+a { color: #2563eb; text-decoration: none; word-break: break-word; }
+a:hover { text-decoration: underline; }
+.url { color: #6b7280; font-size: 0.8rem; word-break: break-all; margin-top: 2px; }
+.description { margin: 6px 0 0; font-size: 0.9rem; }
+.tags { margin-top: 6px; }
+.tag { display: inline-block; background: #eef2ff; color: #4338ca; border-radius: 999px; padding: 1px 8px; font-size: 0.75rem; margin: 2px 4px 0 0; }
+.message { background: #fff; border: 1px solid #e5e7eb; border-radius: 8px; padding: 20px; }
+input[type=password] { padding: 8px; border: 1px solid #d1d5db; border-radius: 6px; width: 220px; max-width: 100%; }
+button { padding: 8px 14px; border: 0; border-radius: 6px; background: #2563eb; color: #fff; cursor: pointer; }
+.error { color: #b91c1c; }
+</style>
+</head>
+<body>
+{{if .Folder}}
+<h1>{{.Folder.Name}}</h1>
+<div class="meta">{{len .Folder.Bookmarks}} bookmark{{if ne (len .Folder.Bookmarks) 1}}s{{end}}{{if .Folder.ExpiresAt}} · available until {{.Folder.ExpiresAt.Time.Format "2006-01-02 15:04 MST"}}{{end}}</div>
+{{if .Folder.Bookmarks}}
+<ul>
+{{range .Folder.Bookmarks}}
+<li>
+<div class="title">{{if .FaviconURL}}<img src="{{.FaviconURL}}" alt="" loading="lazy">{{end}}<a href="{{.URL}}" rel="noopener noreferrer nofollow" target="_blank">{{if .Title}}{{.Title}}{{else}}{{.URL}}{{end}}</a></div>
+<div class="url">{{.URL}}</div>
+{{if .Description}}<p class="description">{{.Description}}</p>{{end}}
+{{if .Tags}}<div class="tags">{{range .Tags}}<span class="tag">{{.}}</span>{{end}}</div>{{end}}
+</li>
+{{end}}
+</ul>
+{{else}}
+<div class="message">This folder is empty.</div>
+{{end}}
+{{else if .PasswordRequired}}
+<div class="message">
+<p>This shared folder is password protected.</p>
+{{if .Message}}{{if .PasswordSent}}<p class="error">{{.Message}}</p>{{end}}{{end}}
+<form method="post">
+<input type="password" name="password" placeholder="Password" autofocus required>
+<button type="submit">Open</button>
+</form>
+</div>
+{{else}}
+<div class="message">{{.Message}}</div>
+{{end}}
+</body>
+</html>
+`))
+
+// publicFolderLinkHTMLHandler renders a folder shared through a public link as an HTML page.
+// Protected links show a password form that posts back to the same URL.
+// API Endpoint: GET|POST /api/custom/public/folder-links/{token}/html
+func publicFolderLinkHTMLHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		setPublicFolderHeaders(e)
+		header := e.Response.Header()
+		header.Set("Content-Security-Policy", "default-src 'none'; img-src * data:; style-src 'unsafe-inline'; form-action 'self'; base-uri 'none'; frame-ancestors 'none'")
+
+		password := ""
+		if e.Request.Method == http.MethodPost {
+			var requestData struct {
+				Password string `json:"password" form:"password"`
+			}
+			if err := e.BindBody(&requestData); err != nil {
+				return e.BadRequestError("Failed to parse request data.", err)
+			}
+			password = requestData.Password
+		}
+
+		page := struct {
+			Folder           *publicFolderData
+			Message          string
+			PasswordRequired bool
+			PasswordSent     bool
+		}{PasswordSent: strings.TrimSpace(password) != ""}
+
+		link, status, message, passwordRequired := folderLinkAccess(app, e.Request.PathValue("token"), password, e.RealIP())
+		if link == nil {
+			page.Message = message
+			page.PasswordRequired = passwordRequired
+		} else {
+			data, err := loadPublicFolderData(app, link)
+			if err != nil {
+				log.Printf("Failed to load shared folder of link %s: %v", link.Id, err)
+				status = http.StatusInternalServerError
+				page.Message = "Failed to load the shared folder."
+			} else {
+				page.Folder = data
+			}
+		}
+
+		var body strings.Builder
+		if err := publicFolderPageTemplate.Execute(&body, page); err != nil {
+			return e.InternalServerError("Failed to render shared folder", err)
+		}
+		return e.HTML(status, body.String())
+	}
+}
@@ -0,0 +1,526 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// 共享文件夹：所有者通过 folder_shares 把文件夹及其子树共享给其他用户（viewer 只读，editor 可增删改）。
+// 集合规则无法递归判断子树，因此 folder_shares 变化或文件夹结构变化时，syncFolderAccess 把共享展开为
+// folder_access（每个用户对每个文件夹一条），folders/bookmarks 的规则据此判断访问权限。
+// 共享文件夹中的书签和子文件夹始终属于文件夹所有者。
+
+// Folder roles. folderRoleOwner is never stored; it is returned for the owner's own folders.
+const (
+	folderRoleOwner  = "owner"
+	folderRoleEditor = "editor"
+	folderRoleViewer = "viewer"
+)
+
+// folderAccessRole returns the role of userId on folderId, or "" without access.
+func folderAccessRole(app core.App, userId string, folderId string) string {
+	if folderId == "" || userId == "" {
+		return ""
+	}
+	folder, err := app.FindRecordById("folders", folderId)
+	if err != nil || isTrashed(folder) {
+		return ""
+	}
+	if folder.GetString("userId") == userId {
+		return folderRoleOwner
+	}
+	access, err := app.FindFirstRecordByFilter(
+		"folder_access",
+		"folderId = {:folderId} && userId = {:userId}",
+		dbx.Params{"folderId": folderId, "userId": userId},
+	)
+	if err != nil {
+		return ""
+	}
+	return access.GetString("role")
+}
+
+// bookmarkAccessRole returns the role of userId on bookmark, or "" without access. For workspace
+// bookmarks it is the user's workspace role.
+func bookmarkAccessRole(app core.App, userId string, bookmark *core.Record) string {
+	if workspaceId := bookmark.GetString("workspaceId"); workspaceId != "" {
+		return workspaceMemberRole(app, workspaceId, userId)
+	}
+	if bookmark.GetString("userId") == userId {
+		return folderRoleOwner
+	}
+	role := folderAccessRole(app, userId, bookmark.GetString("folderId"))
+	if role == folderRoleOwner {
+		// 书签属于其他用户但放在当前用户的文件夹中，不应出现
+		return ""
+	}
+	return role
+}
+
+// canEditBookmark reports whether userId may modify bookmark.
+func canEditBookmark(app core.App, userId string, bookmark *core.Record) bool {
+	if isTrashed(bookmark) {
+		return false
+	}
+	role := bookmarkAccessRole(app, userId, bookmark)
+	return role == folderRoleOwner || role == folderRoleEditor || role == workspaceRoleAdmin
+}
+
+// folderAccessEntry is the desired folder_access row of one user on one folder.
+type folderAccessEntry struct {
+	role    string
+	shareId string
+	isRoot  bool
+}
+
+// syncFolderAccess recomputes the folder_access rows of ownerId's folders from its shares.
+// A user shared at several levels of a subtree gets the strongest role.
+func syncFolderAccess(app core.App, ownerId string) error {
+	shares, err := app.FindRecordsByFilter("folder_shares", "ownerId = {:ownerId}", "createdAt", 0, 0, dbx.Params{"ownerId": ownerId})
+	if err != nil {
+		return fmt.Errorf("failed to load folder shares: %w", err)
+	}
+	existing, err := app.FindRecordsByFilter("folder_access", "ownerId = {:ownerId}", "", 0, 0, dbx.Params{"ownerId": ownerId})
+	if err != nil {
+		return fmt.Errorf("failed to load folder access: %w", err)
+	}
+	if len(shares) == 0 && len(existing) == 0 {
+		return nil
+	}
+
+	folders, err := app.FindRecordsByFilter("folders", "userId = {:ownerId}", "", 0, 0, dbx.Params{"ownerId": ownerId})
+	if err != nil {
+		return fmt.Errorf("failed to load folders: %w", err)
+	}
+	children := map[string][]string{}
+	parents := map[string]string{}
+	for _, folder := range folders {
+		parentId := folder.GetString("parentId")
+		parents[folder.Id] = parentId
+		children[parentId] = append(children[parentId], folder.Id)
+	}
+
+	// desired[userId][folderId]
+	desired := map[string]map[string]folderAccessEntry{}
+	for _, share := range shares {
+		userId := share.GetString("userId")
+		role := share.GetString("role")
+		if desired[userId] == nil {
+			desired[userId] = map[string]folderAccessEntry{}
+		}
+		stack := []string{share.GetString("folderId")}
+		visited := map[string]bool{}
+		for len(stack) > 0 {
+			folderId := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if visited[folderId] {
+				continue
+			}
+			visited[folderId] = true
+			if current, ok := desired[userId][folderId]; !ok || (role == folderRoleEditor && current.role != folderRoleEditor) {
+				desired[userId][folderId] = folderAccessEntry{role: role, shareId: share.Id}
+			}
+			stack = append(stack, children[folderId]...)
+		}
+	}
+	for _, entries := range desired {
+		for folderId, entry := range entries {
+			_, parentShared := entries[parents[folderId]]
+			entry.isRoot = !parentShared
+			entries[folderId] = entry
+		}
+	}
+
+	collection, err := app.FindCollectionByNameOrId("folder_access")
+	if err != nil {
+		return fmt.Errorf("failed to find folder_access collection: %w", err)
+	}
+
+	return app.RunInTransaction(func(txApp core.App) error {
+		for _, access := range existing {
+			userId := access.GetString("userId")
+			entry, ok := desired[userId][access.GetString("folderId")]
+			if !ok {
+				if err := txApp.Delete(access); err != nil {
+					return fmt.Errorf("failed to delete folder access %s: %w", access.Id, err)
+				}
+				continue
+			}
+			delete(desired[userId], access.GetString("folderId"))
+			if access.GetString("role") == entry.role && access.GetString("shareId") == entry.shareId && access.GetBool("isRoot") == entry.isRoot {
+				continue
+			}
+			access.Set("role", entry.role)
+			access.Set("shareId", entry.shareId)
+			access.Set("isRoot", entry.isRoot)
+			if err := txApp.Save(access); err != nil {
+				return fmt.Errorf("failed to update folder access %s: %w", access.Id, err)
+			}
+		}
+		for userId, entries := range desired {
+			for folderId, entry := range entries {
+				access := core.NewRecord(collection)
+				access.Set("folderId", folderId)
+				access.Set("ownerId", ownerId)
+				access.Set("userId", userId)
+				access.Set("shareId", entry.shareId)
+				access.Set("role", entry.role)
+				access.Set("isRoot", entry.isRoot)
+				if err := txApp.Save(access); err != nil {
+					return fmt.Errorf("failed to create folder access for folder %s: %w", folderId, err)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// checkSharedFolderWrite validates a create or update of a folder (parentField "parentId") or
+// bookmark (parentField "folderId") by a regular user: the target folder must belong to the
+// record's owner, and users other than the owner need editor access on it. Records created by
+// an editor in a shared folder are given to the folder's owner. It runs after the hooks in
+// main.go, which set userId to the creator on create and keep the owner on update.
+func checkSharedFolderWrite(e *core.RecordRequestEvent, parentField string, isCreate bool) error {
+	// 工作区中的记录按成员角色检查，见 checkWorkspaceWrite
+	if e.Auth == nil || e.HasSuperuserAuth() || e.Record.GetString("workspaceId") != "" {
+		return nil
+	}
+	actorId := e.Auth.Id
+	record := e.Record
+
+	targetId := record.GetString(parentField)
+	var target *core.Record
+	if targetId != "" {
+		var err error
+		if target, err = e.App.FindRecordById("folders", targetId); err != nil {
+			return apis.NewBadRequestError("Folder not found.", err)
+		}
+	}
+
+	if isCreate {
+		if target != nil && target.GetString("userId") != record.GetString("userId") {
+			if record.GetString("userId") != actorId {
+				return apis.NewBadRequestError("The folder belongs to another user.", nil)
+			}
+			// 在共享文件夹中新建，记录属于文件夹所有者
+			record.Set("userId", target.GetString("userId"))
+		}
+		if record.GetString("userId") != actorId && folderAccessRole(e.App, actorId, targetId) != folderRoleEditor {
+			return apis.NewForbiddenError("You need editor access to this shared folder.", nil)
+		}
+		return nil
+	}
+
+	// 更新时所有者保持不变（见 main.go 中的钩子）
+	original := record.Original()
+	if targetId == original.GetString(parentField) {
+		return nil
+	}
+	if target != nil && target.GetString("userId") != record.GetString("userId") {
+		return apis.NewBadRequestError("Items can't be moved into a folder of another user.", nil)
+	}
+	if actorId != record.GetString("userId") && folderAccessRole(e.App, actorId, targetId) != folderRoleEditor {
+		return apis.NewForbiddenError("You need editor access to the target folder.", nil)
+	}
+	return nil
+}
+
+// registerFolderSharingHooks keeps folder_access in sync and checks writes to shared folders.
+func registerFolderSharingHooks(app *pocketbase.PocketBase) {
+	syncOwner := func(e *core.RecordEvent, ownerField string) {
+		if err := syncFolderAccess(e.App, e.Record.GetString(ownerField)); err != nil {
+			log.Printf("Failed to update shared folder access of user %s: %v", e.Record.GetString(ownerField), err)
+		}
+	}
+
+	// 文件夹新建、移动或删除后，更新所有者的共享子树
+	app.OnRecordAfterCreateSuccess("folders").BindFunc(func(e *core.RecordEvent) error {
+		syncOwner(e, "userId")
+		return e.Next()
+	})
+	app.OnRecordAfterUpdateSuccess("folders").BindFunc(func(e *core.RecordEvent) error {
+		if e.Record.GetString("parentId") != e.Record.Original().GetString("parentId") {
+			syncOwner(e, "userId")
+		}
+		return e.Next()
+	})
+	app.OnRecordAfterDeleteSuccess("folders").BindFunc(func(e *core.RecordEvent) error {
+		syncOwner(e, "userId")
+		return e.Next()
+	})
+	app.OnRecordAfterCreateSuccess("folder_shares").BindFunc(func(e *core.RecordEvent) error {
+		syncOwner(e, "ownerId")
+		return e.Next()
+	})
+	app.OnRecordAfterUpdateSuccess("folder_shares").BindFunc(func(e *core.RecordEvent) error {
+		syncOwner(e, "ownerId")
+		return e.Next()
+	})
+	app.OnRecordAfterDeleteSuccess("folder_shares").BindFunc(func(e *core.RecordEvent) error {
+		syncOwner(e, "ownerId")
+		return e.Next()
+	})
+
+	app.OnRecordCreateRequest("folders").BindFunc(func(e *core.RecordRequestEvent) error {
+		if err := checkSharedFolderWrite(e, "parentId", true); err != nil {
+			return err
+		}
+		return e.Next()
+	})
+	app.OnRecordUpdateRequest("folders").BindFunc(func(e *core.RecordRequestEvent) error {
+		if err := checkSharedFolderWrite(e, "parentId", false); err != nil {
+			return err
+		}
+		return e.Next()
+	})
+	app.OnRecordCreateRequest("bookmarks").BindFunc(func(e *core.RecordRequestEvent) error {
+		if err := checkSharedFolderWrite(e, "folderId", true); err != nil {
+			return err
+		}
+		return e.Next()
+	})
+	app.OnRecordUpdateRequest("bookmarks").BindFunc(func(e *core.RecordRequestEvent) error {
+		if err := checkSharedFolderWrite(e, "folderId", false); err != nil {
+			return err
+		}
+		return e.Next()
+	})
+
+	// 对共享用户，共享的根文件夹显示为顶层文件夹（其父文件夹不可见），并附带 sharedRole
+	app.OnRecordEnrich("folders").BindFunc(func(e *core.RecordEnrichEvent) error {
+		if e.RequestInfo == nil || e.RequestInfo.Auth == nil || e.RequestInfo.Auth.Id == e.Record.GetString("userId") {
+			return e.Next()
+		}
+		access, err := e.App.FindFirstRecordByFilter(
+			"folder_access",
+			"folderId = {:folderId} && userId = {:userId}",
+			dbx.Params{"folderId": e.Record.Id, "userId": e.RequestInfo.Auth.Id},
+		)
+		if err == nil {
+			e.Record.WithCustomData(true)
+			e.Record.Set("sharedRole", access.GetString("role"))
+			if access.GetBool("isRoot") {
+				e.Record.Set("parentId", "")
+			}
+		}
+		return e.Next()
+	})
+}
+
+// folderShareResponse is the JSON representation of a share.
+func folderShareResponse(app core.App, share *core.Record) map[string]interface{} {
+	response := map[string]interface{}{
+		"id":        share.Id,
+		"folderId":  share.GetString("folderId"),
+		"ownerId":   share.GetString("ownerId"),
+		"userId":    share.GetString("userId"),
+		"role":      share.GetString("role"),
+		"createdAt": share.GetDateTime("createdAt"),
+		"updatedAt": share.GetDateTime("updatedAt"),
+	}
+	if user, err := app.FindRecordById("users", share.GetString("userId")); err == nil {
+		response["email"] = user.Email()
+		response["name"] = user.GetString("name")
+	}
+	if owner, err := app.FindRecordById("users", share.GetString("ownerId")); err == nil {
+		response["ownerEmail"] = owner.Email()
+		response["ownerName"] = owner.GetString("name")
+	}
+	if folder, err := app.FindRecordById("folders", share.GetString("folderId")); err == nil {
+		response["folderName"] = folder.GetString("name")
+	}
+	return response
+}
+
+// findOwnedFolder returns the folder of the path parameter folderId if it is a personal folder of
+// userId. Workspace folders are shared through workspace membership instead.
+func findOwnedFolder(app core.App, e *core.RequestEvent, userId string) (*core.Record, error) {
+	folder, err := app.FindFirstRecordByFilter(
+		"folders",
+		"id = {:id} && userId = {:userId} && workspaceId = '' && deletedAt = ''",
+		dbx.Params{"id": e.Request.PathValue("folderId"), "userId": userId},
+	)
+	if err != nil {
+		return nil, e.NotFoundError("Folder not found or you are not its owner.", err)
+	}
+	return folder, nil
+}
+
+// shareFolderHandler shares a folder subtree with another user, or changes the role of an
+// existing share.
+// API Endpoint: POST /api/custom/folders/{folderId}/shares
+// Request: { "email": "string", "role": "viewer" | "editor" }
+// Response: the share { "id", "folderId", "folderName", "ownerId", "userId", "email", "name", "role", ... }
+func shareFolderHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		authRecord := e.Auth
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("User not authenticated.", nil)
+		}
+
+		var requestData struct {
+			Email string `json:"email"`
+			Role  string `json:"role"`
+		}
+		if err := e.BindBody(&requestData); err != nil {
+			return e.BadRequestError("Failed to parse request data.", err)
+		}
+		role := requestData.Role
+		if role == "" {
+			role = folderRoleViewer
+		}
+		if role != folderRoleViewer && role != folderRoleEditor {
+			return e.BadRequestError("role must be 'viewer' or 'editor'.", nil)
+		}
+
+		folder, err := findOwnedFolder(app, e, authRecord.Id)
+		if err != nil {
+			return err
+		}
+
+		recipient, err := app.FindAuthRecordByEmail("users", strings.TrimSpace(requestData.Email))
+		if err != nil {
+			return e.NotFoundError("No user with this email address.", err)
+		}
+		if recipient.Id == authRecord.Id {
+			return e.BadRequestError("You can't share a folder with yourself.", nil)
+		}
+
+		share, err := app.FindFirstRecordByFilter(
+			"folder_shares",
+			"folderId = {:folderId} && userId = {:userId}",
+			dbx.Params{"folderId": folder.Id, "userId": recipient.Id},
+		)
+		status := http.StatusOK
+		if err != nil {
+			collection, err := app.FindCollectionByNameOrId("folder_shares")
+			if err != nil {
+				return e.InternalServerError("Failed to find folder_shares collection", err)
+			}
+			share = core.NewRecord(collection)
+			share.Set("folderId", folder.Id)
+			share.Set("ownerId", authRecord.Id)
+			share.Set("userId", recipient.Id)
+			status = http.StatusCreated
+		}
+		share.Set("role", role)
+		if err := app.Save(share); err != nil {
+			return e.InternalServerError("Failed to save folder share", err)
+		}
+
+		return e.JSON(status, folderShareResponse(app, share))
+	}
+}
+
+// listFolderSharesHandler lists the users a folder is shared with. Only the owner can list them.
+// API Endpoint: GET /api/custom/folders/{folderId}/shares
+// Response: { "items": [share, ...] }
+func listFolderSharesHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		authRecord := e.Auth
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("User not authenticated.", nil)
+		}
+
+		folder, err := findOwnedFolder(app, e, authRecord.Id)
+		if err != nil {
+			return err
+		}
+
+		shares, err := app.FindRecordsByFilter("folder_shares", "folderId = {:folderId}", "createdAt", 0, 0, dbx.Params{"folderId": folder.Id})
+		if err != nil {
+			return e.InternalServerError("Failed to fetch folder shares", err)
+		}
+		items := make([]map[string]interface{}, 0, len(shares))
+		for _, share := range shares {
+			// 回收站中的文件夹在恢复前不再共享
+			if folder, err := app.FindRecordById("folders", share.GetString("folderId")); err != nil || isTrashed(folder) {
+				continue
+			}
+			items = append(items, folderShareResponse(app, share))
+		}
+		return e.JSON(http.StatusOK, map[string]interface{}{"items": items})
+	}
+}
+
+// deleteFolderShareHandler removes a share. The owner can revoke it and the recipient can leave it.
+// API Endpoint: DELETE /api/custom/folders/{folderId}/shares/{shareId}
+func deleteFolderShareHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		authRecord := e.Auth
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("User not authenticated.", nil)
+		}
+
+		share, err := app.FindFirstRecordByFilter(
+			"folder_shares",
+			"id = {:id} && folderId = {:folderId} && (ownerId = {:userId} || userId = {:userId})",
+			dbx.Params{"id": e.Request.PathValue("shareId"), "folderId": e.Request.PathValue("folderId"), "userId": authRecord.Id},
+		)
+		if err != nil {
+			return e.NotFoundError("Folder share not found.", err)
+		}
+		if err := app.Delete(share); err != nil {
+			return e.InternalServerError("Failed to delete folder share", err)
+		}
+		return e.NoContent(http.StatusNoContent)
+	}
+}
+
+// sharedWithMeHandler lists the folders other users shared with the current user.
+// API Endpoint: GET /api/custom/shared-folders
+// Response: { "items": [share, ...] }
+func sharedWithMeHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		authRecord := e.Auth
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("User not authenticated.", nil)
+		}
+
+		shares, err := app.FindRecordsByFilter("folder_shares", "userId = {:userId}", "createdAt", 0, 0, dbx.Params{"userId": authRecord.Id})
+		if err != nil {
+			return e.InternalServerError("Failed to fetch shared folders", err)
+		}
+		items := make([]map[string]interface{}, 0, len(shares))
+		for _, share := range shares {
+			items = append(items, folderShareResponse(app, share))
+		}
+		return e.JSON(http.StatusOK, map[string]interface{}{"items": items})
+	}
+}
+
+// loadSharedSyncRecords returns the folders and bookmarks shared with userId, with the access
+// row of each shared folder keyed by folder id.
+func loadSharedSyncRecords(app core.App, userId string) ([]*core.Record, []*core.Record, map[string]*core.Record, error) {
+	accessRecords, err := app.FindRecordsByFilter("folder_access", "userId = {:userId}", "", 0, 0, dbx.Params{"userId": userId})
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to load shared folder access: %w", err)
+	}
+	if len(accessRecords) == 0 {
+		return nil, nil, map[string]*core.Record{}, nil
+	}
+
+	accessByFolder := make(map[string]*core.Record, len(accessRecords))
+	folderIds := make([]interface{}, 0, len(accessRecords))
+	for _, access := range accessRecords {
+		accessByFolder[access.GetString("folderId")] = access
+		folderIds = append(folderIds, access.GetString("folderId"))
+	}
+
+	folders, err := app.FindAllRecords("folders", dbx.In("id", folderIds...), dbx.HashExp{"deletedAt": ""})
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to load shared folders: %w", err)
+	}
+	bookmarks, err := app.FindAllRecords("bookmarks", dbx.In("folderId", folderIds...), dbx.HashExp{"deletedAt": ""})
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to load shared bookmarks: %w", err)
+	}
+	return folders, bookmarks, accessByFolder, nil
+}
@@ -2,20 +2,20 @@ package main
 
 import (
 	"bytes"
-	"crypto/aes"
-	"crypto/cipher"
+	"context"
 	"crypto/rand"  // Added for JWT secret generation and encryption
 	"encoding/base64"
 	"encoding/hex" // Added for JWT secret generation
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url" // Used for parsing POCKETBASE_URL
 	"os"
-	"path"
-	"strconv" // Added for Favicon logic
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -23,13 +23,12 @@ import (
 	"github.com/pocketbase/pocketbase"
 	"github.com/pocketbase/pocketbase/apis"
 	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
 
 	// "github.com/labstack/echo/v4" // No longer directly needed after refactor
 	// "github.com/pocketbase/pocketbase/models"    // ENSURE THIS IS REMOVED or not present if v0.28.1+
 	// "github.com/pocketbase/pocketbase/tools/router" // No longer needed for RegisterRefreshFaviconRoute signature
 	"github.com/pocketbase/pocketbase/plugins/migratecmd"
-	"github.com/pocketbase/pocketbase/tools/types" // Added for types.JsonRaw
-	"github.com/studio-b12/gowebdav"
 	"golang.org/x/net/html" // 用于HTML解析
 
 	// Load migrations
@@ -43,8 +42,12 @@ type WebDAVConfig struct {
 	Password string `json:"Password"`
 	Path     string `json:"Path"`
 	AutoSync bool   `json:"AutoSync"`
+	BackupOptions
 }
 
+// webdavSecretFields lists the webdav_config keys that are stored encrypted.
+var webdavSecretFields = []string{"Password", "BackupPassphrase"}
+
 // UserSettingsBackupData defines the structure for user settings in backup.
 type UserSettingsBackupData struct {
 	TagList     []string `json:"tagList,omitempty"`
@@ -86,29 +89,22 @@ type FolderBackup struct {
 	UpdatedAt  string `json:"updatedAt,omitempty"`
 }
 
-// 全局加密密钥变量
+// 全局加密密钥变量（当前密钥的原始值，实际使用的密钥见 encryptionKeyRing）
 var encryptionKey string
 
-// 初始化加密密钥
-func initEncryptionKey() {
+// 初始化加密密钥。未设置 ENCRYPTION_KEY 时使用保存在数据目录中的生成密钥，保证重启后仍能解密
+func initEncryptionKey(dataDir string) {
 	encryptionKey = os.Getenv("ENCRYPTION_KEY")
 	if encryptionKey == "" {
-		log.Println("Warning: ENCRYPTION_KEY not set, generating random key for this session")
-		randomKeyBytes := make([]byte, 32)
-		if _, err := rand.Read(randomKeyBytes); err == nil {
-			encryptionKey = hex.EncodeToString(randomKeyBytes)[:32] // 确保32字符
-			log.Printf("Info: Generated temporary encryption key: %s...", encryptionKey[:8])
-		} else {
-			encryptionKey = "dev_encryption_key_32_chars_long" // 32字符的默认密钥
-			log.Printf("Warning: Using default encryption key for development")
+		generatedKey, err := loadOrCreateGeneratedEncryptionKey(dataDir)
+		if err != nil {
+			log.Fatalf("FATAL: ENCRYPTION_KEY not set and no key could be generated: %v\n", err)
 		}
+		encryptionKey = generatedKey
 	} else if len(encryptionKey) < 32 {
-		log.Printf("Warning: ENCRYPTION_KEY too short (%d chars), padding to 32 chars", len(encryptionKey))
-		encryptionKey = (encryptionKey + "00000000000000000000000000000000")[:32]
-	} else if len(encryptionKey) > 32 {
-		encryptionKey = encryptionKey[:32] // 截取前32字符
+		log.Printf("Warning: ENCRYPTION_KEY is short (%d chars), please use at least 32 random characters", len(encryptionKey))
 	}
-	log.Printf("Info: Encryption key initialized (length: %d)", len(encryptionKey))
+	loadEncryptionKeyRing(encryptionKey)
 }
 
 // 检查默认密钥并警告用户
@@ -154,64 +150,60 @@ func checkDefaultKeys() {
 	}
 }
 
-// 加密敏感数据
+// 加密敏感数据，结果格式为 "enc:v1:<keyId>:<base64(nonce||ciphertext)>"
 func encryptSensitiveData(plaintext string) (string, error) {
 	if plaintext == "" {
 		return "", nil
 	}
-	
-	block, err := aes.NewCipher([]byte(encryptionKey))
-	if err != nil {
-		return "", fmt.Errorf("failed to create cipher: %w", err)
+	if len(encryptionKeyRing) == 0 {
+		return "", fmt.Errorf("encryption key is not initialized")
 	}
-	
-	gcm, err := cipher.NewGCM(block)
+
+	current := encryptionKeyRing[0]
+	ciphertext, err := sealWithKey(current.key, []byte(plaintext))
 	if err != nil {
-		return "", fmt.Errorf("failed to create GCM: %w", err)
-	}
-	
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
-		return "", fmt.Errorf("failed to generate nonce: %w", err)
+		return "", err
 	}
-	
-	ciphertext := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
-	return base64.StdEncoding.EncodeToString(ciphertext), nil
+	return secretEnvelopePrefix + current.id + ":" + base64.StdEncoding.EncodeToString(ciphertext), nil
 }
 
-// 解密敏感数据
+// 解密敏感数据，只接受 encryptSensitiveData 生成的格式
 func decryptSensitiveData(ciphertext string) (string, error) {
 	if ciphertext == "" {
 		return "", nil
 	}
-	
-	data, err := base64.StdEncoding.DecodeString(ciphertext)
-	if err != nil {
-		return "", fmt.Errorf("failed to decode base64: %w", err)
+	if !isEncryptedSecret(ciphertext) {
+		return "", errSecretNotEncrypted
 	}
-	
-	block, err := aes.NewCipher([]byte(encryptionKey))
-	if err != nil {
-		return "", fmt.Errorf("failed to create cipher: %w", err)
+
+	keyID, payload, ok := splitEncryptionKeyID(strings.TrimPrefix(ciphertext, secretEnvelopePrefix))
+	if !ok {
+		return "", fmt.Errorf("malformed encrypted value")
 	}
-	
-	gcm, err := cipher.NewGCM(block)
+	data, err := base64.StdEncoding.DecodeString(payload)
 	if err != nil {
-		return "", fmt.Errorf("failed to create GCM: %w", err)
+		return "", fmt.Errorf("failed to decode base64: %w", err)
 	}
-	
-	nonceSize := gcm.NonceSize()
-	if len(data) < nonceSize {
-		return "", fmt.Errorf("ciphertext too short")
+
+	for _, entry := range encryptionKeyRing {
+		if entry.id == keyID {
+			plaintext, err := openWithKey(entry.key, data)
+			if err != nil {
+				return "", err
+			}
+			return string(plaintext), nil
+		}
 	}
-	
-	nonce, ciphertextBytes := data[:nonceSize], data[nonceSize:]
-	plaintext, err := gcm.Open(nil, nonce, ciphertextBytes, nil)
-	if err != nil {
-		return "", fmt.Errorf("failed to decrypt: %w", err)
+	return "", fmt.Errorf("%w (key id %s)", errUnknownEncryptionKey, keyID)
+}
+
+// 读取 user_settings 中加密保存的字段。迁移前保存的明文值原样返回
+func decryptUserSetting(record *core.Record, field string) (string, error) {
+	value := record.GetString(field)
+	if !isEncryptedSecret(value) {
+		return value, nil
 	}
-	
-	return string(plaintext), nil
+	return decryptSensitiveData(value)
 }
 
 // 辅助函数：解密密码（保持向后兼容）
@@ -219,6 +211,101 @@ func decryptPassword(encrypted string) (string, error) {
 	return decryptSensitiveData(encrypted)
 }
 
+// encryptJSONFieldSecrets encrypts the given keys of a JSON object field in place.
+func encryptJSONFieldSecrets(record *core.Record, field string, secretKeys []string) error {
+	raw := record.Get(field)
+	if raw == nil {
+		return nil
+	}
+	configBytes, err := jsonFieldBytes(raw)
+	if err != nil {
+		log.Printf("Error marshaling %s: %v", field, err)
+		return nil // 跳过加密，继续处理
+	}
+
+	var config map[string]interface{}
+	if err := json.Unmarshal(configBytes, &config); err != nil || config == nil {
+		return nil
+	}
+
+	// 客户端原样提交的掩码值还原为已保存的密文
+	var originalConfig map[string]interface{}
+	if originalBytes, err := jsonFieldBytes(record.Original().Get(field)); err == nil && len(originalBytes) > 0 {
+		json.Unmarshal(originalBytes, &originalConfig)
+	}
+
+	configChanged := false
+	for _, secretKey := range secretKeys {
+		// "<key>Configured" 标志只用于响应，不保存
+		if _, exists := config[secretKey+"Configured"]; exists {
+			delete(config, secretKey+"Configured")
+			configChanged = true
+		}
+		if secret, exists := config[secretKey]; exists {
+			if secretStr, ok := secret.(string); ok && secretStr != "" {
+				originalSecret, _ := originalConfig[secretKey].(string)
+				if isMaskedJSONSecret(secretStr, originalSecret) {
+					config[secretKey] = originalSecret
+					configChanged = true
+					continue
+				}
+				if isEncryptedSecret(secretStr) {
+					continue
+				}
+				encrypted, err := encryptSensitiveData(secretStr)
+				if err != nil {
+					log.Printf("Error encrypting %s.%s: %v", field, secretKey, err)
+					return fmt.Errorf("failed to encrypt %s.%s", field, secretKey)
+				}
+				config[secretKey] = encrypted
+				configChanged = true
+				log.Printf("Encrypted %s.%s for user %s", field, secretKey, record.GetString("userId"))
+			}
+		}
+	}
+
+	if configChanged {
+		updatedConfig, err := json.Marshal(config)
+		if err != nil {
+			return fmt.Errorf("failed to marshal %s", field)
+		}
+		record.Set(field, string(updatedConfig))
+	}
+	return nil
+}
+
+// maskJSONFieldSecrets replaces the given keys of a JSON object field with their masked
+// form and adds a "<key>Configured" flag for each of them.
+func maskJSONFieldSecrets(record *core.Record, field string, secretKeys []string) {
+	raw := record.Get(field)
+	if raw == nil {
+		return
+	}
+	configBytes, err := jsonFieldBytes(raw)
+	if err != nil {
+		log.Printf("Error marshaling %s for masking: %v", field, err)
+		return
+	}
+
+	var config map[string]interface{}
+	if err := json.Unmarshal(configBytes, &config); err != nil || len(config) == 0 {
+		return
+	}
+
+	for _, secretKey := range secretKeys {
+		secretStr, _ := config[secretKey].(string)
+		if secretStr != "" {
+			config[secretKey] = secretMask
+		}
+		config[secretKey+"Configured"] = secretStr != ""
+	}
+
+	updatedConfig, err := json.Marshal(config)
+	if err == nil {
+		record.Set(field, string(updatedConfig))
+	}
+}
+
 // suggestFolderHandler handles the API request for AI folder suggestions.
 // It requires authentication, accepts bookmark title and URL, fetches page content,
 // and then asks AI to suggest one existing folder.
@@ -242,6 +329,12 @@ func suggestFolderHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent)
 			return e.BadRequestError("Title and URL are required for folder suggestion.", nil)
 		}
 
+		// Folders are suggested from the selected space (personal or workspace)
+		space, err := resolveSpaceContext(app, e)
+		if err != nil {
+			return err
+		}
+
 		// Fetch user's Gemini API settings
 		userSettings, err := app.FindFirstRecordByFilter(
 			"user_settings",
@@ -251,28 +344,26 @@ func suggestFolderHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent)
 		if err != nil {
 			return e.NotFoundError("User settings not found for folder suggestion.", err)
 		}
-		geminiApiKey := userSettings.GetString("geminiApiKey")
-		geminiApiBaseUrl := userSettings.GetString("geminiApiBaseUrl")
-		geminiModelName := userSettings.GetString("geminiModelName")
-
-		if geminiApiKey == "" {
-			return e.BadRequestError("Gemini API configuration not found in user settings for folder suggestion.", nil)
+		if _, err := resolveAIConfig(app, userSettings); err != nil {
+			status, message := aiConfigErrorResponse(err)
+			return e.Error(status, message, err)
 		}
 
 		// Fetch page content and metadata
-		pageData, err := fetchPageContent(requestData.URL, app)
+		pageData, err := fetchPageContent(requestData.URL, app, userSettings)
 		if err != nil {
 			log.Printf("SuggestFolder: Failed to fetch page content for URL %s: %v. Proceeding with title and URL only.", requestData.URL, err)
 		}
 
-		// Fetch user's existing folders
+		// Fetch the space's existing folders
+		folderFilter, folderParams := space.recordFilter()
 		folderRecords, err := app.FindRecordsByFilter(
 			"folders",
-			"userId = {:userId}",
+			folderFilter,
 			"", // sort
 			0,  // limit
 			0,  // offset
-			dbx.Params{"userId": userId},
+			folderParams,
 		)
 		if err != nil {
 			return e.InternalServerError("Failed to fetch user's folders.", err)
@@ -285,81 +376,44 @@ func suggestFolderHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent)
 			existingFolderNames[i] = record.GetString("name")
 		}
 
-		// Prepare AI prompt
-		apiBaseUrl := geminiApiBaseUrl
-		if apiBaseUrl == "" {
-			apiBaseUrl = "https://generativelanguage.googleapis.com/v1beta/openai/"
-		}
-		modelName := geminiModelName
-		if modelName == "" {
-			modelName = "gemini-2.0-flash" // Or a model suitable for classification/suggestion
-		}
-
-		systemMessage := "You are a professional bookmark organization assistant. Your ONLY task is to select the most appropriate folder for a bookmark from the user's existing folders. You MUST select ONE folder from the provided list - creating new folder names is STRICTLY FORBIDDEN. Analyze the webpage's title, URL, and content, then return ONLY a JSON response in the format {\"folder_name\": \"ChosenFolderName\"}. If multiple folders seem appropriate, choose the single best match. You CANNOT suggest a new folder name or return an empty result - you MUST select from the provided list only."
-
-		userPrompt := fmt.Sprintf("分析以下网页信息以选择合适的文件夹：\n\n原始书签标题: %s\n网页URL: %s", requestData.Title, requestData.URL)
-
-		if pageData.MetaTitle != "" {
-			userPrompt += fmt.Sprintf("\n网页Meta标题: %s", pageData.MetaTitle)
-		}
-		if pageData.MetaDescription != "" {
-			userPrompt += fmt.Sprintf("\n网页Meta描述: %s", pageData.MetaDescription)
-		}
-		if pageData.OGTitle != "" {
-			userPrompt += fmt.Sprintf("\n网页OG标题: %s", pageData.OGTitle)
-		}
-		if pageData.OGDescription != "" {
-			userPrompt += fmt.Sprintf("\n网页OG描述: %s", pageData.OGDescription)
+		// Resolve the user's or the shared AI configuration, counting the request against the shared quota
+		aiConfig, err := prepareAICall(app, userId, userSettings)
+		if err != nil {
+			status, message := aiConfigErrorResponse(err)
+			return e.Error(status, message, err)
 		}
 
-		if pageData.Content != "" {
-			maxContentLength := 10000
-			content := pageData.Content
-			if len(content) > maxContentLength {
-				content = content[:maxContentLength]
-			}
-			userPrompt += fmt.Sprintf("\n\n网页主要内容摘要:\n%s", content)
-		}
+		// Render the user's (or the default) prompt templates
+		modelName := aiConfig.Model
 
-		userPrompt += fmt.Sprintf("\n\n这是用户现有的文件夹列表: %v。\n\n重要提示：您必须从此列表中选择一个文件夹。请勿创建新的文件夹名称。请勿返回空结果。请从列表中选择最合适的单个文件夹，即使相关性看起来一般。这对于维护用户的有组织的书签结构至关重要。", existingFolderNames)
+		promptData := newAIPromptData(aiPromptFolder, requestData.Title, requestData.URL, pageData)
+		promptData.Folders = existingFolderNames
+		prompt := renderAIPrompt(userSettings, aiPromptFolder, promptData)
 
 		requestBody, _ := json.Marshal(map[string]interface{}{
 			"model": modelName,
 			"messages": []map[string]interface{}{
-				{"role": "system", "content": systemMessage},
-				{"role": "user", "content": userPrompt},
+				{"role": "system", "content": prompt.System},
+				{"role": "user", "content": prompt.User},
 			},
 			"temperature":     0.2,
 			"max_tokens":      50,
 			"response_format": map[string]string{"type": "json_object"},
 		})
 
-		httpClient := &http.Client{Timeout: 25 * time.Second}
-		finalApiUrl := apiBaseUrl
-		if !strings.HasSuffix(finalApiUrl, "/") {
-			finalApiUrl += "/"
-		}
-		aiReq, err := http.NewRequest("POST", finalApiUrl+"chat/completions", bytes.NewBuffer(requestBody))
-		if err != nil {
-			return e.InternalServerError("SuggestFolder: Failed to create AI HTTP request.", err)
-		}
-		aiReq.Header.Set("Content-Type", "application/json")
-		aiReq.Header.Set("Authorization", "Bearer "+geminiApiKey)
-
-		aiResp, err := httpClient.Do(aiReq)
+		responseBodyBytes, statusCode, err := callAIChatCompletion(app, userId, aiFeatureSuggestFolder, aiConfig, requestBody, 25*time.Second)
 		if err != nil {
 			return e.InternalServerError("SuggestFolder: Failed to call AI API.", err)
 		}
-		defer aiResp.Body.Close()
 
-		if aiResp.StatusCode != http.StatusOK {
+		if statusCode != http.StatusOK {
 			var errorResponse map[string]interface{}
-			json.NewDecoder(aiResp.Body).Decode(&errorResponse)
-			return e.InternalServerError(fmt.Sprintf("SuggestFolder: AI API returned non-200 status: %d", aiResp.StatusCode), errorResponse)
+			json.Unmarshal(responseBodyBytes, &errorResponse)
+			return e.InternalServerError(fmt.Sprintf("SuggestFolder: AI API returned non-200 status: %d", statusCode), errorResponse)
 		}
 
 		var aiResult map[string]interface{}
-		if err := json.NewDecoder(aiResp.Body).Decode(&aiResult); err != nil {
+		if err := json.Unmarshal(responseBodyBytes, &aiResult); err != nil {
 			return e.InternalServerError("SuggestFolder: Failed to parse AI API response.", err)
 		}
 
@@ -440,14 +494,24 @@ func ensureFolderPathHandler(app *pocketbase.PocketBase) func(e *core.RequestEve
 		createdFolders := []string{}
 		var currentParentId *string = nil
 
-		// Get all user's folders once
+		// Folders are created in the selected space (personal or workspace)
+		space, err := resolveSpaceContext(app, e)
+		if err != nil {
+			return err
+		}
+		if !space.canEdit() {
+			return apis.NewForbiddenError("You need editor access to this workspace.", nil)
+		}
+
+		// Get all folders of the space once
+		folderFilter, folderParams := space.recordFilter()
 		folderRecords, err := app.FindRecordsByFilter(
 			"folders",
-			"userId = {:userId}",
+			folderFilter,
 			"", // sort
 			0,  // limit
 			0,  // offset
-			dbx.Params{"userId": userId},
+			folderParams,
 		)
 		if err != nil {
 			return e.InternalServerError("Failed to fetch user's folders.", err)
@@ -483,12 +547,13 @@ func ensureFolderPathHandler(app *pocketbase.PocketBase) func(e *core.RequestEve
 
 				newFolder := core.NewRecord(collection)
 				newFolder.Set("userId", userId)
+				newFolder.Set("workspaceId", space.workspaceId)
 				newFolder.Set("name", folderName)
 				if currentParentId != nil {
 					newFolder.Set("parentId", *currentParentId)
 				}
 
-				if err := app.Save(newFolder); err != nil {
+				if err := app.SaveWithContext(auditContext(e, ""), newFolder); err != nil {
 					return e.InternalServerError("Failed to create folder: "+folderName, err)
 				}
 
@@ -522,11 +587,16 @@ func syncExportDataHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent
 
 		// Parse optional query parameters
 		lastSyncTime := e.Request.URL.Query().Get("lastSyncTime")
+
+		// Export the selected space (personal or workspace)
+		space, err := resolveSpaceContext(app, e)
+		if err != nil {
+			return err
+		}
 		
 		// Build filter for incremental sync if lastSyncTime is provided
-		bookmarkFilter := "userId = {:userId}"
-		folderFilter := "userId = {:userId}"
-		params := dbx.Params{"userId": userId}
+		bookmarkFilter, params := space.recordFilter()
+		folderFilter := bookmarkFilter
 		
 		if lastSyncTime != "" {
 			// Add time filter for incremental sync
@@ -561,11 +631,50 @@ func syncExportDataHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent
 			return e.InternalServerError("Failed to fetch user's folders for sync export.", err)
 		}
 
+		// Folders shared with the user by others, and the bookmarks in them (personal space only)
+		var sharedFolderRecords, sharedBookmarkRecords []*core.Record
+		sharedAccess := map[string]*core.Record{}
+		if !space.isWorkspace() {
+			sharedFolderRecords, sharedBookmarkRecords, sharedAccess, err = loadSharedSyncRecords(app, userId)
+			if err != nil {
+				return e.InternalServerError("Failed to fetch shared folders for sync export.", err)
+			}
+		}
+
 		// Build folder path mapping for efficient lookup
 		folderMap := make(map[string]*core.Record)
 		for _, record := range folderRecords {
 			folderMap[record.Id] = record
 		}
+		for _, record := range sharedFolderRecords {
+			folderMap[record.Id] = record
+		}
+
+		// 增量同步时，共享项在本身更新或刚被共享给当前用户时才导出
+		changedSince := func(record *core.Record, access *core.Record) bool {
+			if lastSyncTime == "" {
+				return true
+			}
+			return record.GetString("updatedAt") > lastSyncTime || access.GetString("createdAt") > lastSyncTime
+		}
+		for _, record := range sharedFolderRecords {
+			if changedSince(record, sharedAccess[record.Id]) {
+				folderRecords = append(folderRecords, record)
+			}
+		}
+		for _, record := range sharedBookmarkRecords {
+			if record.GetString("userId") != userId && changedSince(record, sharedAccess[record.GetString("folderId")]) {
+				bookmarkRecords = append(bookmarkRecords, record)
+			}
+		}
+
+		// 共享的根文件夹的父文件夹对当前用户不可见
+		folderParentId := func(folder *core.Record) string {
+			if access, ok := sharedAccess[folder.Id]; ok && access.GetBool("isRoot") {
+				return ""
+			}
+			return folder.GetString("parentId")
+		}
 
 		// Helper function to build folder path recursively
 		var buildFolderPath func(folderId string) []string
@@ -579,7 +688,7 @@ func syncExportDataHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent
 				return []string{}
 			}
 			
-			parentId := folder.GetString("parentId")
+			parentId := folderParentId(folder)
 			if parentId == "" {
 				return []string{folder.GetString("name")}
 			}
@@ -596,16 +705,23 @@ func syncExportDataHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent
 			folder := map[string]interface{}{
 				"id":        record.Id,
 				"name":      record.GetString("name"),
-				"parentId":  record.GetString("parentId"),
+				"parentId":  folderParentId(record),
 				"path":      folderPath,
 				"createdAt": record.GetString("createdAt"),
 				"updatedAt": record.GetString("updatedAt"),
 			}
 			
 			// Handle empty parentId
-			if record.GetString("parentId") == "" {
+			if folderParentId(record) == "" {
 				folder["parentId"] = nil
 			}
+
+			// Mark folders shared by other users
+			if access, ok := sharedAccess[record.Id]; ok {
+				folder["shared"] = true
+				folder["ownerId"] = record.GetString("userId")
+				folder["role"] = access.GetString("role")
+			}
 			
 			folders = append(folders, folder)
 		}
@@ -644,6 +760,15 @@ func syncExportDataHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent
 			if record.GetString("chromeBookmarkId") == "" {
 				bookmark["chromeBookmarkId"] = nil
 			}
+
+			// Mark bookmarks in folders shared by other users
+			if !space.isWorkspace() && record.GetString("userId") != userId {
+				bookmark["shared"] = true
+				bookmark["ownerId"] = record.GetString("userId")
+				if access, ok := sharedAccess[folderId]; ok {
+					bookmark["role"] = access.GetString("role")
+				}
+			}
 			
 			bookmarks = append(bookmarks, bookmark)
 		}
@@ -654,6 +779,10 @@ func syncExportDataHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent
 			"totalBookmarks": len(bookmarks),
 			"exportTime":     time.Now().UTC().Format(time.RFC3339),
 			"isIncremental":  lastSyncTime != "",
+			"workspaceId":    nil,
+		}
+		if space.isWorkspace() {
+			syncMetadata["workspaceId"] = space.workspaceId
 		}
 
 		// Prepare response
@@ -670,6 +799,113 @@ func syncExportDataHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent
 	}
 }
 
+// buildBackupData collects the user's bookmarks, folders and settings into a backup document.
+// Only the personal space is backed up; workspace records are not part of a user's backup.
+func buildBackupData(app core.App, userId string) (WebDAVBackupData, error) {
+	var backupData WebDAVBackupData
+
+	personalFilter, personalParams := personalSpace(userId).recordFilter()
+	bookmarkRecords, err := app.FindRecordsByFilter(
+		"bookmarks",
+		personalFilter,
+		"", 0, 0,
+		personalParams,
+	)
+	if err != nil {
+		return backupData, fmt.Errorf("failed to fetch bookmarks for backup: %w", err)
+	}
+
+	folderRecords, err := app.FindRecordsByFilter(
+		"folders",
+		personalFilter,
+		"", 0, 0,
+		personalParams,
+	)
+	if err != nil {
+		return backupData, fmt.Errorf("failed to fetch folders for backup: %w", err)
+	}
+
+	backupData.Version = "1.0.0"
+
+	backupData.Bookmarks = make([]BookmarkBackup, 0, len(bookmarkRecords))
+	for _, record := range bookmarkRecords {
+		bookmark := BookmarkBackup{
+			OriginalID: record.Id,
+			FolderID:   record.GetString("folderId"),
+			URL:        record.GetString("url"),
+			Title:      record.GetString("title"),
+			Tags:       record.GetStringSlice("tags"),
+			FaviconURL: record.GetString("faviconUrl"),
+			CreatedAt:  record.GetString("createdAt"),
+			UpdatedAt:  record.GetString("updatedAt"),
+		}
+		backupData.Bookmarks = append(backupData.Bookmarks, bookmark)
+	}
+
+	backupData.Folders = make([]FolderBackup, 0, len(folderRecords))
+	for _, record := range folderRecords {
+		folder := FolderBackup{
+			OriginalID: record.Id,
+			ParentID:   record.GetString("parentId"),
+			Name:       record.GetString("name"),
+			CreatedAt:  record.GetString("createdAt"),
+			UpdatedAt:  record.GetString("updatedAt"),
+		}
+		backupData.Folders = append(backupData.Folders, folder)
+	}
+
+	// 3a. Get user_settings data
+	var userSettingsData UserSettingsBackupData
+	userSettingsRecord, errSettings := app.FindFirstRecordByFilter(
+		"user_settings",
+		"userId = {:userId}",
+		dbx.Params{"userId": userId},
+	)
+	if errSettings != nil {
+		log.Printf("WebDAV Backup: User settings not found for user %s, settings will not be backed up. Error: %v", userId, errSettings)
+		// Not treating as a fatal error, backup will proceed without these settings.
+	} else if userSettingsRecord != nil {
+		userSettingsData.TagList = userSettingsRecord.GetStringSlice("tagList")
+		userSettingsData.DarkMode = userSettingsRecord.GetBool("darkMode")
+		userSettingsData.AccentColor = userSettingsRecord.GetString("accentColor")
+		userSettingsData.DefaultView = userSettingsRecord.GetString("defaultView")
+		userSettingsData.Language = userSettingsRecord.GetString("language")
+		// userSettingsData.SortOption = userSettingsRecord.GetString("sortOption") // Uncomment if needed
+		// userSettingsData.SearchFields = userSettingsRecord.GetStringSlice("searchFields") // Uncomment if needed
+		backupData.UserSettings = userSettingsData
+		log.Printf("WebDAV Backup: User settings included for user %s.", userId)
+	}
+
+	return backupData, nil
+}
+
+// encodeBackupPayload serializes backupData and applies compression and, when a passphrase
+// is given, encryption. It returns the timestamped file name matching the encoding.
+func encodeBackupPayload(backupData WebDAVBackupData, compression string, passphrase string) (string, []byte, error) {
+	// 4. 序列化数据
+	jsonData, err := json.MarshalIndent(backupData, "", "  ")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to serialize backup data: %w", err)
+	}
+
+	backupFileName := fmt.Sprintf("backup_%s.json", time.Now().Format("20060102_150405"))
+	jsonData, compressedExt, err := compressBackupPayload(jsonData, compression, backupFileName)
+	if err != nil {
+		return "", nil, err
+	}
+	backupFileName += compressedExt
+
+	if passphrase != "" {
+		jsonData, err = encryptBackupPayload(jsonData, passphrase)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to encrypt backup data: %w", err)
+		}
+		backupFileName += encryptedBackupExtension
+	}
+
+	return backupFileName, jsonData, nil
+}
+
 // webdavBackupHandler handles the WebDAV backup request.
 func webdavBackupHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
 	return func(e *core.RequestEvent) error {
@@ -679,6 +915,15 @@ func webdavBackupHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent)
 		}
 		userId := authRecord.Id
 
+		// Optional passphrase and compression overriding the ones stored with the backup target
+		var requestData struct {
+			Passphrase  string `json:"passphrase"`
+			Compression string `json:"compression"`
+		}
+		if err := e.BindBody(&requestData); err != nil {
+			return e.BadRequestError("Failed to parse request data.", err)
+		}
+
 		userSettings, err := app.FindFirstRecordByFilter(
 			"user_settings",
 			"userId = {:userId}",
@@ -688,146 +933,61 @@ func webdavBackupHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent)
 			return e.NotFoundError("User settings not found.", err)
 		}
 
-		webdavConfigRaw := userSettings.Get("webdav_config")
-		if webdavConfigRaw == nil {
-			return e.BadRequestError("WebDAV configuration not found or is null in user settings.", nil)
-		}
-
-		var webdavConfig WebDAVConfig
-		var webdavConfigBytes []byte
-
-		switch v := webdavConfigRaw.(type) {
-		case nil:
-			return e.BadRequestError("WebDAV configuration is null.", nil)
-		case types.JSONRaw:
-			webdavConfigBytes = []byte(v)
-		case json.RawMessage:
-			webdavConfigBytes = []byte(v)
-		case string:
-			webdavConfigBytes = []byte(v)
-		case []byte:
-			webdavConfigBytes = v
-		case map[string]any:
-			webdavConfigBytes, err = json.Marshal(v)
-			if err != nil {
-				return e.InternalServerError("Failed to re-marshal webdav_config from map[string]any.", err)
-			}
-		default:
-			log.Printf("Unexpected type for webdav_config: %T, value: %v", v, v)
-			return e.InternalServerError("Unexpected type for webdav_config. Expected string, []byte, json.RawMessage, or map[string]any.", nil)
-		}
-
-		if len(webdavConfigBytes) == 0 {
-			return e.BadRequestError("WebDAV configuration is empty after type assertion.", nil)
-		}
-
-		if err := json.Unmarshal(webdavConfigBytes, &webdavConfig); err != nil {
-			return e.BadRequestError("Failed to parse WebDAV configuration.", err)
+		storage, backupOptions, err := loadBackupTarget(userSettings)
+		if errors.Is(err, errBackupTargetNotConfigured) {
+			return e.BadRequestError("Backup target (WebDAV or other) is not configured in user settings.", nil)
 		}
-
-		decryptedPassword, err := decryptPassword(webdavConfig.Password)
 		if err != nil {
-			return e.InternalServerError("Failed to decrypt WebDAV password.", err)
+			return e.BadRequestError("Failed to open backup target.", err)
 		}
+		defer storage.Close()
 
-		bookmarkRecords, err := app.FindRecordsByFilter(
-			"bookmarks",
-			"userId = {:userId}",
-			"", 0, 0,
-			dbx.Params{"userId": userId},
-		)
+		backupData, err := buildBackupData(app, userId)
 		if err != nil {
-			return e.InternalServerError("Failed to fetch bookmarks for backup.", err)
+			return e.InternalServerError("Failed to collect data for backup.", err)
 		}
 
-		folderRecords, err := app.FindRecordsByFilter(
-			"folders",
-			"userId = {:userId}",
-			"", 0, 0,
-			dbx.Params{"userId": userId},
-		)
+		backupPassphrase, err := resolveBackupPassphrase(backupOptions, requestData.Passphrase)
 		if err != nil {
-			return e.InternalServerError("Failed to fetch folders for backup.", err)
+			return e.InternalServerError("Failed to decrypt backup passphrase.", err)
 		}
-
-		var backupData WebDAVBackupData
-		backupData.Version = "1.0.0"
-
-		backupData.Bookmarks = make([]BookmarkBackup, 0, len(bookmarkRecords))
-		for _, record := range bookmarkRecords {
-			bookmark := BookmarkBackup{
-				OriginalID: record.Id,
-				FolderID:   record.GetString("folderId"),
-				URL:        record.GetString("url"),
-				Title:      record.GetString("title"),
-				Tags:       record.GetStringSlice("tags"),
-				FaviconURL: record.GetString("faviconUrl"),
-				CreatedAt:  record.GetString("createdAt"),
-				UpdatedAt:  record.GetString("updatedAt"),
-			}
-			backupData.Bookmarks = append(backupData.Bookmarks, bookmark)
+		if backupOptions.EncryptBackups && backupPassphrase == "" {
+			return e.BadRequestError("Backup encryption is enabled but no passphrase is configured.", nil)
 		}
 
-		backupData.Folders = make([]FolderBackup, 0, len(folderRecords))
-		for _, record := range folderRecords {
-			folder := FolderBackup{
-				OriginalID: record.Id,
-				ParentID:   record.GetString("parentId"),
-				Name:       record.GetString("name"),
-				CreatedAt:  record.GetString("createdAt"),
-				UpdatedAt:  record.GetString("updatedAt"),
-			}
-			backupData.Folders = append(backupData.Folders, folder)
+		compression := backupOptions.Compression
+		if requestData.Compression != "" {
+			compression = requestData.Compression
 		}
-
-		// 3a. Get user_settings data
-		var userSettingsData UserSettingsBackupData
-		userSettingsRecord, errSettings := app.FindFirstRecordByFilter(
-			"user_settings",
-			"userId = {:userId}",
-			dbx.Params{"userId": userId},
-		)
-		if errSettings != nil {
-			log.Printf("WebDAV Backup: User settings not found for user %s, settings will not be backed up. Error: %v", userId, errSettings)
-			// Not treating as a fatal error, backup will proceed without these settings.
-		} else if userSettingsRecord != nil {
-			userSettingsData.TagList = userSettingsRecord.GetStringSlice("tagList")
-			userSettingsData.DarkMode = userSettingsRecord.GetBool("darkMode")
-			userSettingsData.AccentColor = userSettingsRecord.GetString("accentColor")
-			userSettingsData.DefaultView = userSettingsRecord.GetString("defaultView")
-			userSettingsData.Language = userSettingsRecord.GetString("language")
-			// userSettingsData.SortOption = userSettingsRecord.GetString("sortOption") // Uncomment if needed
-			// userSettingsData.SearchFields = userSettingsRecord.GetStringSlice("searchFields") // Uncomment if needed
-			backupData.UserSettings = userSettingsData
-			log.Printf("WebDAV Backup: User settings included for user %s.", userId)
-		}
-
-		// 4. 序列化数据
-		jsonData, err := json.MarshalIndent(backupData, "", "  ")
+		compression, err = normalizeBackupCompression(compression)
 		if err != nil {
-			return e.InternalServerError("Failed to serialize backup data.", err)
+			return e.BadRequestError(err.Error(), nil)
 		}
 
-		client := gowebdav.NewClient(webdavConfig.Url, webdavConfig.Username, decryptedPassword)
+		backupFileName, jsonData, err := encodeBackupPayload(backupData, compression, backupPassphrase)
+		if err != nil {
+			return e.InternalServerError("Failed to encode backup data.", err)
+		}
 
-		backupFileName := fmt.Sprintf("backup_%s.json", time.Now().Format("20060102_150405"))
-		remotePath := path.Join(webdavConfig.Path, backupFileName)
+		remotePath := storage.Describe(backupFileName)
 
-		err = client.MkdirAll(webdavConfig.Path, 0755)
+		err = storage.Prepare()
 		if err != nil {
-			log.Printf("Warning: Failed to create WebDAV directories %s: %v", webdavConfig.Path, err)
+			log.Printf("Warning: Failed to prepare backup directory for %s: %v", remotePath, err)
 		}
 
-		err = client.Write(remotePath, jsonData, 0644)
+		err = storage.Write(backupFileName, jsonData)
 		if err != nil {
-			return e.InternalServerError(fmt.Sprintf("Failed to upload backup to WebDAV server at %s", remotePath), err)
+			return e.InternalServerError(fmt.Sprintf("Failed to upload backup to %s", remotePath), err)
 		}
 
-		log.Printf("Successfully backed up data for user %s to WebDAV server at %s", userId, remotePath)
+		log.Printf("Successfully backed up data for user %s to %s", userId, remotePath)
 		return e.JSON(http.StatusOK, map[string]interface{}{
-			"success":  true,
-			"message":  "Backup successful",
-			"fileName": backupFileName,
+			"success":     true,
+			"message":     "Backup successful",
+			"fileName":    backupFileName,
+			"encrypted":   backupPassphrase != "",
+			"compression": compression,
 		})
 	}
 }
@@ -855,6 +1015,17 @@ func suggestTagsForBookmarkHandler(app *pocketbase.PocketBase) func(e *core.Requ
 			return e.BadRequestError("Title and URL are required", nil)
 		}
 
+		// 未提供现有标签时，使用所选空间（个人或工作区）的标签列表
+		space, err := resolveSpaceContext(app, e)
+		if err != nil {
+			return err
+		}
+		if requestData.ExistingUserTags == nil {
+			if tagRecord, err := space.tagListRecord(app); err == nil {
+				requestData.ExistingUserTags = tagRecord.GetStringSlice("tagList")
+			}
+		}
+
 		userSettings, err := e.App.FindFirstRecordByFilter(
 			"user_settings",
 			"userId = {:userId}",
@@ -865,71 +1036,33 @@ func suggestTagsForBookmarkHandler(app *pocketbase.PocketBase) func(e *core.Requ
 			return e.NotFoundError("User settings not found", err)
 		}
 
-		geminiApiKey := userSettings.GetString("geminiApiKey")
-		geminiApiBaseUrl := userSettings.GetString("geminiApiBaseUrl")
-		geminiModelName := userSettings.GetString("geminiModelName")
-
-		if geminiApiKey == "" {
-			return e.BadRequestError("Gemini API configuration not found in user settings", nil)
-		}
-
-		apiBaseUrl := geminiApiBaseUrl
-		if apiBaseUrl == "" {
-			apiBaseUrl = "https://generativelanguage.googleapis.com/v1beta/openai/"
-		}
-
-		modelName := geminiModelName
-		if modelName == "" {
-			modelName = "gemini-2.0-flash"
+		aiConfig, err := prepareAICall(app, userId, userSettings)
+		if err != nil {
+			status, message := aiConfigErrorResponse(err)
+			return e.Error(status, message, err)
 		}
 
-		systemMessage := "You are a professional bookmark tagging assistant. Your ONLY task is to select relevant tags from the user's existing tag collection. You MUST ONLY choose from the tags provided in the 'existingUserTags' list. DO NOT create new tags. If no existing tags are relevant, return an empty array in the format {\"tags\": []}. Return ONLY a JSON response in the format {\"tags\": [\"tag1\", \"tag2\"]}. Choose 2-3 tags maximum if relevant ones exist."
+		modelName := aiConfig.Model
 
-		pageData, err := fetchPageContent(requestData.URL, app)
+		pageData, err := fetchPageContent(requestData.URL, app, userSettings)
 		if err != nil {
 			log.Printf("Failed to fetch page content for URL %s: %v. Proceeding with title and URL only for tag suggestion.", requestData.URL, err)
 		}
 
-		userPrompt := fmt.Sprintf("分析以下网页信息以生成相关标签。\n\n原始书签标题: %s\n网页URL: %s", requestData.Title, requestData.URL)
-
-		if pageData.MetaTitle != "" {
-			userPrompt += fmt.Sprintf("\n网页Meta标题: %s", pageData.MetaTitle)
-		}
-		if pageData.MetaDescription != "" {
-			userPrompt += fmt.Sprintf("\n网页Meta描述: %s", pageData.MetaDescription)
-		}
-		if pageData.OGTitle != "" {
-			userPrompt += fmt.Sprintf("\n网页OG标题: %s", pageData.OGTitle)
-		}
-		if pageData.OGDescription != "" {
-			userPrompt += fmt.Sprintf("\n网页OG描述: %s", pageData.OGDescription)
-		}
-
-		if pageData.Content != "" {
-			maxContentLength := 15000
-			content := pageData.Content
-			if len(content) > maxContentLength {
-				content = content[:maxContentLength]
-			}
-			userPrompt += fmt.Sprintf("\n\n网页主要内容摘要:\n%s", content)
-		}
-
-		if len(requestData.ExistingUserTags) > 0 {
-			userPrompt += fmt.Sprintf("\n\nCRITICAL INSTRUCTION: 您必须从用户现有的标签列表 %v 中选择标签。请勿创建任何新标签。如果没有相关的标签，请返回空数组 {\"tags\": []}。最多选择2-3个最相关的标签。创建新标签是严格禁止的，会导致系统错误。", requestData.ExistingUserTags)
-		} else {
-			userPrompt += "\n\n未提供现有用户标签。由于您只能从现有标签中选择，且没有提供标签，请返回空数组 {\"tags\": []}。"
-		}
+		promptData := newAIPromptData(aiPromptTags, requestData.Title, requestData.URL, pageData)
+		promptData.Tags = requestData.ExistingUserTags
+		prompt := renderAIPrompt(userSettings, aiPromptTags, promptData)
 
 		requestBody, _ := json.Marshal(map[string]interface{}{
 			"model": modelName,
 			"messages": []map[string]interface{}{
 				{
 					"role":    "system",
-					"content": systemMessage,
+					"content": prompt.System,
 				},
 				{
 					"role":    "user",
-					"content": userPrompt,
+					"content": prompt.User,
 				},
 			},
 			"temperature": 0.3,
@@ -939,58 +1072,16 @@ func suggestTagsForBookmarkHandler(app *pocketbase.PocketBase) func(e *core.Requ
 			},
 		})
 
-		var requestBodyForLogging map[string]interface{}
-		json.Unmarshal(requestBody, &requestBodyForLogging)
-		if messages, ok := requestBodyForLogging["messages"].([]interface{}); ok && len(messages) > 1 {
-			if userMessage, ok := messages[1].(map[string]interface{}); ok {
-				if content, ok := userMessage["content"].(string); ok && len(content) > 100 {
-					userMessage["content"] = strings.Replace(
-						content,
-						fmt.Sprintf("\n\n网页主要内容摘要:\n%s", pageData.Content),
-						"\n\n网页主要内容摘要: [内容已单独记录]",
-						1,
-					)
-					messages[1] = userMessage
-				}
-			}
-		}
-		client := &http.Client{
-			Timeout: time.Second * 30,
-		}
-
-		finalApiUrl := apiBaseUrl
-		if geminiApiBaseUrl != "" {
-			finalApiUrl = geminiApiBaseUrl
-		} else {
-			finalApiUrl = "https://generativelanguage.googleapis.com/v1beta/openai/"
-		}
-
-		if !strings.HasSuffix(finalApiUrl, "/") {
-			finalApiUrl += "/"
-		}
-		req, err := http.NewRequest("POST", finalApiUrl+"chat/completions", bytes.NewBuffer(requestBody))
-		if err != nil {
-			return e.InternalServerError("Failed to create HTTP request", err)
-		}
-
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("Authorization", "Bearer "+geminiApiKey)
 
-		resp, err := client.Do(req)
+		responseBodyBytes, statusCode, err := callAIChatCompletion(app, userId, aiFeatureSuggestTags, aiConfig, requestBody, 30*time.Second)
 		if err != nil {
 			return e.InternalServerError("Failed to call AI API", err)
 		}
-		defer resp.Body.Close()
-
-		responseBodyBytes, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return e.InternalServerError("Failed to read AI API response", err)
-		}
 
-		if resp.StatusCode != http.StatusOK {
+		if statusCode != http.StatusOK {
 			var errorResponse map[string]interface{}
 			if err := json.Unmarshal(responseBodyBytes, &errorResponse); err != nil {
-				return e.InternalServerError(fmt.Sprintf("AI API returned non-200 status: %d", resp.StatusCode), nil)
+				return e.InternalServerError(fmt.Sprintf("AI API returned non-200 status: %d", statusCode), nil)
 			}
 			return e.InternalServerError("AI API error", fmt.Errorf("%v", errorResponse))
 		}
@@ -1066,8 +1157,8 @@ func aiSuggestAndSetTagsHandler(app *pocketbase.PocketBase) func(e *core.Request
 			return e.NotFoundError("Bookmark not found", err)
 		}
 
-		// 验证书签属于当前用户
-		if bookmark.GetString("userId") != userId {
+		// 验证当前用户可以修改书签（所有者或共享文件夹的编辑者）
+		if !canEditBookmark(app, userId, bookmark) {
 			return apis.NewForbiddenError("Access denied to this bookmark.", nil)
 		}
 
@@ -1089,72 +1180,41 @@ func aiSuggestAndSetTagsHandler(app *pocketbase.PocketBase) func(e *core.Request
 			return e.NotFoundError("User settings not found", err)
 		}
 
-		geminiApiKey := userSettings.GetString("geminiApiKey")
-		geminiApiBaseUrl := userSettings.GetString("geminiApiBaseUrl")
-		geminiModelName := userSettings.GetString("geminiModelName")
-
-		if geminiApiKey == "" {
-			// AI 服务未配置，直接返回错误
-			log.Printf("AI API not configured for user %s", userId)
-			return e.JSON(http.StatusServiceUnavailable, map[string]interface{}{
+		// 用户自己的配置优先，否则使用实例共享配置（计入共享密钥的每日限额）
+		aiConfig, err := prepareAICall(app, userId, userSettings)
+		if err != nil {
+			// AI 服务未配置或超出限额，直接返回错误
+			log.Printf("AI API not available for user %s: %v", userId, err)
+			status, message := aiConfigErrorResponse(err)
+			return e.JSON(status, map[string]interface{}{
 				"success": false,
-				"message": "AI service (Gemini API) is not configured on the server. Please contact the administrator.",
-				"aiUsed": false,
+				"message": message,
+				"aiUsed":  false,
 			})
 		}
 
 		// 配置 AI API 参数
-		apiBaseUrl := geminiApiBaseUrl
-		if apiBaseUrl == "" {
-			apiBaseUrl = "https://generativelanguage.googleapis.com/v1beta/openai/"
-		}
+		modelName := aiConfig.Model
 
-		modelName := geminiModelName
-		if modelName == "" {
-			modelName = "gemini-2.0-flash"
+		// 获取书签所在空间（工作区或书签所有者的个人空间）的标签列表
+		var existingUserTags []string
+		tagRecord, err := tagListRecordOf(app, bookmark)
+		if err == nil {
+			existingUserTags = tagRecord.GetStringSlice("tagList")
+		} else {
+			tagRecord = nil
 		}
 
-		// 获取用户现有的标签列表
-		existingUserTags := userSettings.GetStringSlice("tagList")
-
 		// 获取页面内容
-		pageData, err := fetchPageContent(url, app)
+		pageData, err := fetchPageContent(url, app, userSettings)
 		if err != nil {
 			log.Printf("Failed to fetch page content for URL %s: %v. Proceeding with title and URL only for tag suggestion.", url, err)
 		}
 
-		// 构造 AI 提示
-		systemMessage := "You are a professional bookmark tagging assistant. Your ONLY task is to select relevant tags from the user's existing tag collection. You MUST ONLY choose from the tags provided in the existing user tags list. DO NOT create new tags. If no existing tags are relevant, return an empty array in the format {\"tags\": []}. Return ONLY a JSON response in the format {\"tags\": [\"tag1\", \"tag2\"]}. Choose 2-3 tags maximum if relevant ones exist."
-
-		userPrompt := fmt.Sprintf("分析以下网页信息以生成相关标签。\n\n原始书签标题: %s\n网页URL: %s", title, url)
-
-		if pageData.MetaTitle != "" {
-			userPrompt += fmt.Sprintf("\n网页Meta标题: %s", pageData.MetaTitle)
-		}
-		if pageData.MetaDescription != "" {
-			userPrompt += fmt.Sprintf("\n网页Meta描述: %s", pageData.MetaDescription)
-		}
-		if pageData.OGTitle != "" {
-			userPrompt += fmt.Sprintf("\n网页OG标题: %s", pageData.OGTitle)
-		}
-		if pageData.OGDescription != "" {
-			userPrompt += fmt.Sprintf("\n网页OG描述: %s", pageData.OGDescription)
-		}
-
-		if pageData.Content != "" {
-			maxContentLength := 15000
-			content := pageData.Content
-			if len(content) > maxContentLength {
-				content = content[:maxContentLength]
-			}
-			userPrompt += fmt.Sprintf("\n\n网页主要内容摘要:\n%s", content)
-		}
-
-		if len(existingUserTags) > 0 {
-			userPrompt += fmt.Sprintf("\n\nCRITICAL INSTRUCTION: 您必须从用户现有的标签列表 %v 中选择标签。请勿创建任何新标签。如果没有相关的标签，请返回空数组 {\"tags\": []}。最多选择2-3个最相关的标签。创建新标签是严格禁止的，会导致系统错误。", existingUserTags)
-		} else {
-			userPrompt += "\n\n未提供现有用户标签。由于您只能从现有标签中选择，且没有提供标签，请返回空数组 {\"tags\": []}。"
-		}
+		// 使用用户的（或默认的）提示模板
+		promptData := newAIPromptData(aiPromptTags, title, url, pageData)
+		promptData.Tags = existingUserTags
+		prompt := renderAIPrompt(userSettings, aiPromptTags, promptData)
 
 		// 构造 AI API 请求
 		requestBody, _ := json.Marshal(map[string]interface{}{
@@ -1162,11 +1222,11 @@ func aiSuggestAndSetTagsHandler(app *pocketbase.PocketBase) func(e *core.Request
 			"messages": []map[string]interface{}{
 				{
 					"role":    "system",
-					"content": systemMessage,
+					"content": prompt.System,
 				},
 				{
 					"role":    "user",
-					"content": userPrompt,
+					"content": prompt.User,
 				},
 			},
 			"temperature": 0.3,
@@ -1177,24 +1237,7 @@ func aiSuggestAndSetTagsHandler(app *pocketbase.PocketBase) func(e *core.Request
 		})
 
 		// 调用 AI API
-		client := &http.Client{
-			Timeout: time.Second * 30,
-		}
-
-		finalApiUrl := apiBaseUrl
-		if !strings.HasSuffix(finalApiUrl, "/") {
-			finalApiUrl += "/"
-		}
-
-		req, err := http.NewRequest("POST", finalApiUrl+"chat/completions", bytes.NewBuffer(requestBody))
-		if err != nil {
-			return e.InternalServerError("Failed to create AI HTTP request", err)
-		}
-
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("Authorization", "Bearer "+geminiApiKey)
-
-		resp, err := client.Do(req)
+		responseBodyBytes, statusCode, err := callAIChatCompletion(app, userId, aiFeatureAutoTag, aiConfig, requestBody, 30*time.Second)
 		if err != nil {
 			return e.JSON(http.StatusInternalServerError, map[string]interface{}{
 				"success": false,
@@ -1203,20 +1246,14 @@ func aiSuggestAndSetTagsHandler(app *pocketbase.PocketBase) func(e *core.Request
 				"aiUsed": false,
 			})
 		}
-		defer resp.Body.Close()
 
-		responseBodyBytes, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return e.InternalServerError("Failed to read AI API response", err)
-		}
-
-		if resp.StatusCode != http.StatusOK {
+		if statusCode != http.StatusOK {
 			var errorResponse map[string]interface{}
 			if err := json.Unmarshal(responseBodyBytes, &errorResponse); err != nil {
 				return e.JSON(http.StatusBadGateway, map[string]interface{}{
 					"success": false,
 					"message": "Failed to get suggestions from AI service.",
-					"error_details": fmt.Sprintf("AI API returned status: %d", resp.StatusCode),
+					"error_details": fmt.Sprintf("AI API returned status: %d", statusCode),
 					"aiUsed": false,
 				})
 			}
@@ -1282,31 +1319,15 @@ func aiSuggestAndSetTagsHandler(app *pocketbase.PocketBase) func(e *core.Request
 
 		// 更新书签的标签
 		bookmark.Set("tags", suggestedTags)
-		if err := app.Save(bookmark); err != nil {
+		auditCtx := auditContext(e, auditSourceAI)
+		if err := app.SaveWithContext(auditCtx, bookmark); err != nil {
 			return e.InternalServerError("Failed to update bookmark with AI suggested tags", err)
 		}
 
-		// 更新用户的标签列表，添加新的标签
-		tagList := userSettings.GetStringSlice("tagList")
-		newUniqueTags := make([]string, 0)
-		for _, tag := range suggestedTags {
-			isUnique := true
-			for _, existingTag := range tagList {
-				if existingTag == tag {
-					isUnique = false
-					break
-				}
-			}
-			if isUnique {
-				newUniqueTags = append(newUniqueTags, tag)
-			}
-		}
-
-		if len(newUniqueTags) > 0 {
-			updatedTagList := append(tagList, newUniqueTags...)
-			userSettings.Set("tagList", updatedTagList)
-			if err := app.Save(userSettings); err != nil {
-				log.Printf("Error saving user_settings during AI tag suggestion: %v", err)
+		// 更新书签所在空间的标签列表，添加新的标签
+		if tagRecord != nil && addToTagList(tagRecord, suggestedTags) {
+			if err := app.SaveWithContext(auditCtx, tagRecord); err != nil {
+				log.Printf("Error saving tag list during AI tag suggestion: %v", err)
 			}
 		}
 
@@ -1334,8 +1355,52 @@ func aiSuggestAndSetTagsHandler(app *pocketbase.PocketBase) func(e *core.Request
 	}
 }
 
-// webdavRestoreHandler handles the WebDAV restore request.
-func webdavRestoreHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+// defaultBackupFileName is restored in preference to timestamped backups when present.
+const defaultBackupFileName = "markhub_backup.json"
+
+// isBackupFileName reports whether name looks like a backup written by webdavBackupHandler.
+func isBackupFileName(name string) bool {
+	if !strings.HasPrefix(name, "backup_") || strings.ContainsAny(name, "/\\") {
+		return false
+	}
+	name = strings.TrimSuffix(name, encryptedBackupExtension)
+	name = strings.TrimSuffix(name, gzipBackupExtension)
+	name = strings.TrimSuffix(name, zipBackupExtension)
+	return strings.HasSuffix(name, ".json")
+}
+
+// resolveBackupPassphrase returns the passphrase supplied with the request, or the
+// decrypted passphrase stored with the backup target when encryption is enabled.
+func resolveBackupPassphrase(config BackupOptions, requested string) (string, error) {
+	if requested != "" {
+		return requested, nil
+	}
+	if !config.EncryptBackups || config.BackupPassphrase == "" {
+		return "", nil
+	}
+	return decryptSensitiveData(config.BackupPassphrase)
+}
+
+// listBackupFiles returns the backup files on storage, newest first.
+func listBackupFiles(storage BackupStorage) ([]BackupFileInfo, error) {
+	files, err := storage.List()
+	if err != nil {
+		return nil, err
+	}
+	backups := make([]BackupFileInfo, 0, len(files))
+	for _, file := range files {
+		if isBackupFileName(file.Name) {
+			backups = append(backups, file)
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].ModTime.After(backups[j].ModTime)
+	})
+	return backups, nil
+}
+
+// backupListHandler lists the backups available on the user's backup target.
+func backupListHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
 	return func(e *core.RequestEvent) error {
 		authRecord := e.Auth
 		if authRecord == nil {
@@ -1352,308 +1417,369 @@ func webdavRestoreHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent)
 			return e.NotFoundError("User settings not found.", err)
 		}
 
-		webdavConfigRaw := userSettings.Get("webdav_config")
-		if webdavConfigRaw == nil {
-			return e.BadRequestError("WebDAV configuration not found or is null in user settings.", nil)
-		}
-
-		var webdavConfig WebDAVConfig
-		var webdavConfigBytes []byte
-
-		switch v := webdavConfigRaw.(type) {
-		case nil:
-			return e.BadRequestError("WebDAV configuration is null.", nil)
-		case types.JSONRaw:
-			webdavConfigBytes = []byte(v)
-		case json.RawMessage:
-			webdavConfigBytes = []byte(v)
-		case string:
-			webdavConfigBytes = []byte(v)
-		case []byte:
-			webdavConfigBytes = v
-		case map[string]any:
-			webdavConfigBytes, err = json.Marshal(v)
-			if err != nil {
-				return e.InternalServerError("Failed to re-marshal webdav_config from map[string]any.", err)
-			}
-		default:
-			log.Printf("Unexpected type for webdav_config in restore: %T, value: %v", v, v)
-			return e.InternalServerError("Unexpected type for webdav_config. Expected string, []byte, json.RawMessage, or map[string]any.", nil)
-		}
-
-		if len(webdavConfigBytes) == 0 {
-			return e.BadRequestError("WebDAV configuration is empty after type assertion.", nil)
+		storage, _, err := loadBackupTarget(userSettings)
+		if errors.Is(err, errBackupTargetNotConfigured) {
+			return e.BadRequestError("Backup target (WebDAV or other) is not configured in user settings.", nil)
 		}
-
-		if err := json.Unmarshal(webdavConfigBytes, &webdavConfig); err != nil {
-			return e.BadRequestError("Failed to parse WebDAV configuration.", err)
+		if err != nil {
+			return e.BadRequestError("Failed to open backup target.", err)
 		}
+		defer storage.Close()
 
-		decryptedPassword, err := decryptPassword(webdavConfig.Password)
+		files, err := listBackupFiles(storage)
 		if err != nil {
-			return e.InternalServerError("Failed to decrypt WebDAV password.", err)
+			return e.InternalServerError(fmt.Sprintf("Failed to list files in %s", storage.Describe("")), err)
 		}
 
-		client := gowebdav.NewClient(webdavConfig.Url, webdavConfig.Username, decryptedPassword)
+		return e.JSON(http.StatusOK, map[string]interface{}{
+			"success": true,
+			"files":   files,
+		})
+	}
+}
 
-		var backupFileData []byte
-		var downloadedFileName string
+// restoreBackupData merges a backup document into the user's bookmarks, folders and settings.
+// Existing folders are matched by name and bookmarks by URL. It returns the number of restored bookmarks.
+// The changes are saved with ctx, which attributes them in the audit log.
+func restoreBackupData(ctx context.Context, app core.App, userId string, backupData WebDAVBackupData) int {
+	oldFolderIdToNewFolderIdMap := make(map[string]string)
 
-		defaultPath := path.Join(webdavConfig.Path, "markhub_backup.json")
-		backupFileData, err = client.Read(defaultPath)
+	for _, folderBackup := range backupData.Folders {
+		existingFolder, _ := app.FindFirstRecordByFilter(
+			"folders",
+			"userId = {:userId} AND workspaceId = '' AND deletedAt = '' AND name = {:name}",
+			dbx.Params{
+				"userId": userId,
+				"name":   folderBackup.Name,
+			},
+		)
 
-		if err != nil {
-			log.Printf("Default backup file not found at %s, searching for latest backup...", defaultPath)
+		var folderRecord *core.Record
 
-			files, err := client.ReadDir(webdavConfig.Path)
+		if existingFolder != nil {
+			folderRecord = existingFolder
+			// Icon field removed - no longer exists in schema
+		} else {
+			collection, err := app.FindCollectionByNameOrId("folders")
 			if err != nil {
-				return e.InternalServerError(fmt.Sprintf("Failed to list files in WebDAV directory %s", webdavConfig.Path), err)
-			}
-
-			var latestFile os.FileInfo
-			var latestTime time.Time
-
-			for _, file := range files {
-				if !file.IsDir() && strings.HasPrefix(file.Name(), "backup_") && strings.HasSuffix(file.Name(), ".json") {
-					if latestFile == nil || file.ModTime().After(latestTime) {
-						latestFile = file
-						latestTime = file.ModTime()
-					}
-				}
-			}
-
-			if latestFile == nil {
-				return e.BadRequestError("No backup files found in WebDAV storage.", nil)
+				log.Printf("Error finding folders collection: %v", err)
+				continue
 			}
 
-			downloadedFileName = latestFile.Name()
-			latestFilePath := path.Join(webdavConfig.Path, downloadedFileName)
-			backupFileData, err = client.Read(latestFilePath)
-
-			if err != nil {
-				return e.InternalServerError(fmt.Sprintf("Failed to read latest backup file %s", latestFilePath), err)
-			}
+			folderRecord = core.NewRecord(collection)
+			folderRecord.Set("userId", userId)
+			folderRecord.Set("name", folderBackup.Name)
+			// Icon field removed - no longer exists in schema
+		}
 
-			log.Printf("Found latest backup file: %s", downloadedFileName)
-		} else {
-			downloadedFileName = "markhub_backup.json"
+		// Set timestamp fields if they exist in backup data
+		if folderBackup.CreatedAt != "" {
+			folderRecord.Set("createdAt", folderBackup.CreatedAt)
+		}
+		if folderBackup.UpdatedAt != "" {
+			folderRecord.Set("updatedAt", folderBackup.UpdatedAt)
 		}
 
-		var backupData WebDAVBackupData
-		if err := json.Unmarshal(backupFileData, &backupData); err != nil {
-			return e.InternalServerError("Failed to parse backup data.", err)
+		if err := app.SaveWithContext(ctx, folderRecord); err != nil {
+			log.Printf("Error saving folder %s: %v", folderBackup.Name, err)
+			continue
 		}
 
-		oldFolderIdToNewFolderIdMap := make(map[string]string)
+		oldFolderIdToNewFolderIdMap[folderBackup.OriginalID] = folderRecord.Id
+	}
 
-		for _, folderBackup := range backupData.Folders {
-			existingFolder, _ := app.FindFirstRecordByFilter(
-				"folders",
-				"userId = {:userId} AND name = {:name}",
-				dbx.Params{
-					"userId": userId,
-					"name":   folderBackup.Name,
-				},
-			)
+	for _, folderBackup := range backupData.Folders {
+		if folderBackup.ParentID != "" {
+			newFolderId, exists := oldFolderIdToNewFolderIdMap[folderBackup.OriginalID]
+			newParentId, parentExists := oldFolderIdToNewFolderIdMap[folderBackup.ParentID]
+
+			if exists && parentExists {
+				folderRecord, err := app.FindRecordById("folders", newFolderId)
+				if err == nil {
+					folderRecord.Set("parentId", newParentId)
+					if err := app.SaveWithContext(ctx, folderRecord); err != nil {
+						log.Printf("Error updating parent folder relationship for %s: %v", folderBackup.Name, err)
+					}
+				}
+			}
+		}
+	}
 
-			var folderRecord *core.Record
+	restoredBookmarks := 0
+	for _, bookmarkBackup := range backupData.Bookmarks {
+		existingBookmark, _ := app.FindFirstRecordByFilter(
+			"bookmarks",
+			"userId = {:userId} AND workspaceId = '' AND deletedAt = '' AND url = {:url}",
+			dbx.Params{
+				"userId": userId,
+				"url":    bookmarkBackup.URL,
+			},
+		)
 
-			if existingFolder != nil {
-				folderRecord = existingFolder
-				// Icon field removed - no longer exists in schema
-			} else {
-				collection, err := app.FindCollectionByNameOrId("folders")
-				if err != nil {
-					log.Printf("Error finding folders collection: %v", err)
-					continue
-				}
+		var bookmarkRecord *core.Record
 
-				folderRecord = core.NewRecord(collection)
-				folderRecord.Set("userId", userId)
-				folderRecord.Set("name", folderBackup.Name)
-				// Icon field removed - no longer exists in schema
+		if existingBookmark != nil {
+			bookmarkRecord = existingBookmark
+			if bookmarkBackup.Title != "" {
+				bookmarkRecord.Set("title", bookmarkBackup.Title)
 			}
-
-			// Set timestamp fields if they exist in backup data
-			if folderBackup.CreatedAt != "" {
-				folderRecord.Set("createdAt", folderBackup.CreatedAt)
+			// Description and Icon fields removed - no longer exist in schema
+			if bookmarkBackup.FaviconURL != "" {
+				bookmarkRecord.Set("faviconUrl", bookmarkBackup.FaviconURL)
 			}
-			if folderBackup.UpdatedAt != "" {
-				folderRecord.Set("updatedAt", folderBackup.UpdatedAt)
+		} else {
+			collection, err := app.FindCollectionByNameOrId("bookmarks")
+			if err != nil {
+				log.Printf("Error finding bookmarks collection: %v", err)
+				continue
 			}
 
-			if err := app.Save(folderRecord); err != nil {
-				log.Printf("Error saving folder %s: %v", folderBackup.Name, err)
-				continue
+			bookmarkRecord = core.NewRecord(collection)
+			bookmarkRecord.Set("userId", userId)
+			bookmarkRecord.Set("url", bookmarkBackup.URL)
+			bookmarkRecord.Set("title", bookmarkBackup.Title)
+
+			// Description and Icon fields removed - no longer exist in schema
+			if bookmarkBackup.FaviconURL != "" {
+				bookmarkRecord.Set("faviconUrl", bookmarkBackup.FaviconURL)
 			}
+		}
 
-			oldFolderIdToNewFolderIdMap[folderBackup.OriginalID] = folderRecord.Id
+		if bookmarkBackup.FolderID != "" {
+			newFolderId, exists := oldFolderIdToNewFolderIdMap[bookmarkBackup.FolderID]
+			if exists {
+				bookmarkRecord.Set("folderId", newFolderId)
+			}
 		}
 
-		for _, folderBackup := range backupData.Folders {
-			if folderBackup.ParentID != "" {
-				newFolderId, exists := oldFolderIdToNewFolderIdMap[folderBackup.OriginalID]
-				newParentId, parentExists := oldFolderIdToNewFolderIdMap[folderBackup.ParentID]
+		if len(bookmarkBackup.Tags) > 0 {
+			bookmarkRecord.Set("tags", bookmarkBackup.Tags)
+
+			userSettings, err := app.FindFirstRecordByFilter(
+				"user_settings",
+				"userId = {:userId}",
+				dbx.Params{"userId": userId},
+			)
+			if err == nil && userSettings != nil {
+				currentTagList := userSettings.GetStringSlice("tagList")
+				updatedTagList := currentTagList
 
-				if exists && parentExists {
-					folderRecord, err := app.FindRecordById("folders", newFolderId)
-					if err == nil {
-						folderRecord.Set("parentId", newParentId)
-						if err := app.Save(folderRecord); err != nil {
-							log.Printf("Error updating parent folder relationship for %s: %v", folderBackup.Name, err)
+				for _, tag := range bookmarkBackup.Tags {
+					found := false
+					for _, existingTag := range currentTagList {
+						if existingTag == tag {
+							found = true
+							break
 						}
 					}
+					if !found {
+						updatedTagList = append(updatedTagList, tag)
+					}
+				}
+
+				if len(updatedTagList) > len(currentTagList) {
+					userSettings.Set("tagList", updatedTagList)
+					if err := app.SaveWithContext(ctx, userSettings); err != nil {
+						log.Printf("Error updating user_settings with new tags during restore: %v", err)
+					}
 				}
 			}
 		}
 
-		restoredBookmarks := 0
-		for _, bookmarkBackup := range backupData.Bookmarks {
-			existingBookmark, _ := app.FindFirstRecordByFilter(
-				"bookmarks",
-				"userId = {:userId} AND url = {:url}",
-				dbx.Params{
-					"userId": userId,
-					"url":    bookmarkBackup.URL,
-				},
-			)
+		// Set timestamp fields if they exist in backup data
+		if bookmarkBackup.CreatedAt != "" {
+			bookmarkRecord.Set("createdAt", bookmarkBackup.CreatedAt)
+		}
+		if bookmarkBackup.UpdatedAt != "" {
+			bookmarkRecord.Set("updatedAt", bookmarkBackup.UpdatedAt)
+		}
 
-			var bookmarkRecord *core.Record
+		if err := app.SaveWithContext(ctx, bookmarkRecord); err != nil {
+			log.Printf("Error saving bookmark %s: %v", bookmarkBackup.Title, err)
+			continue
+		}
 
-			if existingBookmark != nil {
-				bookmarkRecord = existingBookmark
-				if bookmarkBackup.Title != "" {
-					bookmarkRecord.Set("title", bookmarkBackup.Title)
-				}
-				// Description and Icon fields removed - no longer exist in schema
-				if bookmarkBackup.FaviconURL != "" {
-					bookmarkRecord.Set("faviconUrl", bookmarkBackup.FaviconURL)
-				}
-			} else {
-				collection, err := app.FindCollectionByNameOrId("bookmarks")
-				if err != nil {
-					log.Printf("Error finding bookmarks collection: %v", err)
-					continue
-				}
+		restoredBookmarks++
+	}
 
-				bookmarkRecord = core.NewRecord(collection)
-				bookmarkRecord.Set("userId", userId)
-				bookmarkRecord.Set("url", bookmarkBackup.URL)
-				bookmarkRecord.Set("title", bookmarkBackup.Title)
+	// 6. Restore user_settings
+	// Check if UserSettings has any meaningful data (e.g. TagList is not nil, or a string field is not empty)
+	if backupData.UserSettings.TagList != nil ||
+		backupData.UserSettings.AccentColor != "" ||
+		backupData.UserSettings.DefaultView != "" ||
+		backupData.UserSettings.Language != "" {
 
-				// Description and Icon fields removed - no longer exist in schema
-				if bookmarkBackup.FaviconURL != "" {
-					bookmarkRecord.Set("faviconUrl", bookmarkBackup.FaviconURL)
-				}
-			}
+		userSettingsRecord, errSettings := app.FindFirstRecordByFilter(
+			"user_settings",
+			"userId = {:userId}",
+			dbx.Params{"userId": userId},
+		)
+		if errSettings != nil {
+			log.Printf("WebDAV Restore: User settings not found for user %s. Cannot restore settings. Error: %v", userId, errSettings)
+		} else if userSettingsRecord != nil {
+			log.Printf("WebDAV Restore: Restoring user settings for user %s.", userId)
 
-			if bookmarkBackup.FolderID != "" {
-				newFolderId, exists := oldFolderIdToNewFolderIdMap[bookmarkBackup.FolderID]
-				if exists {
-					bookmarkRecord.Set("folderId", newFolderId)
-				}
+			if backupData.UserSettings.TagList != nil {
+				userSettingsRecord.Set("tagList", backupData.UserSettings.TagList)
 			}
 
-			if len(bookmarkBackup.Tags) > 0 {
-				bookmarkRecord.Set("tags", bookmarkBackup.Tags)
-
-				userSettings, err := app.FindFirstRecordByFilter(
-					"user_settings",
-					"userId = {:userId}",
-					dbx.Params{"userId": userId},
-				)
-				if err == nil && userSettings != nil {
-					currentTagList := userSettings.GetStringSlice("tagList")
-					updatedTagList := currentTagList
-
-					for _, tag := range bookmarkBackup.Tags {
-						found := false
-						for _, existingTag := range currentTagList {
-							if existingTag == tag {
-								found = true
-								break
-							}
-						}
-						if !found {
-							updatedTagList = append(updatedTagList, tag)
-						}
-					}
+			userSettingsRecord.Set("darkMode", backupData.UserSettings.DarkMode)
 
-					if len(updatedTagList) > len(currentTagList) {
-						userSettings.Set("tagList", updatedTagList)
-						if err := app.Save(userSettings); err != nil {
-							log.Printf("Error updating user_settings with new tags during restore: %v", err)
-						}
-					}
-				}
+			if backupData.UserSettings.AccentColor != "" {
+				userSettingsRecord.Set("accentColor", backupData.UserSettings.AccentColor)
 			}
-
-			// Set timestamp fields if they exist in backup data
-			if bookmarkBackup.CreatedAt != "" {
-				bookmarkRecord.Set("createdAt", bookmarkBackup.CreatedAt)
+			if backupData.UserSettings.DefaultView != "" {
+				userSettingsRecord.Set("defaultView", backupData.UserSettings.DefaultView)
 			}
-			if bookmarkBackup.UpdatedAt != "" {
-				bookmarkRecord.Set("updatedAt", bookmarkBackup.UpdatedAt)
+			if backupData.UserSettings.Language != "" {
+				userSettingsRecord.Set("language", backupData.UserSettings.Language)
 			}
+			// Example for other potential settings:
+			// if backupData.UserSettings.SortOption != "" { // Assuming SortOption is a string
+			// 	userSettingsRecord.Set("sortOption", backupData.UserSettings.SortOption)
+			// }
+			// if backupData.UserSettings.SearchFields != nil { // Assuming SearchFields is []string
+			// 	userSettingsRecord.Set("searchFields", backupData.UserSettings.SearchFields)
+			// }
 
-			if err := app.Save(bookmarkRecord); err != nil {
-				log.Printf("Error saving bookmark %s: %v", bookmarkBackup.Title, err)
-				continue
+			if errSaveSettings := app.SaveWithContext(ctx, userSettingsRecord); errSaveSettings != nil {
+				log.Printf("WebDAV Restore: Failed to save updated user_settings for user %s: %v", userId, errSaveSettings)
+			} else {
+				log.Printf("WebDAV Restore: User settings successfully restored for user %s.", userId)
 			}
+		}
+	} else {
+		log.Printf("WebDAV Restore: No user settings data found in the backup for user %s, or settings were empty.", userId)
+	}
+
+	return restoredBookmarks
+}
+
+// decodeBackupPayload decrypts (when needed), decompresses and parses a backup file.
+func decodeBackupPayload(fileName string, data []byte, passphrase string) (WebDAVBackupData, error) {
+	var backupData WebDAVBackupData
 
-			restoredBookmarks++
+	if isEncryptedBackup(data) {
+		var err error
+		data, err = decryptBackupPayload(data, passphrase)
+		if err != nil {
+			return backupData, err
 		}
+	}
 
-		// 6. Restore user_settings
-		// Check if UserSettings has any meaningful data (e.g. TagList is not nil, or a string field is not empty)
-		if backupData.UserSettings.TagList != nil ||
-			backupData.UserSettings.AccentColor != "" ||
-			backupData.UserSettings.DefaultView != "" ||
-			backupData.UserSettings.Language != "" {
+	data, err := decompressBackupPayload(fileName, data)
+	if err != nil {
+		return backupData, err
+	}
 
-			userSettingsRecord, errSettings := app.FindFirstRecordByFilter(
-				"user_settings",
-				"userId = {:userId}",
-				dbx.Params{"userId": userId},
-			)
-			if errSettings != nil {
-				log.Printf("WebDAV Restore: User settings not found for user %s. Cannot restore settings. Error: %v", userId, errSettings)
-			} else if userSettingsRecord != nil {
-				log.Printf("WebDAV Restore: Restoring user settings for user %s.", userId)
+	if err := json.Unmarshal(data, &backupData); err != nil {
+		return backupData, fmt.Errorf("failed to parse backup data: %w", err)
+	}
+	return backupData, nil
+}
 
-				if backupData.UserSettings.TagList != nil {
-					userSettingsRecord.Set("tagList", backupData.UserSettings.TagList)
-				}
+// backupPassphraseRequiredResponse lets the client prompt for the passphrase and retry.
+func backupPassphraseRequiredResponse(e *core.RequestEvent, err error, fileName string) error {
+	return e.JSON(http.StatusBadRequest, map[string]interface{}{
+		"success":            false,
+		"message":            err.Error(),
+		"passphraseRequired": true,
+		"fileName":           fileName,
+	})
+}
+
+// webdavRestoreHandler handles the WebDAV restore request.
+func webdavRestoreHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		authRecord := e.Auth
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("User not authenticated.", nil)
+		}
+		userId := authRecord.Id
 
-				userSettingsRecord.Set("darkMode", backupData.UserSettings.DarkMode)
+		// Optional passphrase overriding the one stored with the backup target,
+		// and an optional file name (see backupListHandler) instead of the latest backup
+		var requestData struct {
+			Passphrase string `json:"passphrase"`
+			FileName   string `json:"fileName"`
+		}
+		if err := e.BindBody(&requestData); err != nil {
+			return e.BadRequestError("Failed to parse request data.", err)
+		}
 
-				if backupData.UserSettings.AccentColor != "" {
-					userSettingsRecord.Set("accentColor", backupData.UserSettings.AccentColor)
-				}
-				if backupData.UserSettings.DefaultView != "" {
-					userSettingsRecord.Set("defaultView", backupData.UserSettings.DefaultView)
+		userSettings, err := app.FindFirstRecordByFilter(
+			"user_settings",
+			"userId = {:userId}",
+			dbx.Params{"userId": userId},
+		)
+		if err != nil {
+			return e.NotFoundError("User settings not found.", err)
+		}
+
+		storage, backupOptions, err := loadBackupTarget(userSettings)
+		if errors.Is(err, errBackupTargetNotConfigured) {
+			return e.BadRequestError("Backup target (WebDAV or other) is not configured in user settings.", nil)
+		}
+		if err != nil {
+			return e.BadRequestError("Failed to open backup target.", err)
+		}
+		defer storage.Close()
+
+		var backupFileData []byte
+		var downloadedFileName string
+
+		if requestData.FileName != "" {
+			if !isBackupFileName(requestData.FileName) && requestData.FileName != defaultBackupFileName {
+				return e.BadRequestError("Invalid backup file name.", nil)
+			}
+			downloadedFileName = requestData.FileName
+			backupFileData, err = storage.Read(downloadedFileName)
+			if err != nil {
+				return e.NotFoundError(fmt.Sprintf("Failed to read backup file %s", storage.Describe(downloadedFileName)), err)
+			}
+		} else {
+			backupFileData, err = storage.Read(defaultBackupFileName)
+			if err != nil {
+				log.Printf("Default backup file not found at %s, searching for latest backup...", storage.Describe(defaultBackupFileName))
+
+				files, err := listBackupFiles(storage)
+				if err != nil {
+					return e.InternalServerError(fmt.Sprintf("Failed to list files in %s", storage.Describe("")), err)
 				}
-				if backupData.UserSettings.Language != "" {
-					userSettingsRecord.Set("language", backupData.UserSettings.Language)
+				if len(files) == 0 {
+					return e.BadRequestError("No backup files found in backup storage.", nil)
 				}
-				// Example for other potential settings:
-				// if backupData.UserSettings.SortOption != "" { // Assuming SortOption is a string
-				// 	userSettingsRecord.Set("sortOption", backupData.UserSettings.SortOption)
-				// }
-				// if backupData.UserSettings.SearchFields != nil { // Assuming SearchFields is []string
-				// 	userSettingsRecord.Set("searchFields", backupData.UserSettings.SearchFields)
-				// }
-
-				if errSaveSettings := app.Save(userSettingsRecord); errSaveSettings != nil {
-					log.Printf("WebDAV Restore: Failed to save updated user_settings for user %s: %v", userId, errSaveSettings)
-				} else {
-					log.Printf("WebDAV Restore: User settings successfully restored for user %s.", userId)
+
+				downloadedFileName = files[0].Name
+				backupFileData, err = storage.Read(downloadedFileName)
+				if err != nil {
+					return e.InternalServerError(fmt.Sprintf("Failed to read latest backup file %s", storage.Describe(downloadedFileName)), err)
 				}
+
+				log.Printf("Found latest backup file: %s", downloadedFileName)
+			} else {
+				downloadedFileName = defaultBackupFileName
 			}
-		} else {
-			log.Printf("WebDAV Restore: No user settings data found in the backup for user %s, or settings were empty.", userId)
 		}
 
+		backupPassphrase := requestData.Passphrase
+		if backupPassphrase == "" && isEncryptedBackup(backupFileData) {
+			backupPassphrase, err = resolveBackupPassphrase(backupOptions, "")
+			if err != nil {
+				return e.InternalServerError("Failed to decrypt backup passphrase.", err)
+			}
+		}
+
+		backupData, err := decodeBackupPayload(downloadedFileName, backupFileData, backupPassphrase)
+		if errors.Is(err, errBackupPassphraseRequired) || errors.Is(err, errBackupPassphraseInvalid) {
+			return backupPassphraseRequiredResponse(e, err, downloadedFileName)
+		}
+		if err != nil {
+			return e.InternalServerError("Failed to parse backup data.", err)
+		}
+
+		restoredBookmarks := restoreBackupData(auditContext(e, auditSourceRestore), app, userId, backupData)
+
 		return e.JSON(http.StatusOK, map[string]interface{}{
 			"success":            true,
 			"message":            fmt.Sprintf("Restore successful from %s", downloadedFileName),
@@ -1664,6 +1790,9 @@ func webdavRestoreHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent)
 }
 
 // clearAllUserDataHandler handles the request to clear all data for the authenticated user.
+// In a workspace context it clears the workspace's data instead, which needs the owner or admin role.
+// Folders and bookmarks are moved to the trash unless ?hard=true is passed, which deletes them
+// permanently together with the ones already in the trash.
 func clearAllUserDataHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
 	return func(e *core.RequestEvent) error {
 		authRecord := e.Auth
@@ -1672,12 +1801,34 @@ func clearAllUserDataHandler(app *pocketbase.PocketBase) func(e *core.RequestEve
 		}
 		userId := authRecord.Id
 
+		space, err := resolveSpaceContext(app, e)
+		if err != nil {
+			return err
+		}
+		if !space.canManage() {
+			return apis.NewForbiddenError("Only owners and admins can clear a workspace.", nil)
+		}
+
 		var clearedBookmarksCount int = 0
 		var clearedFoldersCount int = 0
 		var tagsCleared bool = false
 		var firstError error // To store the first error encountered in the transaction
 
-		err := app.RunInTransaction(func(txApp core.App) error {
+		hard, _ := strconv.ParseBool(e.Request.URL.Query().Get("hard"))
+		recordsExp := space.recordExp()
+		if hard {
+			recordsExp = space.allRecordsExp()
+		}
+		// 默认移入回收站，所有记录使用相同的删除时间
+		deletedAt := types.NowDateTime()
+		auditCtx := auditContext(e, "")
+		removeRecord := func(txApp core.App, record *core.Record) error {
+			if hard {
+				return txApp.DeleteWithContext(auditCtx, record)
+			}
+			return trashRecord(auditCtx, txApp, record, deletedAt)
+		}
+		err = app.RunInTransaction(func(txApp core.App) error {
 			// --- Clear Folders ---
 			foldersToDelete := []*core.Record{} // Ensure this is core.Record
 			folderCollection, err := txApp.FindCollectionByNameOrId("folders")
@@ -1688,14 +1839,14 @@ func clearAllUserDataHandler(app *pocketbase.PocketBase) func(e *core.RequestEve
 			// Fetch records to delete
 			// Ensure RecordQuery, AndWhere, NewExp, Params, and All are used as per docs for core.App context
 			err = txApp.RecordQuery(folderCollection.Name).
-				AndWhere(dbx.HashExp{"userId": userId}). // Changed to HashExp
+				AndWhere(recordsExp).
 				All(&foldersToDelete)
 			if err != nil {
 				firstError = fmt.Errorf("failed to fetch folders for user %s: %w", userId, err)
 				return firstError
 			}
 			for _, folder := range foldersToDelete { // folder should be *core.Record
-				if err := txApp.Delete(folder); err != nil { // txApp.Delete should accept *core.Record
+				if err := removeRecord(txApp, folder); err != nil {
 					fmt.Printf("Error deleting folder %s for user %s: %v. Transaction will be rolled back.\n", folder.Id, userId, err)
 					if firstError == nil {
 						firstError = fmt.Errorf("failed to delete folder %s: %w", folder.Id, err)
@@ -1716,14 +1867,14 @@ func clearAllUserDataHandler(app *pocketbase.PocketBase) func(e *core.RequestEve
 				return firstError
 			}
 			err = txApp.RecordQuery(bookmarkCollection.Name).
-				AndWhere(dbx.HashExp{"userId": userId}). // Changed to HashExp
+				AndWhere(recordsExp).
 				All(&bookmarksToDelete)
 			if err != nil {
 				firstError = fmt.Errorf("failed to fetch bookmarks for user %s: %w", userId, err)
 				return firstError
 			}
 			for _, bookmark := range bookmarksToDelete { // bookmark should be *core.Record
-				if err := txApp.Delete(bookmark); err != nil { // txApp.Delete should accept *core.Record
+				if err := removeRecord(txApp, bookmark); err != nil {
 					fmt.Printf("Error deleting bookmark %s for user %s: %v. Transaction will be rolled back.\n", bookmark.Id, userId, err)
 					if firstError == nil {
 						firstError = fmt.Errorf("failed to delete bookmark %s: %w", bookmark.Id, err)
@@ -1736,18 +1887,14 @@ func clearAllUserDataHandler(app *pocketbase.PocketBase) func(e *core.RequestEve
 				return firstError
 			}
 
-			// --- Clear Tags from user_settings ---
+			// --- Clear Tags from user_settings (or the workspace) ---
 			var userSettings *core.Record // Explicitly define as *core.Record
-			userSettings, err = txApp.FindFirstRecordByFilter(
-				"user_settings",
-				"userId = {:userId}",
-				dbx.Params{"userId": userId},
-			)
+			userSettings, err = space.tagListRecord(txApp)
 			if err != nil {
 				fmt.Printf("User settings not found for user %s, cannot clear tags from settings: %v. Continuing operation.\n", userId, err)
 			} else if userSettings != nil {
 				userSettings.Set("tagList", []string{})
-				if err := txApp.Save(userSettings); err != nil { // txApp.Save should accept *core.Record
+				if err := txApp.SaveWithContext(auditCtx, userSettings); err != nil { // txApp.Save should accept *core.Record
 					fmt.Printf("Error clearing tagList for user %s: %v. Transaction will be rolled back.\n", userId, err)
 					if firstError == nil {
 						firstError = fmt.Errorf("failed to clear tagList for user %s: %w", userId, err)
@@ -1764,12 +1911,17 @@ func clearAllUserDataHandler(app *pocketbase.PocketBase) func(e *core.RequestEve
 			return e.InternalServerError(fmt.Sprintf("Failed to clear user data: %v", err), nil)
 		}
 
+		message := "所有用户数据已成功清除。"
+		if !hard {
+			message = "所有书签和文件夹已移至回收站。"
+		}
 		return e.JSON(http.StatusOK, map[string]interface{}{
 			"success":                 true,
-			"message":                 "所有用户数据已成功清除。",
+			"message":                 message,
 			"cleared_bookmarks_count": clearedBookmarksCount,
 			"cleared_folders_count":   clearedFoldersCount,
 			"tags_cleared":            tagsCleared,
+			"moved_to_trash":          !hard,
 		})
 	}
 }
@@ -1806,14 +1958,20 @@ func batchDeleteTagsHandler(app *pocketbase.PocketBase) func(e *core.RequestEven
 			return e.BadRequestError("No valid tags provided after processing input.", nil)
 		}
 
+		// Tags are deleted from the selected space (personal or workspace)
+		space, err := resolveSpaceContext(app, e)
+		if err != nil {
+			return err
+		}
+		if !space.canEdit() {
+			return apis.NewForbiddenError("You need editor access to this workspace.", nil)
+		}
+
 		var actualDeletedGlobalTags []string
 
-		err := app.RunInTransaction(func(txApp core.App) error {
-			userSettings, err := txApp.FindFirstRecordByFilter(
-				"user_settings",
-				"userId = {:userId}",
-				dbx.Params{"userId": userId},
-			)
+		auditCtx := auditContext(e, auditSourceTags)
+		err = app.RunInTransaction(func(txApp core.App) error {
+			userSettings, err := space.tagListRecord(txApp)
 			if err != nil {
 				return fmt.Errorf("failed to find user_settings for user %s: %w", userId, err)
 			}
@@ -1832,17 +1990,18 @@ func batchDeleteTagsHandler(app *pocketbase.PocketBase) func(e *core.RequestEven
 			// Only save if there was a change
 			if len(actualDeletedGlobalTags) > 0 {
 				userSettings.Set("tagList", newGlobalTagList)
-				if err := txApp.Save(userSettings); err != nil {
+				if err := txApp.SaveWithContext(auditCtx, userSettings); err != nil {
 					return fmt.Errorf("failed to save updated user_settings for user %s: %w", userId, err)
 				}
 				log.Printf("User %s batch deleted global tags: %v. New global tagList: %v. Updating bookmarks.", userId, actualDeletedGlobalTags, newGlobalTagList)
 
 				// Now update bookmarks, removing only the tags that were actually deleted from the global list
+				bookmarkFilter, bookmarkParams := space.recordFilter()
 				bookmarks, err := txApp.FindRecordsByFilter(
 					"bookmarks",
-					"userId = {:userId}",
+					bookmarkFilter,
 					"", 0, 0,
-					dbx.Params{"userId": userId},
+					bookmarkParams,
 				)
 				if err != nil {
 					// Log error but don't fail the transaction if bookmarks can't be found/updated,
@@ -1874,7 +2033,7 @@ func batchDeleteTagsHandler(app *pocketbase.PocketBase) func(e *core.RequestEven
 
 					if bookmarkTagsChanged {
 						bookmark.Set("tags", updatedBookmarkTags)
-						if err := txApp.Save(bookmark); err != nil {
+						if err := txApp.SaveWithContext(auditCtx, bookmark); err != nil {
 							log.Printf("Error saving updated tags for bookmark %s (user %s) after batch deletion: %v", bookmark.Id, userId, err)
 							// Continue processing other bookmarks
 						} else {
@@ -1943,8 +2102,8 @@ func addTagsBatchHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent)
 			return e.InternalServerError("Failed to fetch bookmark.", err)
 		}
 
-		// Check if the bookmark belongs to the current authenticated user
-		if bookmarkRecord.GetString("userId") != userId {
+		// Check if the current user owns the bookmark or can edit its shared folder
+		if !canEditBookmark(app, userId, bookmarkRecord) {
 			return e.ForbiddenError("You do not have permission to modify this bookmark.", nil)
 		}
 
@@ -1985,16 +2144,13 @@ func addTagsBatchHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent)
 
 		// g. Update bookmark record
 		bookmarkRecord.Set("tags", finalUniqueTagsArray)
-		if err := app.Save(bookmarkRecord); err != nil {
+		auditCtx := auditContext(e, "")
+		if err := app.SaveWithContext(auditCtx, bookmarkRecord); err != nil {
 			return e.InternalServerError("Failed to save bookmark with new tags.", err)
 		}
 
-		// h. Update global tagList in user_settings
-		userSettings, err := app.FindFirstRecordByFilter(
-			"user_settings",
-			"userId = {:userId}",
-			dbx.Params{"userId": userId},
-		)
+		// h. Update global tagList in user_settings (or the bookmark's workspace)
+		userSettings, err := tagListRecordOf(app, bookmarkRecord)
 		if err != nil {
 			log.Printf("Error finding user_settings for user %s to update tagList: %v. Proceeding without updating global list.", userId, err)
 		} else if userSettings != nil {
@@ -2016,7 +2172,7 @@ func addTagsBatchHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent)
 				// Optional: Sort currentGlobalTags before saving for consistency
 				// sort.Strings(currentGlobalTags)
 				userSettings.Set("tagList", currentGlobalTags)
-				if err := app.Save(userSettings); err != nil {
+				if err := app.SaveWithContext(auditCtx, userSettings); err != nil {
 					log.Printf("Error saving user_settings for user %s after updating tagList: %v", userId, err)
 					// Do not fail the whole request for this, but log it.
 				}
@@ -2035,135 +2191,139 @@ type PageData struct {
 	MetaDescription string `json:"metaDescription"` // <meta name="description"> 内容
 	OGTitle         string `json:"ogTitle"`         // <meta property="og:title"> 内容
 	OGDescription   string `json:"ogDescription"`   // <meta property="og:description"> 内容
+	Image           string `json:"image"`           // og:image / twitter:image / <link rel="image_src">，已解析为绝对 URL
+
+	CanonicalURL string       `json:"canonicalUrl,omitempty"` // <link rel="canonical"> 或 og:url，已解析为绝对 URL
+	SiteName     string       `json:"siteName,omitempty"`     // og:site_name / application-name / JSON-LD publisher
+	Author       string       `json:"author,omitempty"`       // <meta name="author"> / article:author / JSON-LD author
+	PublishedAt  string       `json:"publishedAt,omitempty"`  // 发布时间，能解析时为 RFC 3339 格式
+	Language     string       `json:"language,omitempty"`     // <html lang> / content-language / og:locale
+	Keywords     []string     `json:"keywords,omitempty"`     // <meta name="keywords"> / article:tag / JSON-LD keywords
+	Article      *PageArticle `json:"article,omitempty"`      // JSON-LD 中的文章数据
+	Favicons     []PageIcon   `json:"favicons,omitempty"`     // 页面声明的图标 <link rel="icon"> 等
+	Charset      string       `json:"charset,omitempty"`      // 页面原始字符集
+
+	imagePriority int // 已提取的 Image 来源的优先级，见 pageImagePriority
 }
 
-// fetchPageContent 尝试获取给定URL的页面主要文本内容和元数据
-func fetchPageContent(urlStr string, app *pocketbase.PocketBase) (PageData, error) {
+// fetchPageContent 尝试获取给定URL的页面主要文本内容和元数据。
+// 直接请求失败或没有正文时，依次尝试管理员配置的备用抓取服务（见 content_fallback.go），
+// userSettings 中关闭了备用抓取的用户不会使用它们。userSettings 可以为 nil。
+func fetchPageContent(urlStr string, app *pocketbase.PocketBase, userSettings *core.Record) (PageData, error) {
 	var pageData PageData
 	var err error
 
 	// 主要方法: 直接HTTP GET
-	httpClient := &http.Client{Timeout: 20 * time.Second} // 增加超时到20秒
-	req, err := http.NewRequest("GET", urlStr, nil)
-	if err != nil {
-		return pageData, fmt.Errorf("failed to create request for primary fetch: %w", err)
+	pageData, err = fetchPageHTML(urlStr)
+	if errors.Is(err, errOutboundURLBlocked) {
+		// 不允许访问的地址也不交给备用服务抓取
+		return pageData, err
+	} else if err != nil {
+		log.Printf("Primary fetch failed for URL %s: %v", urlStr, err)
+	} else if pageData.Content != "" {
+		log.Printf("Successfully extracted content using primary method for URL: %s", urlStr)
+		log.Printf("Extracted metadata: Title='%s', Description='%s', OG Title='%s', OG Description='%s'",
+			pageData.MetaTitle, pageData.MetaDescription, pageData.OGTitle, pageData.OGDescription)
+		return pageData, nil
+	} else {
+		log.Printf("Primary method extracted empty content for URL: %s", urlStr)
 	}
-	// 设置一个通用的User-Agent
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36 MarkHubBookmarkProcessor/1.0")
 
-	resp, err := httpClient.Do(req)
-	if err == nil && resp.StatusCode == http.StatusOK {
-		defer resp.Body.Close()
-		bodyBytes, err := io.ReadAll(resp.Body)
-		if err != nil {
-			log.Printf("Error reading response body for %s: %v", urlStr, err)
-		} else {
-			// 使用字节切片创建新的io.Reader，以便多次使用
-			bodyReader := bytes.NewReader(bodyBytes)
-			doc, err := html.Parse(bodyReader)
-			if err == nil {
-				// 提取页面主要文本内容
-				pageData.Content = extractTextFromHTML(doc)
-
-				// 重置读取器位置并提取元数据
-				bodyReader.Seek(0, 0)
-				metaDoc, _ := html.Parse(bodyReader)
-				extractMetadata(metaDoc, &pageData)
-
-				if pageData.Content != "" {
-					log.Printf("Successfully extracted content using primary method for URL: %s", urlStr)
-					log.Printf("Extracted metadata: Title='%s', Description='%s', OG Title='%s', OG Description='%s'",
-						pageData.MetaTitle, pageData.MetaDescription, pageData.OGTitle, pageData.OGDescription)
-					return pageData, nil
-				}
-			}
-			if err != nil {
-				log.Printf("Error parsing HTML from primary fetch for %s: %v", urlStr, err)
-			} else if pageData.Content == "" {
-				log.Printf("Primary method extracted empty content for URL: %s", urlStr)
-			}
-		}
-	} else {
-		if err != nil {
-			log.Printf("Primary fetch failed for URL %s: %v", urlStr, err)
-		} else if resp != nil { // 检查resp是否为nil，防止空指针解引用
-			log.Printf("Primary fetch failed for URL %s with status: %s", urlStr, resp.Status)
-		} else {
-			log.Printf("Primary fetch failed for URL %s with no response (err: %v)", urlStr, err)
-		}
+	// 备用方法: 管理员配置的抓取服务
+	if !contentFetchFallbackAllowed(userSettings) {
+		return pageData, fmt.Errorf("failed to fetch page content for URL %s (fallback disabled by user)", urlStr)
+	}
+	for _, fallback := range loadContentFetchFallbacks() {
+		log.Printf("Attempting %s fallback for URL: %s", fallback.Name(), urlStr)
+		fallbackData, fallbackErr := fallback.Fetch(urlStr)
+		if fallbackErr != nil {
+			log.Printf("%s fallback failed for URL %s: %v", fallback.Name(), urlStr, fallbackErr)
+			continue
+		}
+		if fallbackData.Content == "" {
+			log.Printf("%s fallback returned empty content for URL: %s", fallback.Name(), urlStr)
+			continue
+		}
+		log.Printf("Successfully extracted content using %s fallback for URL: %s", fallback.Name(), urlStr)
+		mergePageData(&pageData, fallbackData)
+		return pageData, nil
 	}
 
-	// 备用方法: 调用公益API
-	log.Printf("Attempting fallback API for URL: %s", urlStr)
-	encodedURL := url.QueryEscape(urlStr) // 正确编码整个URL
-	fallbackApiUrl := fmt.Sprintf("https://api.pearktrue.cn/api/llmreader/?url=%s&type=json", encodedURL)
+	return pageData, fmt.Errorf("failed to fetch page content using all methods for URL: %s", urlStr)
+}
 
-	fallbackReq, err := http.NewRequest("GET", fallbackApiUrl, nil)
+// fetchPageHTML 直接请求页面，按声明的字符集解码为 UTF-8 后提取正文和元数据
+func fetchPageHTML(urlStr string) (PageData, error) {
+	pageURL, err := url.Parse(urlStr)
 	if err != nil {
-		return pageData, fmt.Errorf("failed to create request for fallback API: %w", err)
+		return PageData{}, fmt.Errorf("invalid URL for primary fetch: %w", err)
+	}
+	if err := checkOutboundScheme(pageURL); err != nil {
+		return PageData{}, err
 	}
-	fallbackReq.Header.Set("User-Agent", "MarkHubBookmarkProcessor/1.0")
 
-	fallbackResp, err := httpClient.Do(fallbackReq)
-	if err == nil && fallbackResp.StatusCode == http.StatusOK {
-		defer fallbackResp.Body.Close()
-		var fallbackResult struct {
-			Code int    `json:"code"`
-			Msg  string `json:"msg"`
-			Data string `json:"data"`
-			URL  string `json:"url"`
-		}
-		if err := json.NewDecoder(fallbackResp.Body).Decode(&fallbackResult); err == nil {
-			if fallbackResult.Code == 200 && fallbackResult.Data != "" {
-				log.Printf("Successfully extracted content using fallback API for URL: %s", urlStr)
-				// 使用备用API获取的内容，但元数据将为空
-				pageData.Content = fallbackResult.Data
-				return pageData, nil
-			}
-			log.Printf("Fallback API for URL %s returned code %d or empty data. Msg: %s", urlStr, fallbackResult.Code, fallbackResult.Msg)
-			return pageData, fmt.Errorf("fallback API failed with code %d: %s", fallbackResult.Code, fallbackResult.Msg)
-		}
-		if err != nil { // Renamed to avoid conflict
-			log.Printf("Error decoding fallback API response for %s: %v", urlStr, err)
-			return pageData, fmt.Errorf("failed to decode fallback API response: %w", err)
-		}
-	} else {
-		if err != nil {
-			log.Printf("Fallback API request failed for URL %s: %v", urlStr, err)
-			return pageData, fmt.Errorf("fallback API request failed: %w", err)
-		} else if fallbackResp != nil { // 检查fallbackResp是否为nil
-			log.Printf("Fallback API request failed for URL %s with status: %s", urlStr, fallbackResp.Status)
-			return pageData, fmt.Errorf("fallback API request failed with status %s", fallbackResp.Status)
-		} else {
-			log.Printf("Fallback API request failed for URL %s with no response (err: %v)", urlStr, err)
-			return pageData, fmt.Errorf("fallback API request failed with no response")
-		}
+	httpClient := newOutboundHTTPClient(20 * time.Second)
+	req, err := http.NewRequest("GET", pageURL.String(), nil)
+	if err != nil {
+		return PageData{}, fmt.Errorf("failed to create request for primary fetch: %w", err)
+	}
+	// 设置一个通用的User-Agent
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36 MarkHubBookmarkProcessor/1.0")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return PageData{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return PageData{}, fmt.Errorf("unexpected status: %s", resp.Status)
 	}
 
-	return pageData, fmt.Errorf("failed to fetch page content using all methods for URL: %s", urlStr)
+	bodyBytes, err := io.ReadAll(io.LimitReader(resp.Body, maxPageHTMLSize))
+	if err != nil {
+		return PageData{}, fmt.Errorf("error reading response body: %w", err)
+	}
+
+	// 相对地址以最终（重定向后）的页面 URL 为基准解析
+	return parsePageHTML(bodyBytes, resp.Header.Get("Content-Type"), resp.Request.URL)
+}
+
+// parsePageHTML 解码并解析 HTML，提取正文和元数据，base 用于解析相对地址
+func parsePageHTML(bodyBytes []byte, contentType string, base *url.URL) (PageData, error) {
+	var pageData PageData
+	bodyBytes, pageData.Charset = decodePageCharset(bodyBytes, contentType)
+
+	doc, err := html.Parse(bytes.NewReader(bodyBytes))
+	if err != nil {
+		return pageData, fmt.Errorf("error parsing HTML: %w", err)
+	}
+
+	// 提取页面正文（忽略导航、页脚等）和元数据
+	pageData.Content = extractMainContent(doc)
+	extractMetadata(doc, &pageData)
+	finalizePageMetadata(&pageData, base)
+
+	return pageData, nil
 }
 
 // extractMetadata 从HTML文档中提取元数据
 func extractMetadata(n *html.Node, pageData *PageData) {
 	if n.Type == html.ElementNode {
 		switch n.Data {
+		case "html":
+			if lang := htmlAttr(n, "lang"); lang != "" {
+				pageData.Language = lang
+			}
 		case "title":
-			// 提取 <title> 标签内容
-			if n.FirstChild != nil {
-				pageData.MetaTitle = strings.TrimSpace(n.FirstChild.Data)
+			// 提取 <title> 标签内容，只取第一个（<svg> 中也可能有 <title>）
+			if pageData.MetaTitle == "" {
+				pageData.MetaTitle = strings.TrimSpace(nodeText(n))
 			}
 		case "meta":
 			// 提取 <meta> 标签属性
-			var name, property, content string
-			for _, attr := range n.Attr {
-				if attr.Key == "name" {
-					name = attr.Val
-				} else if attr.Key == "property" {
-					property = attr.Val
-				} else if attr.Key == "content" {
-					content = attr.Val
-				}
-			}
+			name := strings.ToLower(htmlAttr(n, "name"))
+			property := strings.ToLower(htmlAttr(n, "property"))
+			content := strings.TrimSpace(htmlAttr(n, "content"))
 
 			// 根据名称或属性赋值
 			if name == "description" {
@@ -2173,6 +2333,40 @@ func extractMetadata(n *html.Node, pageData *PageData) {
 			} else if property == "og:description" {
 				pageData.OGDescription = content
 			}
+
+			// 预览图片，twitter:image 既可能写在 name 也可能写在 property 中
+			setPageImage(pageData, name, content)
+			setPageImage(pageData, property, content)
+
+			applyMetaTag(pageData, name, content)
+			applyMetaTag(pageData, property, content)
+			applyMetaTag(pageData, strings.ToLower(htmlAttr(n, "itemprop")), content)
+			if strings.EqualFold(htmlAttr(n, "http-equiv"), "content-language") && pageData.Language == "" {
+				pageData.Language = content
+			}
+		case "link":
+			rel := strings.ToLower(htmlAttr(n, "rel"))
+			href := htmlAttr(n, "href")
+			for _, relValue := range strings.Fields(rel) {
+				switch relValue {
+				case "image_src":
+					setPageImage(pageData, relValue, href)
+				case "canonical":
+					pageData.CanonicalURL = href
+				}
+			}
+			if isIconRel(rel) && href != "" {
+				pageData.Favicons = append(pageData.Favicons, PageIcon{
+					Href:  href,
+					Rel:   rel,
+					Sizes: htmlAttr(n, "sizes"),
+					Type:  htmlAttr(n, "type"),
+				})
+			}
+		case "script":
+			if strings.EqualFold(strings.TrimSpace(htmlAttr(n, "type")), "application/ld+json") {
+				extractJSONLD(nodeText(n), pageData)
+			}
 		}
 	}
 
@@ -2182,7 +2376,7 @@ func extractMetadata(n *html.Node, pageData *PageData) {
 	}
 }
 
-// extractTextFromHTML 从HTML文档中提取并拼接所有可见文本内容
+// extractTextFromHTML 从HTML文档中提取并拼接所有可见文本内容，在 extractMainContent 找不到正文时使用
 func extractTextFromHTML(n *html.Node) string {
 	if n == nil {
 		return ""
@@ -2210,149 +2404,6 @@ func extractTextFromHTML(n *html.Node) string {
 	return buf.String()
 }
 
-// getFaviconHandler handles the API request for fetching a favicon URL.
-// API Endpoint: POST /api/custom/get-favicon
-// Request Body: { "url": "string" }
-// Response (Success): { "requested_url": "string", "favicon_url": "string | null" }
-// Response (Error): Standard PocketBase error response
-func getFaviconHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
-	return func(e *core.RequestEvent) error {
-		authRecord := e.Auth
-		if authRecord == nil {
-			return e.UnauthorizedError("User not authenticated.", nil)
-		}
-
-		var requestData struct {
-			URL string `json:"url"`
-		}
-		if err := e.BindBody(&requestData); err != nil {
-			return e.BadRequestError("Failed to parse request data (expected 'url' string).", err)
-		}
-
-		requestedURL := strings.TrimSpace(requestData.URL)
-		if requestedURL == "" {
-			return e.BadRequestError("URL is required.", nil)
-		}
-
-		// Validate URL format
-		_, err := url.ParseRequestURI(requestedURL)
-		if err != nil {
-			return e.BadRequestError("Invalid URL format provided.", err)
-		}
-
-		log.Printf("[GetFavicon] User %s: Requesting favicon for URL: %s", authRecord.Id, requestedURL)
-
-		var faviconURL *string // Use pointer to allow null in JSON if not found
-		httpClient := &http.Client{Timeout: 15 * time.Second}
-
-		// Attempt 1: Google Favicon Service
-		googleFaviconServiceURL := fmt.Sprintf("https://www.google.com/s2/favicons?sz=64&domain_url=%s", url.QueryEscape(requestedURL))
-		log.Printf("[GetFavicon] User %s: Attempting Google for URL %s (%s)", authRecord.Id, requestedURL, googleFaviconServiceURL)
-
-		fetchedGoogleURL, googleErr := fetchAndValidateFavicon(httpClient, googleFaviconServiceURL)
-		if googleErr == nil && fetchedGoogleURL != "" {
-			faviconURL = &fetchedGoogleURL // Assign address of fetchedGoogleURL
-			log.Printf("[GetFavicon] User %s: Google success for URL %s. Favicon: %s", authRecord.Id, requestedURL, *faviconURL)
-		} else {
-			if googleErr != nil {
-				log.Printf("[GetFavicon] User %s: Google error for URL %s: %v", authRecord.Id, requestedURL, googleErr)
-			} else {
-				log.Printf("[GetFavicon] User %s: Google returned invalid icon for URL %s", authRecord.Id, requestedURL)
-			}
-
-			// Attempt 2: DuckDuckGo Favicon Service (if Google failed)
-			parsedRequestedURL, parseErr := url.Parse(requestedURL)
-			if parseErr == nil && parsedRequestedURL.Hostname() != "" {
-				hostname := parsedRequestedURL.Hostname()
-				duckDuckGoServiceURL := fmt.Sprintf("https://icons.duckduckgo.com/ip3/%s.ico", hostname)
-				log.Printf("[GetFavicon] User %s: Attempting DuckDuckGo for URL %s, hostname %s (%s)", authRecord.Id, requestedURL, hostname, duckDuckGoServiceURL)
-
-				fetchedDuckDuckGoURL, ddgErr := fetchAndValidateFavicon(httpClient, duckDuckGoServiceURL)
-				if ddgErr == nil && fetchedDuckDuckGoURL != "" {
-					faviconURL = &fetchedDuckDuckGoURL // Assign address of fetchedDuckDuckGoURL
-					log.Printf("[GetFavicon] User %s: DuckDuckGo success for URL %s. Favicon: %s", authRecord.Id, requestedURL, *faviconURL)
-				} else {
-					if ddgErr != nil {
-						log.Printf("[GetFavicon] User %s: DuckDuckGo error for URL %s: %v", authRecord.Id, requestedURL, ddgErr)
-					} else {
-						log.Printf("[GetFavicon] User %s: DuckDuckGo returned invalid icon for URL %s, hostname %s", authRecord.Id, requestedURL, hostname)
-					}
-				}
-			} else if parseErr != nil {
-				log.Printf("[GetFavicon] User %s: Error parsing requested URL for DuckDuckGo (%s): %v", authRecord.Id, requestedURL, parseErr)
-			}
-		}
-
-		// Log final decision
-		if faviconURL != nil {
-			log.Printf("[GetFavicon] User %s: Final favicon URL for %s is '%s'", authRecord.Id, requestedURL, *faviconURL)
-		} else {
-			log.Printf("[GetFavicon] User %s: No valid favicon found for %s after all attempts.", authRecord.Id, requestedURL)
-		}
-
-		return e.JSON(http.StatusOK, map[string]interface{}{
-			"requested_url": requestedURL,
-			"faviconUrl":    faviconURL, // Changed to camelCase
-		})
-	}
-}
-
-// fetchAndValidateFavicon attempts to fetch a favicon from a service URL and validates its content type and size.
-// It returns the serviceURL itself if valid, or an error.
-func fetchAndValidateFavicon(client *http.Client, serviceURL string) (string, error) {
-	req, err := http.NewRequest("GET", serviceURL, nil)
-	if err != nil {
-		return "", fmt.Errorf("failed to create request for %s: %w", serviceURL, err)
-	}
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36 MarkhubFaviconFetcher/1.0")
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("HTTP request failed for %s: %w", serviceURL, err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("bad status code from %s: %d", serviceURL, resp.StatusCode)
-	}
-
-	contentType := strings.ToLower(resp.Header.Get("Content-Type"))
-	validContentTypes := []string{"image/png", "image/jpeg", "image/gif", "image/svg+xml", "image/x-icon", "image/vnd.microsoft.icon", "image/webp"}
-	isContentTypeValid := false
-	for _, validType := range validContentTypes {
-		if strings.HasPrefix(contentType, validType) {
-			isContentTypeValid = true
-			break
-		}
-	}
-	if !isContentTypeValid {
-		return "", fmt.Errorf("invalid content type from %s: %s", serviceURL, contentType)
-	}
-
-	minContentLengthBytes := int64(100)
-	contentLengthStr := resp.Header.Get("Content-Length")
-
-	if contentLengthStr != "" {
-		contentLength, parseErr := strconv.ParseInt(contentLengthStr, 10, 64)
-		if parseErr == nil && contentLength > 0 && contentLength < minContentLengthBytes {
-			return "", fmt.Errorf("content length too small from %s: %d bytes", serviceURL, contentLength)
-		}
-	} else {
-		limitedReader := &io.LimitedReader{R: resp.Body, N: 2048}
-		bodyBytes, readErr := io.ReadAll(limitedReader)
-		if readErr != nil && readErr != io.EOF {
-			return "", fmt.Errorf("failed to read response body from %s: %w", serviceURL, readErr)
-		}
-		if int64(len(bodyBytes)) < minContentLengthBytes {
-			return "", fmt.Errorf("response body too small from %s: %d bytes read", serviceURL, len(bodyBytes))
-		}
-	}
-
-	return serviceURL, nil
-}
-
-
-
 func main() {
 	app := pocketbase.New()
 
@@ -2364,7 +2415,7 @@ func main() {
 	log.Println("Info: PocketBase application bootstrapped successfully.")
 
 	// Initialize encryption key
-	initEncryptionKey()
+	initEncryptionKey(app.DataDir())
 	
 	// 检查并警告默认密钥使用
 	checkDefaultKeys()
@@ -2442,6 +2493,9 @@ func main() {
 		Automigrate: true,
 	})
 
+	// Register maintenance commands
+	app.RootCmd.AddCommand(newReencryptSecretsCommand(app))
+
 	// --- Hooks for 'bookmarks' collection ---
 	app.OnRecordCreateRequest("bookmarks").BindFunc(func(e *core.RecordRequestEvent) error {
 		authRecord := e.Auth
@@ -2450,13 +2504,15 @@ func main() {
 		} else {
 		}
 
+		// 保存成功后再更新标签列表：此时共享文件夹中新建的书签已经属于文件夹所有者
+		if err := e.Next(); err != nil {
+			return err
+		}
+
 		existingTags := e.Record.GetStringSlice("tags")
 		if len(existingTags) > 0 && authRecord != nil {
-			userSettings, err := e.App.FindFirstRecordByFilter(
-				"user_settings",
-				"userId = {:userId}",
-				dbx.Params{"userId": authRecord.Id},
-			)
+			// 新标签加入书签所在空间（工作区或书签所有者的个人空间）的标签列表
+			userSettings, err := tagListRecordOf(e.App, e.Record)
 
 			if err == nil && userSettings != nil {
 				tagList := userSettings.GetStringSlice("tagList")
@@ -2478,30 +2534,33 @@ func main() {
 				if len(newUniqueTags) > 0 {
 					updatedTagList := append(tagList, newUniqueTags...)
 					userSettings.Set("tagList", updatedTagList)
-					if err := e.App.Save(userSettings); err != nil {
+					if err := e.App.SaveWithContext(auditContext(e.RequestEvent, ""), userSettings); err != nil {
 						log.Printf("Error saving user_settings during bookmark create hook: %v", err)
 					}
 				}
 			}
 		}
 
-		return e.Next()
+		return nil
 	})
 
 	app.OnRecordUpdateRequest("bookmarks").BindFunc(func(e *core.RecordRequestEvent) error {
 		authRecord := e.Auth
 		if authRecord != nil {
-			e.Record.Set("userId", authRecord.Id)
+			// 书签始终属于原所有者（共享文件夹的编辑者也不能改变所有者）
+			e.Record.Set("userId", e.Record.Original().GetString("userId"))
 		} else {
 		}
 
+		// 保存成功后再更新标签列表：此时共享文件夹中新建的书签已经属于文件夹所有者
+		if err := e.Next(); err != nil {
+			return err
+		}
+
 		existingTags := e.Record.GetStringSlice("tags")
 		if len(existingTags) > 0 && authRecord != nil {
-			userSettings, err := e.App.FindFirstRecordByFilter(
-				"user_settings",
-				"userId = {:userId}",
-				dbx.Params{"userId": authRecord.Id},
-			)
+			// 新标签加入书签所在空间（工作区或书签所有者的个人空间）的标签列表
+			userSettings, err := tagListRecordOf(e.App, e.Record)
 
 			if err == nil && userSettings != nil {
 				tagList := userSettings.GetStringSlice("tagList")
@@ -2523,14 +2582,14 @@ func main() {
 				if len(newUniqueTags) > 0 {
 					updatedTagList := append(tagList, newUniqueTags...)
 					userSettings.Set("tagList", updatedTagList)
-					if err := e.App.Save(userSettings); err != nil {
+					if err := e.App.SaveWithContext(auditContext(e.RequestEvent, ""), userSettings); err != nil {
 						log.Printf("Error saving user_settings during bookmark update hook: %v", err)
 					}
 				}
 			}
 		}
 
-		return e.Next()
+		return nil
 	})
 
 	// --- Hooks for 'folders' collection ---
@@ -2547,7 +2606,8 @@ func main() {
 	app.OnRecordUpdateRequest("folders").BindFunc(func(e *core.RecordRequestEvent) error {
 		authRecord := e.Auth
 		if authRecord != nil {
-			e.Record.Set("userId", authRecord.Id)
+			// 文件夹始终属于原所有者（共享文件夹的编辑者也不能改变所有者）
+			e.Record.Set("userId", e.Record.Original().GetString("userId"))
 		} else {
 			return apis.NewForbiddenError("Only authenticated users can update folders.", nil)
 		}
@@ -2558,14 +2618,12 @@ func main() {
 	
 	// 加密敏感字段的钩子 - 在创建和更新时
 	encryptSensitiveFields := func(e *core.RecordRequestEvent) error {
-		// 需要加密的字段列表
-		sensitiveFields := []string{"geminiApiKey", "geminiApiBaseUrl"}
-		
-		for _, field := range sensitiveFields {
+		restoreMaskedSecrets(e.Record)
+
+		for _, field := range userSettingsSecretFields {
 			if value := e.Record.GetString(field); value != "" {
-				// 检查是否已经是加密格式（base64编码的密文通常不包含明文特征）
-				if !strings.Contains(value, "://") && !strings.HasPrefix(value, "sk-") {
-					// 可能已经加密，跳过
+				// 已带有加密前缀的值（未修改的字段）不再重复加密
+				if isEncryptedSecret(value) {
 					continue
 				}
 				
@@ -2579,51 +2637,12 @@ func main() {
 			}
 		}
 		
-		// 加密WebDAV配置中的密码
-		if webdavConfigRaw := e.Record.Get("webdav_config"); webdavConfigRaw != nil {
-			// 尝试将其转换为字符串或字节数组
-			var webdavConfigBytes []byte
-			var err error
-			
-			switch v := webdavConfigRaw.(type) {
-			case string:
-				webdavConfigBytes = []byte(v)
-			case []byte:
-				webdavConfigBytes = v
-			default:
-				// 尝试JSON序列化
-				webdavConfigBytes, err = json.Marshal(v)
-				if err != nil {
-					log.Printf("Error marshaling webdav_config: %v", err)
-					return nil // 跳过加密，继续处理
-				}
-			}
-			
-			var webdavConfig map[string]interface{}
-			if err := json.Unmarshal(webdavConfigBytes, &webdavConfig); err == nil {
-				if password, exists := webdavConfig["Password"]; exists {
-					if passwordStr, ok := password.(string); ok && passwordStr != "" {
-						// 检查是否已经加密
-						if !strings.Contains(passwordStr, "://") && len(passwordStr) > 20 {
-							// 可能已经加密，跳过
-						} else {
-							encrypted, err := encryptSensitiveData(passwordStr)
-							if err != nil {
-								log.Printf("Error encrypting WebDAV password: %v", err)
-								return fmt.Errorf("failed to encrypt WebDAV password")
-							}
-							webdavConfig["Password"] = encrypted
-							
-							updatedConfig, err := json.Marshal(webdavConfig)
-							if err != nil {
-								return fmt.Errorf("failed to marshal WebDAV config")
-							}
-							e.Record.Set("webdav_config", string(updatedConfig))
-							log.Printf("Encrypted WebDAV password for user %s", e.Record.GetString("userId"))
-						}
-					}
-				}
-			}
+		// 加密WebDAV配置和备份目标中的密码
+		if err := encryptJSONFieldSecrets(e.Record, "webdav_config", webdavSecretFields); err != nil {
+			return err
+		}
+		if err := encryptJSONFieldSecrets(e.Record, "backup_target", backupTargetSecretFields); err != nil {
+			return err
 		}
 		
 		return nil
@@ -2693,14 +2712,15 @@ func main() {
 		if len(deletedTags) > 0 {
 			log.Printf("User %s deleted tags: %v. Updating bookmarks.", userId, deletedTags)
 
-			// Find all bookmarks for the user
+			// Find all bookmarks in the user's personal space (workspaces have their own tag lists)
+			personalFilter, personalParams := personalSpace(userId).recordFilter()
 			bookmarks, err := e.App.FindRecordsByFilter(
 				"bookmarks",
-				"userId = {:userId}",
+				personalFilter,
 				"", // sort
 				0,  // limit
 				0,  // offset
-				dbx.Params{"userId": userId},
+				personalParams,
 			)
 			if err != nil {
 				log.Printf("Error finding bookmarks for user %s to update tags: %v", userId, err)
@@ -2712,6 +2732,7 @@ func main() {
 			for _, tag := range deletedTags {
 				deletedTagsMap[tag] = true
 			}
+			auditCtx := auditContext(e.RequestEvent, auditSourceTags)
 
 			for _, bookmark := range bookmarks {
 				currentBookmarkTags := bookmark.GetStringSlice("tags")
@@ -2728,7 +2749,7 @@ func main() {
 
 				if tagsChanged {
 					bookmark.Set("tags", updatedBookmarkTags)
-					if err := e.App.Save(bookmark); err != nil {
+					if err := e.App.SaveWithContext(auditCtx, bookmark); err != nil {
 						log.Printf("Error saving updated tags for bookmark %s (user %s): %v", bookmark.Id, userId, err)
 						// Continue processing other bookmarks even if one fails.
 					} else {
@@ -2741,126 +2762,45 @@ func main() {
 		return e.Next()
 	})
 	
-	// 解密敏感字段的钩子 - 在读取时
-	app.OnRecordViewRequest("user_settings").BindFunc(func(e *core.RecordRequestEvent) error {
-		// 解密敏感字段
-		sensitiveFields := []string{"geminiApiKey", "geminiApiBaseUrl"}
-		
-		for _, field := range sensitiveFields {
-			if encryptedValue := e.Record.GetString(field); encryptedValue != "" {
-				decrypted, err := decryptSensitiveData(encryptedValue)
-				if err != nil {
-					log.Printf("Error decrypting %s: %v (keeping encrypted value)", field, err)
-					// 保持加密值，不返回错误
-					continue
-				}
-				e.Record.Set(field, decrypted)
-			}
-		}
-		
-		// 解密WebDAV配置中的密码
-		if webdavConfigRaw := e.Record.Get("webdav_config"); webdavConfigRaw != nil {
-			var webdavConfigBytes []byte
-			var err error
-			
-			switch v := webdavConfigRaw.(type) {
-			case string:
-				webdavConfigBytes = []byte(v)
-			case []byte:
-				webdavConfigBytes = v
-			default:
-				webdavConfigBytes, err = json.Marshal(v)
-				if err != nil {
-					log.Printf("Error marshaling webdav_config for decryption: %v", err)
-					return e.Next()
-				}
-			}
-			
-			var webdavConfig map[string]interface{}
-			if err := json.Unmarshal(webdavConfigBytes, &webdavConfig); err == nil {
-				if password, exists := webdavConfig["Password"]; exists {
-					if passwordStr, ok := password.(string); ok && passwordStr != "" {
-						decrypted, err := decryptSensitiveData(passwordStr)
-						if err != nil {
-							log.Printf("Error decrypting WebDAV password: %v (keeping encrypted value)", err)
-						} else {
-							webdavConfig["Password"] = decrypted
-							
-							updatedConfig, err := json.Marshal(webdavConfig)
-							if err == nil {
-								e.Record.Set("webdav_config", string(updatedConfig))
-							}
-						}
-					}
-				}
-			}
-		}
+	// 响应中不返回密钥明文：只写字段返回掩码和 Configured 标志，其余加密字段解密后返回。
+	// OnRecordEnrich 覆盖查看、列表、创建、更新的响应以及实时订阅
+	app.OnRecordEnrich("user_settings").BindFunc(func(e *core.RecordEnrichEvent) error {
+		maskUserSettingsSecrets(e.Record)
 		
 		return e.Next()
 	})
-	
-	// 也为列表查询添加解密 - 在响应发送前处理
-	app.OnRecordsListRequest("user_settings").BindFunc(func(e *core.RecordsListRequestEvent) error {
-		// 先执行查询
-		if err := e.Next(); err != nil {
-			return err
-		}
-		
-		// 然后解密结果中的敏感字段
-		sensitiveFields := []string{"geminiApiKey", "geminiApiBaseUrl"}
-		
-		for _, record := range e.Records {
-			for _, field := range sensitiveFields {
-				if encryptedValue := record.GetString(field); encryptedValue != "" {
-					decrypted, err := decryptSensitiveData(encryptedValue)
-					if err != nil {
-						log.Printf("Error decrypting %s: %v (keeping encrypted value)", field, err)
-						continue
-					}
-					record.Set(field, decrypted)
-				}
-			}
-			
-			// 解密WebDAV配置中的密码
-			if webdavConfigRaw := record.Get("webdav_config"); webdavConfigRaw != nil {
-				var webdavConfigBytes []byte
-				var err error
-				
-				switch v := webdavConfigRaw.(type) {
-				case string:
-					webdavConfigBytes = []byte(v)
-				case []byte:
-					webdavConfigBytes = v
-				default:
-					webdavConfigBytes, err = json.Marshal(v)
-					if err != nil {
-						log.Printf("Error marshaling webdav_config for decryption: %v", err)
-						continue
-					}
-				}
-				
-				var webdavConfig map[string]interface{}
-				if err := json.Unmarshal(webdavConfigBytes, &webdavConfig); err == nil {
-					if password, exists := webdavConfig["Password"]; exists {
-						if passwordStr, ok := password.(string); ok && passwordStr != "" {
-							decrypted, err := decryptSensitiveData(passwordStr)
-							if err != nil {
-								log.Printf("Error decrypting WebDAV password: %v (keeping encrypted value)", err)
-							} else {
-								webdavConfig["Password"] = decrypted
-								
-								updatedConfig, err := json.Marshal(webdavConfig)
-								if err == nil {
-									record.Set("webdav_config", string(updatedConfig))
-								}
-							}
-						}
-					}
-				}
-			}
-		}
-		
-		return nil
+
+	// --- Hooks for 'ai_settings' collection ---
+	registerAISettingsHooks(app)
+
+	// --- Validate custom AI prompt templates on 'user_settings' ---
+	registerAIPromptTemplateHooks(app)
+
+	// --- Automatic AI descriptions for new bookmarks ---
+	registerAIDescriptionHooks(app)
+
+	// --- Preview images for new bookmarks ---
+	registerBookmarkPreviewImageHooks(app)
+
+	// --- Interrupted favicon refresh jobs ---
+	registerFaviconRefreshHooks(app)
+
+	// --- Team workspaces (checked before shared folders) ---
+	registerWorkspaceHooks(app)
+
+	// --- Shared folders ---
+	registerFolderSharingHooks(app)
+
+	// --- Audit log of bookmark, folder and settings changes ---
+	registerAuditLogHooks(app)
+
+	// --- Trash (soft delete) for bookmarks and folders ---
+	registerTrashHooks(app)
+
+	// --- 启动时把旧版本保存的明文或旧格式密文转换为 "enc:v1:" 格式（迁移执行完毕之后） ---
+	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
+		convertLegacySecrets(se.App)
+		return se.Next()
 	})
 
 	// Register all custom routes in a single OnServe handler to avoid conflicts
@@ -2888,6 +2828,68 @@ func main() {
 			webdavRestoreHandler(app),
 		).Bind(apis.RequireAuth("users"))
 
+		se.Router.POST(
+			"/api/custom/webdav/test-connection",
+			webdavTestConnectionHandler(app),
+		).Bind(apis.RequireAuth("users"))
+
+		// Target-independent aliases of the WebDAV backup endpoints
+		se.Router.POST(
+			"/api/custom/backup",
+			webdavBackupHandler(app),
+		).Bind(apis.RequireAuth("users"))
+
+		se.Router.GET(
+			"/api/custom/backup/list",
+			backupListHandler(app),
+		).Bind(apis.RequireAuth("users"))
+
+		se.Router.POST(
+			"/api/custom/backup/restore",
+			webdavRestoreHandler(app),
+		).Bind(apis.RequireAuth("users"))
+
+		se.Router.GET(
+			"/api/custom/backup/export",
+			backupExportHandler(app),
+		).Bind(apis.RequireAuth("users"))
+
+		se.Router.POST(
+			"/api/custom/backup/export",
+			backupExportHandler(app),
+		).Bind(apis.RequireAuth("users"))
+
+		// The default body limit is too small for larger backups; allow 1MB of multipart overhead
+		se.Router.POST(
+			"/api/custom/backup/import",
+			backupImportHandler(app),
+		).Bind(apis.RequireAuth("users"), apis.BodyLimit(maxBackupUploadSize+(1<<20)))
+
+		se.Router.GET(
+			"/api/custom/settings/secrets",
+			secretsListHandler(app),
+		).Bind(apis.RequireAuth("users"))
+
+		se.Router.POST(
+			"/api/custom/settings/secrets/{name}",
+			secretUpdateHandler(app),
+		).Bind(apis.RequireAuth("users"))
+
+		se.Router.DELETE(
+			"/api/custom/settings/secrets/{name}",
+			secretUpdateHandler(app),
+		).Bind(apis.RequireAuth("users"))
+
+		se.Router.GET(
+			"/api/custom/ai/usage",
+			aiUsageSummaryHandler(app),
+		).Bind(apis.RequireAuth("users"))
+
+		se.Router.POST(
+			"/api/custom/ai/prompt-preview",
+			aiPromptPreviewHandler(app),
+		).Bind(apis.RequireAuth("users"))
+
 		se.Router.POST(
 			"/api/custom/suggest-tags-for-bookmark",
 			suggestTagsForBookmarkHandler(app),
@@ -2898,11 +2900,143 @@ func main() {
 			addTagsBatchHandler(app),
 		).Bind(apis.RequireAuth("users"))
 
+		se.Router.POST(
+			"/api/custom/page-metadata",
+			pageMetadataHandler(app),
+		).Bind(apis.RequireAuth("users"))
+
 		se.Router.POST(
 			"/api/custom/get-favicon",
 			getFaviconHandler(app),
 		).Bind(apis.RequireAuth("users"))
 
+		se.Router.POST(
+			"/api/custom/bookmarks/refresh-favicons",
+			refreshFaviconsHandler(app),
+		).Bind(apis.RequireAuth("users"))
+
+		se.Router.GET(
+			"/api/custom/bookmarks/refresh-favicons/{jobId}",
+			refreshFaviconsStatusHandler(app),
+		).Bind(apis.RequireAuth("users"))
+
+		// 缓存的图标文件公开提供，<img> 请求无法携带认证头
+		se.Router.GET(
+			"/api/custom/favicons/{host}",
+			faviconFileHandler(app),
+		)
+
+		se.Router.GET(
+			"/api/custom/favicons/{host}/placeholder.svg",
+			placeholderIconHandler(app),
+		)
+
+		se.Router.GET(
+			"/api/custom/audit-logs",
+			auditLogsHandler(app),
+		).Bind(apis.RequireAuth("users"))
+
+		se.Router.GET(
+			"/api/custom/trash",
+			listTrashHandler(app),
+		).Bind(apis.RequireAuth("users"))
+
+		se.Router.POST(
+			"/api/custom/trash/restore",
+			restoreTrashHandler(app),
+		).Bind(apis.RequireAuth("users"))
+
+		se.Router.POST(
+			"/api/custom/trash/purge",
+			purgeTrashHandler(app),
+		).Bind(apis.RequireAuth("users"))
+
+		se.Router.GET(
+			"/api/custom/workspaces",
+			listWorkspacesHandler(app),
+		).Bind(apis.RequireAuth("users"))
+
+		se.Router.POST(
+			"/api/custom/workspaces",
+			createWorkspaceHandler(app),
+		).Bind(apis.RequireAuth("users"))
+
+		se.Router.DELETE(
+			"/api/custom/workspaces/{workspaceId}",
+			deleteWorkspaceHandler(app),
+		).Bind(apis.RequireAuth("users"))
+
+		se.Router.GET(
+			"/api/custom/workspaces/{workspaceId}/members",
+			listWorkspaceMembersHandler(app),
+		).Bind(apis.RequireAuth("users"))
+
+		se.Router.POST(
+			"/api/custom/workspaces/{workspaceId}/members",
+			setWorkspaceMemberHandler(app),
+		).Bind(apis.RequireAuth("users"))
+
+		se.Router.DELETE(
+			"/api/custom/workspaces/{workspaceId}/members/{userId}",
+			removeWorkspaceMemberHandler(app),
+		).Bind(apis.RequireAuth("users"))
+
+		se.Router.GET(
+			"/api/custom/shared-folders",
+			sharedWithMeHandler(app),
+		).Bind(apis.RequireAuth("users"))
+
+		se.Router.GET(
+			"/api/custom/folders/{folderId}/shares",
+			listFolderSharesHandler(app),
+		).Bind(apis.RequireAuth("users"))
+
+		se.Router.POST(
+			"/api/custom/folders/{folderId}/shares",
+			shareFolderHandler(app),
+		).Bind(apis.RequireAuth("users"))
+
+		se.Router.DELETE(
+			"/api/custom/folders/{folderId}/shares/{shareId}",
+			deleteFolderShareHandler(app),
+		).Bind(apis.RequireAuth("users"))
+
+		se.Router.GET(
+			"/api/custom/folders/{folderId}/links",
+			listFolderLinksHandler(app),
+		).Bind(apis.RequireAuth("users"))
+
+		se.Router.POST(
+			"/api/custom/folders/{folderId}/links",
+			createFolderLinkHandler(app),
+		).Bind(apis.RequireAuth("users"))
+
+		se.Router.DELETE(
+			"/api/custom/folders/{folderId}/links/{linkId}",
+			revokeFolderLinkHandler(app),
+		).Bind(apis.RequireAuth("users"))
+
+		// 公开链接无需登录，凭令牌（和可选的密码）访问
+		se.Router.GET(
+			"/api/custom/public/folder-links/{token}",
+			publicFolderLinkHandler(app),
+		)
+
+		se.Router.POST(
+			"/api/custom/public/folder-links/{token}",
+			publicFolderLinkHandler(app),
+		)
+
+		se.Router.GET(
+			"/api/custom/public/folder-links/{token}/html",
+			publicFolderLinkHTMLHandler(app),
+		)
+
+		se.Router.POST(
+			"/api/custom/public/folder-links/{token}/html",
+			publicFolderLinkHTMLHandler(app),
+		)
+
 		se.Router.POST(
 			"/api/custom/tags/batch-delete",
 			batchDeleteTagsHandler(app),
@@ -2914,6 +3048,21 @@ func main() {
 			aiSuggestAndSetTagsHandler(app),
 		).Bind(apis.RequireAuth("users"))
 
+		se.Router.POST(
+			"/api/custom/bookmarks/{bookmarkId}/ai-describe",
+			aiDescribeBookmarkHandler(app),
+		).Bind(apis.RequireAuth("users"))
+
+		se.Router.POST(
+			"/api/custom/bookmarks/ai-describe-missing",
+			aiDescribeMissingHandler(app),
+		).Bind(apis.RequireAuth("users"))
+
+		se.Router.POST(
+			"/api/custom/bookmarks/{bookmarkId}/preview-image",
+			bookmarkPreviewImageHandler(app),
+		).Bind(apis.RequireAuth("users"))
+
 		se.Router.POST(
 			"/api/custom/user-data/clear-all",
 			clearAllUserDataHandler(app),
@@ -0,0 +1,42 @@
+package migrations
+
+import (
+	"fmt"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		userSettingsCollection, err := app.FindCollectionByNameOrId("user_settings")
+		if err != nil {
+			return fmt.Errorf("failed to find user_settings collection: %w", err)
+		}
+
+		// 添加 backup_target 字段 (JSON) - 备份目标配置 (webdav / s3 / local / sftp)
+		userSettingsCollection.Fields.Add(&core.JSONField{
+			Name: "backup_target",
+		})
+
+		if err := app.Save(userSettingsCollection); err != nil {
+			return fmt.Errorf("failed to add backup_target field to user_settings collection: %w", err)
+		}
+
+		return nil
+	}, func(app core.App) error {
+		// --- Down migration ---
+		userSettingsCollection, err := app.FindCollectionByNameOrId("user_settings")
+		if err != nil {
+			return fmt.Errorf("failed to find user_settings collection for rollback: %w", err)
+		}
+
+		userSettingsCollection.Fields.RemoveByName("backup_target")
+
+		if err := app.Save(userSettingsCollection); err != nil {
+			return fmt.Errorf("failed to remove backup_target field from user_settings collection: %w", err)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,59 @@
+package migrations
+
+import (
+	"fmt"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		// --- ai_settings collection ---
+		// 实例级 AI 配置（共享的 API 密钥等），所有规则为 nil，仅超级管理员可访问。
+		// 只使用第一条记录；未设置的字段回退到 AI_* 环境变量。
+		aiSettingsCollection := core.NewBaseCollection("ai_settings")
+		aiSettingsCollection.Name = "ai_settings"
+
+		aiSettingsCollection.Fields.Add(&core.TextField{Name: "apiBaseUrl"})
+		aiSettingsCollection.Fields.Add(&core.TextField{Name: "apiKey"})
+		aiSettingsCollection.Fields.Add(&core.TextField{Name: "modelName"})
+		// 每个用户每天可使用共享密钥的请求数，0 表示不限制
+		aiSettingsCollection.Fields.Add(&core.NumberField{
+			Name:    "sharedDailyRequestLimit",
+			OnlyInt: true,
+			Min:     types.Pointer(0.0),
+		})
+		// 按用户覆盖的限制 {"<userId>": limit}
+		aiSettingsCollection.Fields.Add(&core.JSONField{Name: "userDailyRequestLimits"})
+		aiSettingsCollection.Fields.Add(&core.AutodateField{
+			Name:     "createdAt",
+			OnCreate: true,
+			OnUpdate: false,
+		})
+		aiSettingsCollection.Fields.Add(&core.AutodateField{
+			Name:     "updatedAt",
+			OnCreate: true,
+			OnUpdate: true,
+		})
+
+		if err := app.Save(aiSettingsCollection); err != nil {
+			return fmt.Errorf("failed to create ai_settings collection: %w", err)
+		}
+
+		return nil
+	}, func(app core.App) error {
+		// --- Down migration ---
+		aiSettingsCollection, err := app.FindCollectionByNameOrId("ai_settings")
+		if err != nil {
+			return nil // 集合不存在，无需回滚
+		}
+
+		if err := app.Delete(aiSettingsCollection); err != nil {
+			return fmt.Errorf("failed to delete ai_settings collection: %w", err)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,97 @@
+package migrations
+
+import (
+	"fmt"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		// --- ai_usage collection ---
+		// 每次 AI 调用的记录，只由服务端写入，用户只能查看自己的记录
+		aiUsageCollection := core.NewBaseCollection("ai_usage")
+		aiUsageCollection.Name = "ai_usage"
+		aiUsageCollection.ListRule = types.Pointer("@request.auth.id != \"\" && @request.auth.id = userId")
+		aiUsageCollection.ViewRule = types.Pointer("@request.auth.id != \"\" && @request.auth.id = userId")
+
+		aiUsageCollection.Fields.Add(&core.RelationField{
+			Name:          "userId",
+			Required:      true,
+			CollectionId:  "_pb_users_auth_",
+			CascadeDelete: true,
+			MinSelect:     1,
+			MaxSelect:     1,
+		})
+		aiUsageCollection.Fields.Add(&core.TextField{Name: "feature", Required: true})
+		aiUsageCollection.Fields.Add(&core.TextField{Name: "model"})
+		aiUsageCollection.Fields.Add(&core.NumberField{Name: "promptTokens", OnlyInt: true})
+		aiUsageCollection.Fields.Add(&core.NumberField{Name: "completionTokens", OnlyInt: true})
+		aiUsageCollection.Fields.Add(&core.NumberField{Name: "totalTokens", OnlyInt: true})
+		aiUsageCollection.Fields.Add(&core.NumberField{Name: "latencyMs", OnlyInt: true})
+		aiUsageCollection.Fields.Add(&core.BoolField{Name: "success"})
+		// 是否使用了实例共享密钥
+		aiUsageCollection.Fields.Add(&core.BoolField{Name: "shared"})
+		aiUsageCollection.Fields.Add(&core.TextField{Name: "error"})
+		aiUsageCollection.Fields.Add(&core.AutodateField{
+			Name:     "createdAt",
+			OnCreate: true,
+			OnUpdate: false,
+		})
+		aiUsageCollection.Indexes = []string{
+			"CREATE INDEX idx_ai_usage_userId_createdAt ON {{ai_usage}} (userId, createdAt)",
+		}
+
+		if err := app.Save(aiUsageCollection); err != nil {
+			return fmt.Errorf("failed to create ai_usage collection: %w", err)
+		}
+
+		// --- ai_settings: 所有调用（包括用户自己的密钥）的每日限额 ---
+		aiSettingsCollection, err := app.FindCollectionByNameOrId("ai_settings")
+		if err != nil {
+			return fmt.Errorf("failed to find ai_settings collection: %w", err)
+		}
+		// 每个用户每天的请求数和 token 数限制，0 表示不限制
+		aiSettingsCollection.Fields.Add(&core.NumberField{
+			Name:    "dailyRequestLimit",
+			OnlyInt: true,
+			Min:     types.Pointer(0.0),
+		})
+		aiSettingsCollection.Fields.Add(&core.NumberField{
+			Name:    "dailyTokenLimit",
+			OnlyInt: true,
+			Min:     types.Pointer(0.0),
+		})
+		// 按用户覆盖的限制 {"<userId>": {"requests": n, "tokens": n}}
+		aiSettingsCollection.Fields.Add(&core.JSONField{Name: "userDailyLimits"})
+
+		if err := app.Save(aiSettingsCollection); err != nil {
+			return fmt.Errorf("failed to add daily limit fields to ai_settings collection: %w", err)
+		}
+
+		return nil
+	}, func(app core.App) error {
+		// --- Down migration ---
+		if aiSettingsCollection, err := app.FindCollectionByNameOrId("ai_settings"); err == nil {
+			aiSettingsCollection.Fields.RemoveByName("dailyRequestLimit")
+			aiSettingsCollection.Fields.RemoveByName("dailyTokenLimit")
+			aiSettingsCollection.Fields.RemoveByName("userDailyLimits")
+			if err := app.Save(aiSettingsCollection); err != nil {
+				return fmt.Errorf("failed to remove daily limit fields from ai_settings collection: %w", err)
+			}
+		}
+
+		aiUsageCollection, err := app.FindCollectionByNameOrId("ai_usage")
+		if err != nil {
+			return nil // 集合不存在，无需回滚
+		}
+
+		if err := app.Delete(aiUsageCollection); err != nil {
+			return fmt.Errorf("failed to delete ai_usage collection: %w", err)
+		}
+
+		return nil
+	})
+}
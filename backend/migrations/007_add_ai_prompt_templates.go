@@ -0,0 +1,41 @@
+package migrations
+
+import (
+	"fmt"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		userSettingsCollection, err := app.FindCollectionByNameOrId("user_settings")
+		if err != nil {
+			return fmt.Errorf("failed to find user_settings collection: %w", err)
+		}
+
+		// 用户自定义的 AI 提示模板 {"folder": {"system": "...", "user": "..."}, "tags": {...}}
+		// 未设置的模板使用与 language 对应的默认模板
+		userSettingsCollection.Fields.Add(&core.JSONField{Name: "aiPromptTemplates"})
+
+		if err := app.Save(userSettingsCollection); err != nil {
+			return fmt.Errorf("failed to add aiPromptTemplates field to user_settings collection: %w", err)
+		}
+
+		return nil
+	}, func(app core.App) error {
+		// --- Down migration ---
+		userSettingsCollection, err := app.FindCollectionByNameOrId("user_settings")
+		if err != nil {
+			return fmt.Errorf("failed to find user_settings collection for rollback: %w", err)
+		}
+
+		userSettingsCollection.Fields.RemoveByName("aiPromptTemplates")
+
+		if err := app.Save(userSettingsCollection); err != nil {
+			return fmt.Errorf("failed to remove aiPromptTemplates field from user_settings collection: %w", err)
+		}
+
+		return nil
+	})
+}
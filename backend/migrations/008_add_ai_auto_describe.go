@@ -0,0 +1,40 @@
+package migrations
+
+import (
+	"fmt"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		userSettingsCollection, err := app.FindCollectionByNameOrId("user_settings")
+		if err != nil {
+			return fmt.Errorf("failed to find user_settings collection: %w", err)
+		}
+
+		// 新建书签时如果没有描述，是否自动用 AI 生成
+		userSettingsCollection.Fields.Add(&core.BoolField{Name: "aiAutoDescribe"})
+
+		if err := app.Save(userSettingsCollection); err != nil {
+			return fmt.Errorf("failed to add aiAutoDescribe field to user_settings collection: %w", err)
+		}
+
+		return nil
+	}, func(app core.App) error {
+		// --- Down migration ---
+		userSettingsCollection, err := app.FindCollectionByNameOrId("user_settings")
+		if err != nil {
+			return fmt.Errorf("failed to find user_settings collection for rollback: %w", err)
+		}
+
+		userSettingsCollection.Fields.RemoveByName("aiAutoDescribe")
+
+		if err := app.Save(userSettingsCollection); err != nil {
+			return fmt.Errorf("failed to remove aiAutoDescribe field from user_settings collection: %w", err)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,47 @@
+package migrations
+
+import (
+	"fmt"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		bookmarksCollection, err := app.FindCollectionByNameOrId("bookmarks")
+		if err != nil {
+			return fmt.Errorf("failed to find bookmarks collection: %w", err)
+		}
+
+		// 下载保存的预览图片，源站失效后仍可显示；img 字段继续保存原始图片 URL
+		// 缩略图通过 ?thumb=320x180 获取
+		bookmarksCollection.Fields.Add(&core.FileField{
+			Name:      "imgFile",
+			MaxSelect: 1,
+			MaxSize:   5 << 20,
+			MimeTypes: []string{"image/jpeg", "image/png", "image/gif", "image/webp"},
+			Thumbs:    []string{"320x180", "100x100"},
+		})
+
+		if err := app.Save(bookmarksCollection); err != nil {
+			return fmt.Errorf("failed to add imgFile field to bookmarks collection: %w", err)
+		}
+
+		return nil
+	}, func(app core.App) error {
+		// --- Down migration ---
+		bookmarksCollection, err := app.FindCollectionByNameOrId("bookmarks")
+		if err != nil {
+			return fmt.Errorf("failed to find bookmarks collection for rollback: %w", err)
+		}
+
+		bookmarksCollection.Fields.RemoveByName("imgFile")
+
+		if err := app.Save(bookmarksCollection); err != nil {
+			return fmt.Errorf("failed to remove imgFile field from bookmarks collection: %w", err)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,40 @@
+package migrations
+
+import (
+	"fmt"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		userSettingsCollection, err := app.FindCollectionByNameOrId("user_settings")
+		if err != nil {
+			return fmt.Errorf("failed to find user_settings collection: %w", err)
+		}
+
+		// 关闭备用抓取服务：直接请求页面失败时不把 URL 发送给管理员配置的备用服务
+		userSettingsCollection.Fields.Add(&core.BoolField{Name: "disableContentFetchFallback"})
+
+		if err := app.Save(userSettingsCollection); err != nil {
+			return fmt.Errorf("failed to add disableContentFetchFallback field to user_settings collection: %w", err)
+		}
+
+		return nil
+	}, func(app core.App) error {
+		// --- Down migration ---
+		userSettingsCollection, err := app.FindCollectionByNameOrId("user_settings")
+		if err != nil {
+			return fmt.Errorf("failed to find user_settings collection for rollback: %w", err)
+		}
+
+		userSettingsCollection.Fields.RemoveByName("disableContentFetchFallback")
+
+		if err := app.Save(userSettingsCollection); err != nil {
+			return fmt.Errorf("failed to remove disableContentFetchFallback field from user_settings collection: %w", err)
+		}
+
+		return nil
+	})
+}
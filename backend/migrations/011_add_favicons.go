@@ -0,0 +1,65 @@
+package migrations
+
+import (
+	"fmt"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		// --- favicons collection ---
+		// 按主机缓存的网站图标，由服务端抓取和写入，通过 /api/custom/favicons/{host} 提供，不开放集合接口
+		faviconsCollection := core.NewBaseCollection("favicons")
+		faviconsCollection.Name = "favicons"
+
+		// 主机名（小写，非默认端口时带端口）
+		faviconsCollection.Fields.Add(&core.TextField{Name: "host", Required: true, Max: 255})
+		faviconsCollection.Fields.Add(&core.FileField{
+			Name:      "file",
+			MaxSelect: 1,
+			MaxSize:   512 << 10,
+			MimeTypes: []string{
+				"image/png", "image/jpeg", "image/gif", "image/webp",
+				"image/x-icon", "image/vnd.microsoft.icon", "image/svg+xml",
+			},
+		})
+		// 图标的来源地址，可能是网站本身或配置的外部图标服务
+		faviconsCollection.Fields.Add(&core.TextField{Name: "sourceUrl"})
+		// 最近一次抓取失败；失败时保留之前的图标文件
+		faviconsCollection.Fields.Add(&core.BoolField{Name: "failed"})
+		faviconsCollection.Fields.Add(&core.DateField{Name: "fetchedAt"})
+		faviconsCollection.Fields.Add(&core.AutodateField{
+			Name:     "createdAt",
+			OnCreate: true,
+			OnUpdate: false,
+		})
+		faviconsCollection.Fields.Add(&core.AutodateField{
+			Name:     "updatedAt",
+			OnCreate: true,
+			OnUpdate: true,
+		})
+		faviconsCollection.Indexes = []string{
+			"CREATE UNIQUE INDEX idx_favicons_host ON {{favicons}} (host)",
+		}
+
+		if err := app.Save(faviconsCollection); err != nil {
+			return fmt.Errorf("failed to create favicons collection: %w", err)
+		}
+
+		return nil
+	}, func(app core.App) error {
+		// --- Down migration ---
+		faviconsCollection, err := app.FindCollectionByNameOrId("favicons")
+		if err != nil {
+			return nil // 集合不存在，无需回滚
+		}
+
+		if err := app.Delete(faviconsCollection); err != nil {
+			return fmt.Errorf("failed to delete favicons collection: %w", err)
+		}
+
+		return nil
+	})
+}
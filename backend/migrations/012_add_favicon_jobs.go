@@ -0,0 +1,83 @@
+package migrations
+
+import (
+	"fmt"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		// --- favicon_jobs collection ---
+		// 批量刷新书签图标的后台任务及进度，只由服务端写入；用户可以查看（或订阅）自己的任务
+		faviconJobsCollection := core.NewBaseCollection("favicon_jobs")
+		faviconJobsCollection.Name = "favicon_jobs"
+		faviconJobsCollection.ListRule = types.Pointer("@request.auth.id != \"\" && @request.auth.id = userId")
+		faviconJobsCollection.ViewRule = types.Pointer("@request.auth.id != \"\" && @request.auth.id = userId")
+
+		faviconJobsCollection.Fields.Add(&core.RelationField{
+			Name:          "userId",
+			Required:      true,
+			CollectionId:  "_pb_users_auth_",
+			CascadeDelete: true,
+			MinSelect:     1,
+			MaxSelect:     1,
+		})
+		faviconJobsCollection.Fields.Add(&core.SelectField{
+			Name:      "status",
+			Required:  true,
+			MaxSelect: 1,
+			Values:    []string{"running", "completed", "failed"},
+		})
+		// missing: 只处理没有图标的书签；all: 所有书签
+		faviconJobsCollection.Fields.Add(&core.SelectField{
+			Name:      "scope",
+			Required:  true,
+			MaxSelect: 1,
+			Values:    []string{"missing", "all"},
+		})
+		faviconJobsCollection.Fields.Add(&core.BoolField{Name: "force"})
+		faviconJobsCollection.Fields.Add(&core.NumberField{Name: "totalHosts", OnlyInt: true})
+		faviconJobsCollection.Fields.Add(&core.NumberField{Name: "processedHosts", OnlyInt: true})
+		faviconJobsCollection.Fields.Add(&core.NumberField{Name: "failedHosts", OnlyInt: true})
+		faviconJobsCollection.Fields.Add(&core.NumberField{Name: "totalBookmarks", OnlyInt: true})
+		faviconJobsCollection.Fields.Add(&core.NumberField{Name: "updatedBookmarks", OnlyInt: true})
+		// URL 无效（不是 http/https）而跳过的书签数
+		faviconJobsCollection.Fields.Add(&core.NumberField{Name: "skippedBookmarks", OnlyInt: true})
+		faviconJobsCollection.Fields.Add(&core.TextField{Name: "error"})
+		faviconJobsCollection.Fields.Add(&core.DateField{Name: "finishedAt"})
+		faviconJobsCollection.Fields.Add(&core.AutodateField{
+			Name:     "createdAt",
+			OnCreate: true,
+			OnUpdate: false,
+		})
+		faviconJobsCollection.Fields.Add(&core.AutodateField{
+			Name:     "updatedAt",
+			OnCreate: true,
+			OnUpdate: true,
+		})
+		faviconJobsCollection.Indexes = []string{
+			"CREATE INDEX idx_favicon_jobs_userId_status ON {{favicon_jobs}} (userId, status)",
+		}
+
+		if err := app.Save(faviconJobsCollection); err != nil {
+			return fmt.Errorf("failed to create favicon_jobs collection: %w", err)
+		}
+
+		return nil
+	}, func(app core.App) error {
+		// --- Down migration ---
+		faviconJobsCollection, err := app.FindCollectionByNameOrId("favicon_jobs")
+		if err != nil {
+			return nil // 集合不存在，无需回滚
+		}
+
+		if err := app.Delete(faviconJobsCollection); err != nil {
+			return fmt.Errorf("failed to delete favicon_jobs collection: %w", err)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,208 @@
+package migrations
+
+import (
+	"fmt"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// 共享文件夹的访问条件：folder_access 中有当前用户对该文件夹的记录（编辑时还要求 editor 角色）
+const (
+	folderAccessRule = `(@collection.folder_access:access.folderId ?= %s && @collection.folder_access:access.userId ?= @request.auth.id)`
+	folderEditRule   = `(@collection.folder_access:access.folderId ?= %s && @collection.folder_access:access.userId ?= @request.auth.id && @collection.folder_access:access.role ?= "editor")`
+	// 共享的根文件夹只有所有者可以删除
+	folderEditNonRootRule = `(@collection.folder_access:access.folderId ?= %s && @collection.folder_access:access.userId ?= @request.auth.id && @collection.folder_access:access.role ?= "editor" && @collection.folder_access:access.isRoot ?= false)`
+	// 为自己新建记录；请求中可以不带 userId，由新建钩子设置为当前用户
+	ownRecordCreateRule = `(@request.body.userId:isset = false || @request.body.userId = @request.auth.id)`
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		foldersCollection, err := app.FindCollectionByNameOrId("folders")
+		if err != nil {
+			return fmt.Errorf("failed to find folders collection: %w", err)
+		}
+
+		// --- folder_shares collection ---
+		// 所有者把文件夹（及其子文件夹）共享给其他用户，只通过自定义接口写入
+		folderSharesCollection := core.NewBaseCollection("folder_shares")
+		folderSharesCollection.Name = "folder_shares"
+		folderSharesCollection.ListRule = types.Pointer("@request.auth.id != \"\" && (@request.auth.id = ownerId || @request.auth.id = userId)")
+		folderSharesCollection.ViewRule = types.Pointer("@request.auth.id != \"\" && (@request.auth.id = ownerId || @request.auth.id = userId)")
+
+		folderSharesCollection.Fields.Add(&core.RelationField{
+			Name:          "folderId",
+			Required:      true,
+			CollectionId:  foldersCollection.Id,
+			CascadeDelete: true,
+			MinSelect:     1,
+			MaxSelect:     1,
+		})
+		folderSharesCollection.Fields.Add(&core.RelationField{
+			Name:          "ownerId",
+			Required:      true,
+			CollectionId:  "_pb_users_auth_",
+			CascadeDelete: true,
+			MinSelect:     1,
+			MaxSelect:     1,
+		})
+		// 被共享的用户
+		folderSharesCollection.Fields.Add(&core.RelationField{
+			Name:          "userId",
+			Required:      true,
+			CollectionId:  "_pb_users_auth_",
+			CascadeDelete: true,
+			MinSelect:     1,
+			MaxSelect:     1,
+		})
+		folderSharesCollection.Fields.Add(&core.SelectField{
+			Name:      "role",
+			Required:  true,
+			MaxSelect: 1,
+			Values:    []string{"viewer", "editor"},
+		})
+		folderSharesCollection.Fields.Add(&core.AutodateField{
+			Name:     "createdAt",
+			OnCreate: true,
+			OnUpdate: false,
+		})
+		folderSharesCollection.Fields.Add(&core.AutodateField{
+			Name:     "updatedAt",
+			OnCreate: true,
+			OnUpdate: true,
+		})
+		folderSharesCollection.Indexes = []string{
+			"CREATE UNIQUE INDEX idx_folder_shares_folderId_userId ON {{folder_shares}} (folderId, userId)",
+			"CREATE INDEX idx_folder_shares_ownerId ON {{folder_shares}} (ownerId)",
+			"CREATE INDEX idx_folder_shares_userId ON {{folder_shares}} (userId)",
+		}
+
+		if err := app.Save(folderSharesCollection); err != nil {
+			return fmt.Errorf("failed to create folder_shares collection: %w", err)
+		}
+
+		// --- folder_access collection ---
+		// 由 folder_shares 展开的子树访问权限，每个用户对每个文件夹一条，由服务端维护，供集合规则使用
+		folderAccessCollection := core.NewBaseCollection("folder_access")
+		folderAccessCollection.Name = "folder_access"
+		folderAccessCollection.ListRule = types.Pointer("@request.auth.id != \"\" && @request.auth.id = userId")
+		folderAccessCollection.ViewRule = types.Pointer("@request.auth.id != \"\" && @request.auth.id = userId")
+
+		folderAccessCollection.Fields.Add(&core.RelationField{
+			Name:          "folderId",
+			Required:      true,
+			CollectionId:  foldersCollection.Id,
+			CascadeDelete: true,
+			MinSelect:     1,
+			MaxSelect:     1,
+		})
+		folderAccessCollection.Fields.Add(&core.RelationField{
+			Name:          "ownerId",
+			Required:      true,
+			CollectionId:  "_pb_users_auth_",
+			CascadeDelete: true,
+			MinSelect:     1,
+			MaxSelect:     1,
+		})
+		folderAccessCollection.Fields.Add(&core.RelationField{
+			Name:          "userId",
+			Required:      true,
+			CollectionId:  "_pb_users_auth_",
+			CascadeDelete: true,
+			MinSelect:     1,
+			MaxSelect:     1,
+		})
+		folderAccessCollection.Fields.Add(&core.RelationField{
+			Name:          "shareId",
+			Required:      true,
+			CollectionId:  folderSharesCollection.Id,
+			CascadeDelete: true,
+			MinSelect:     1,
+			MaxSelect:     1,
+		})
+		folderAccessCollection.Fields.Add(&core.SelectField{
+			Name:      "role",
+			Required:  true,
+			MaxSelect: 1,
+			Values:    []string{"viewer", "editor"},
+		})
+		// 该用户可访问的最上层文件夹（父文件夹不可访问）
+		folderAccessCollection.Fields.Add(&core.BoolField{Name: "isRoot"})
+		folderAccessCollection.Fields.Add(&core.AutodateField{
+			Name:     "createdAt",
+			OnCreate: true,
+			OnUpdate: false,
+		})
+		folderAccessCollection.Fields.Add(&core.AutodateField{
+			Name:     "updatedAt",
+			OnCreate: true,
+			OnUpdate: true,
+		})
+		folderAccessCollection.Indexes = []string{
+			"CREATE UNIQUE INDEX idx_folder_access_folderId_userId ON {{folder_access}} (folderId, userId)",
+			"CREATE INDEX idx_folder_access_ownerId ON {{folder_access}} (ownerId)",
+			"CREATE INDEX idx_folder_access_userId ON {{folder_access}} (userId)",
+		}
+
+		if err := app.Save(folderAccessCollection); err != nil {
+			return fmt.Errorf("failed to create folder_access collection: %w", err)
+		}
+
+		// --- folders / bookmarks: 所有者之外，共享用户按角色访问 ---
+		// 新建记录时 userId 必须是自己，或者在有 editor 权限的共享文件夹中（由钩子改为文件夹所有者）
+		foldersCollection.ListRule = types.Pointer("@request.auth.id != \"\" && (@request.auth.id = userId || " + fmt.Sprintf(folderAccessRule, "id") + ")")
+		foldersCollection.ViewRule = types.Pointer("@request.auth.id != \"\" && (@request.auth.id = userId || " + fmt.Sprintf(folderAccessRule, "id") + ")")
+		foldersCollection.CreateRule = types.Pointer("@request.auth.id != \"\" && (" + ownRecordCreateRule + " || " + fmt.Sprintf(folderEditRule, "@request.body.parentId") + ")")
+		foldersCollection.UpdateRule = types.Pointer("@request.auth.id != \"\" && (@request.auth.id = userId || " + fmt.Sprintf(folderEditRule, "id") + ")")
+		foldersCollection.DeleteRule = types.Pointer("@request.auth.id != \"\" && (@request.auth.id = userId || " + fmt.Sprintf(folderEditNonRootRule, "id") + ")")
+		if err := app.Save(foldersCollection); err != nil {
+			return fmt.Errorf("failed to update folders collection rules: %w", err)
+		}
+
+		bookmarksCollection, err := app.FindCollectionByNameOrId("bookmarks")
+		if err != nil {
+			return fmt.Errorf("failed to find bookmarks collection: %w", err)
+		}
+		bookmarksCollection.ListRule = types.Pointer("@request.auth.id != \"\" && (@request.auth.id = userId || " + fmt.Sprintf(folderAccessRule, "folderId") + ")")
+		bookmarksCollection.ViewRule = types.Pointer("@request.auth.id != \"\" && (@request.auth.id = userId || " + fmt.Sprintf(folderAccessRule, "folderId") + ")")
+		bookmarksCollection.CreateRule = types.Pointer("@request.auth.id != \"\" && (" + ownRecordCreateRule + " || " + fmt.Sprintf(folderEditRule, "@request.body.folderId") + ")")
+		bookmarksCollection.UpdateRule = types.Pointer("@request.auth.id != \"\" && (@request.auth.id = userId || " + fmt.Sprintf(folderEditRule, "folderId") + ")")
+		bookmarksCollection.DeleteRule = types.Pointer("@request.auth.id != \"\" && (@request.auth.id = userId || " + fmt.Sprintf(folderEditRule, "folderId") + ")")
+		if err := app.Save(bookmarksCollection); err != nil {
+			return fmt.Errorf("failed to update bookmarks collection rules: %w", err)
+		}
+
+		return nil
+	}, func(app core.App) error {
+		// --- Down migration ---
+		ownerRule := types.Pointer("@request.auth.id != \"\" && @request.auth.id = userId")
+		for _, name := range []string{"folders", "bookmarks"} {
+			collection, err := app.FindCollectionByNameOrId(name)
+			if err != nil {
+				continue
+			}
+			collection.ListRule = ownerRule
+			collection.ViewRule = ownerRule
+			collection.CreateRule = types.Pointer("@request.auth.id != \"\"")
+			collection.UpdateRule = ownerRule
+			collection.DeleteRule = ownerRule
+			if err := app.Save(collection); err != nil {
+				return fmt.Errorf("failed to restore %s collection rules: %w", name, err)
+			}
+		}
+
+		for _, name := range []string{"folder_access", "folder_shares"} {
+			collection, err := app.FindCollectionByNameOrId(name)
+			if err != nil {
+				continue // 集合不存在，无需回滚
+			}
+			if err := app.Delete(collection); err != nil {
+				return fmt.Errorf("failed to delete %s collection: %w", name, err)
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,88 @@
+package migrations
+
+import (
+	"fmt"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		foldersCollection, err := app.FindCollectionByNameOrId("folders")
+		if err != nil {
+			return fmt.Errorf("failed to find folders collection: %w", err)
+		}
+
+		// --- folder_links collection ---
+		// 文件夹的公开只读链接，持有令牌的人无需登录即可查看；只通过自定义接口写入，所有者可以查看自己的链接
+		folderLinksCollection := core.NewBaseCollection("folder_links")
+		folderLinksCollection.Name = "folder_links"
+		folderLinksCollection.ListRule = types.Pointer("@request.auth.id != \"\" && @request.auth.id = userId")
+		folderLinksCollection.ViewRule = types.Pointer("@request.auth.id != \"\" && @request.auth.id = userId")
+
+		folderLinksCollection.Fields.Add(&core.RelationField{
+			Name:          "folderId",
+			Required:      true,
+			CollectionId:  foldersCollection.Id,
+			CascadeDelete: true,
+			MinSelect:     1,
+			MaxSelect:     1,
+		})
+		folderLinksCollection.Fields.Add(&core.RelationField{
+			Name:          "userId",
+			Required:      true,
+			CollectionId:  "_pb_users_auth_",
+			CascadeDelete: true,
+			MinSelect:     1,
+			MaxSelect:     1,
+		})
+		folderLinksCollection.Fields.Add(&core.TextField{
+			Name:     "token",
+			Required: true,
+			Max:      100,
+		})
+		// bcrypt 哈希，为空表示不需要密码
+		folderLinksCollection.Fields.Add(&core.TextField{
+			Name:   "passwordHash",
+			Hidden: true,
+		})
+		// 为空表示永不过期
+		folderLinksCollection.Fields.Add(&core.DateField{Name: "expiresAt"})
+		folderLinksCollection.Fields.Add(&core.NumberField{Name: "viewCount", OnlyInt: true})
+		folderLinksCollection.Fields.Add(&core.DateField{Name: "lastViewedAt"})
+		folderLinksCollection.Fields.Add(&core.AutodateField{
+			Name:     "createdAt",
+			OnCreate: true,
+			OnUpdate: false,
+		})
+		folderLinksCollection.Fields.Add(&core.AutodateField{
+			Name:     "updatedAt",
+			OnCreate: true,
+			OnUpdate: true,
+		})
+		folderLinksCollection.Indexes = []string{
+			"CREATE UNIQUE INDEX idx_folder_links_token ON {{folder_links}} (token)",
+			"CREATE INDEX idx_folder_links_folderId ON {{folder_links}} (folderId)",
+		}
+
+		if err := app.Save(folderLinksCollection); err != nil {
+			return fmt.Errorf("failed to create folder_links collection: %w", err)
+		}
+
+		return nil
+	}, func(app core.App) error {
+		// --- Down migration ---
+		folderLinksCollection, err := app.FindCollectionByNameOrId("folder_links")
+		if err != nil {
+			return nil // 集合不存在，无需回滚
+		}
+
+		if err := app.Delete(folderLinksCollection); err != nil {
+			return fmt.Errorf("failed to delete folder_links collection: %w", err)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,210 @@
+package migrations
+
+import (
+	"fmt"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// 工作区的访问条件：workspace_members 中有当前用户的记录（编辑时还要求角色不是 viewer）
+const (
+	workspaceMemberRule = `(@collection.workspace_members:member.workspaceId ?= %s && @collection.workspace_members:member.userId ?= @request.auth.id)`
+	workspaceEditRule   = `(@collection.workspace_members:member.workspaceId ?= %s && @collection.workspace_members:member.userId ?= @request.auth.id && @collection.workspace_members:member.role ?!= "viewer")`
+	workspaceManageRule = `(@collection.workspace_members:member.workspaceId ?= %s && @collection.workspace_members:member.userId ?= @request.auth.id && (@collection.workspace_members:member.role ?= "owner" || @collection.workspace_members:member.role ?= "admin"))`
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		// --- workspaces collection ---
+		// 团队工作区，拥有自己的文件夹、书签和标签列表；创建、删除和成员管理通过自定义接口
+		workspacesCollection := core.NewBaseCollection("workspaces")
+		workspacesCollection.Name = "workspaces"
+
+		workspacesCollection.Fields.Add(&core.TextField{
+			Name:     "name",
+			Required: true,
+			Max:      100,
+		})
+		workspacesCollection.Fields.Add(&core.RelationField{
+			Name:          "ownerId",
+			Required:      true,
+			CollectionId:  "_pb_users_auth_",
+			CascadeDelete: true,
+			MinSelect:     1,
+			MaxSelect:     1,
+		})
+		workspacesCollection.Fields.Add(&core.JSONField{Name: "tagList"})
+		workspacesCollection.Fields.Add(&core.AutodateField{
+			Name:     "createdAt",
+			OnCreate: true,
+			OnUpdate: false,
+		})
+		workspacesCollection.Fields.Add(&core.AutodateField{
+			Name:     "updatedAt",
+			OnCreate: true,
+			OnUpdate: true,
+		})
+
+		if err := app.Save(workspacesCollection); err != nil {
+			return fmt.Errorf("failed to create workspaces collection: %w", err)
+		}
+
+		// --- workspace_members collection ---
+		workspaceMembersCollection := core.NewBaseCollection("workspace_members")
+		workspaceMembersCollection.Name = "workspace_members"
+		workspaceMembersCollection.ListRule = types.Pointer("@request.auth.id != \"\" && " + fmt.Sprintf(workspaceMemberRule, "workspaceId"))
+		workspaceMembersCollection.ViewRule = types.Pointer("@request.auth.id != \"\" && " + fmt.Sprintf(workspaceMemberRule, "workspaceId"))
+
+		workspaceMembersCollection.Fields.Add(&core.RelationField{
+			Name:          "workspaceId",
+			Required:      true,
+			CollectionId:  workspacesCollection.Id,
+			CascadeDelete: true,
+			MinSelect:     1,
+			MaxSelect:     1,
+		})
+		workspaceMembersCollection.Fields.Add(&core.RelationField{
+			Name:          "userId",
+			Required:      true,
+			CollectionId:  "_pb_users_auth_",
+			CascadeDelete: true,
+			MinSelect:     1,
+			MaxSelect:     1,
+		})
+		workspaceMembersCollection.Fields.Add(&core.SelectField{
+			Name:      "role",
+			Required:  true,
+			MaxSelect: 1,
+			Values:    []string{"owner", "admin", "editor", "viewer"},
+		})
+		workspaceMembersCollection.Fields.Add(&core.AutodateField{
+			Name:     "createdAt",
+			OnCreate: true,
+			OnUpdate: false,
+		})
+		workspaceMembersCollection.Fields.Add(&core.AutodateField{
+			Name:     "updatedAt",
+			OnCreate: true,
+			OnUpdate: true,
+		})
+		workspaceMembersCollection.Indexes = []string{
+			"CREATE UNIQUE INDEX idx_workspace_members_workspaceId_userId ON {{workspace_members}} (workspaceId, userId)",
+			"CREATE INDEX idx_workspace_members_userId ON {{workspace_members}} (userId)",
+		}
+
+		if err := app.Save(workspaceMembersCollection); err != nil {
+			return fmt.Errorf("failed to create workspace_members collection: %w", err)
+		}
+
+		// 成员可以查看工作区，owner/admin 可以修改名称和标签列表
+		workspacesCollection.ListRule = types.Pointer("@request.auth.id != \"\" && " + fmt.Sprintf(workspaceMemberRule, "id"))
+		workspacesCollection.ViewRule = types.Pointer("@request.auth.id != \"\" && " + fmt.Sprintf(workspaceMemberRule, "id"))
+		workspacesCollection.UpdateRule = types.Pointer("@request.auth.id != \"\" && " + fmt.Sprintf(workspaceManageRule, "id") + " && (@request.body.ownerId:isset = false || @request.body.ownerId = ownerId)")
+		if err := app.Save(workspacesCollection); err != nil {
+			return fmt.Errorf("failed to update workspaces collection rules: %w", err)
+		}
+
+		// --- folders / bookmarks: 工作区中的记录 ---
+		// workspaceId 为空的记录属于个人空间（userId），否则属于工作区，由成员角色控制访问；userId 记录创建者
+		personalFolderRead := "@request.auth.id = userId || " + fmt.Sprintf(folderAccessRule, "id")
+		personalFolderCreate := ownRecordCreateRule + " || " + fmt.Sprintf(folderEditRule, "@request.body.parentId")
+		personalFolderUpdate := "@request.auth.id = userId || " + fmt.Sprintf(folderEditRule, "id")
+		personalFolderDelete := "@request.auth.id = userId || " + fmt.Sprintf(folderEditNonRootRule, "id")
+		personalBookmarkRead := "@request.auth.id = userId || " + fmt.Sprintf(folderAccessRule, "folderId")
+		personalBookmarkCreate := ownRecordCreateRule + " || " + fmt.Sprintf(folderEditRule, "@request.body.folderId")
+		personalBookmarkWrite := "@request.auth.id = userId || " + fmt.Sprintf(folderEditRule, "folderId")
+
+		spaceReadRule := func(personal string) *string {
+			return types.Pointer("@request.auth.id != \"\" && ((workspaceId = \"\" && (" + personal + ")) || " + fmt.Sprintf(workspaceMemberRule, "workspaceId") + ")")
+		}
+		spaceWriteRule := func(personal string) *string {
+			return types.Pointer("@request.auth.id != \"\" && ((workspaceId = \"\" && (" + personal + ")) || " + fmt.Sprintf(workspaceEditRule, "workspaceId") + ")")
+		}
+		spaceCreateRule := func(personal string) *string {
+			return types.Pointer("@request.auth.id != \"\" && (((@request.body.workspaceId:isset = false || @request.body.workspaceId = \"\") && (" + personal + ")) || " + fmt.Sprintf(workspaceEditRule, "@request.body.workspaceId") + ")")
+		}
+
+		foldersCollection, err := app.FindCollectionByNameOrId("folders")
+		if err != nil {
+			return fmt.Errorf("failed to find folders collection: %w", err)
+		}
+		foldersCollection.Fields.Add(&core.RelationField{
+			Name:          "workspaceId",
+			CollectionId:  workspacesCollection.Id,
+			CascadeDelete: true,
+			MaxSelect:     1,
+		})
+		foldersCollection.AddIndex("idx_folders_workspaceId", false, "workspaceId", "")
+		foldersCollection.ListRule = spaceReadRule(personalFolderRead)
+		foldersCollection.ViewRule = spaceReadRule(personalFolderRead)
+		foldersCollection.CreateRule = spaceCreateRule(personalFolderCreate)
+		foldersCollection.UpdateRule = spaceWriteRule(personalFolderUpdate)
+		foldersCollection.DeleteRule = spaceWriteRule(personalFolderDelete)
+		if err := app.Save(foldersCollection); err != nil {
+			return fmt.Errorf("failed to update folders collection: %w", err)
+		}
+
+		bookmarksCollection, err := app.FindCollectionByNameOrId("bookmarks")
+		if err != nil {
+			return fmt.Errorf("failed to find bookmarks collection: %w", err)
+		}
+		bookmarksCollection.Fields.Add(&core.RelationField{
+			Name:          "workspaceId",
+			CollectionId:  workspacesCollection.Id,
+			CascadeDelete: true,
+			MaxSelect:     1,
+		})
+		bookmarksCollection.AddIndex("idx_bookmarks_workspaceId", false, "workspaceId", "")
+		bookmarksCollection.ListRule = spaceReadRule(personalBookmarkRead)
+		bookmarksCollection.ViewRule = spaceReadRule(personalBookmarkRead)
+		bookmarksCollection.CreateRule = spaceCreateRule(personalBookmarkCreate)
+		bookmarksCollection.UpdateRule = spaceWriteRule(personalBookmarkWrite)
+		bookmarksCollection.DeleteRule = spaceWriteRule(personalBookmarkWrite)
+		if err := app.Save(bookmarksCollection); err != nil {
+			return fmt.Errorf("failed to update bookmarks collection: %w", err)
+		}
+
+		return nil
+	}, func(app core.App) error {
+		// --- Down migration ---
+		// 恢复 013 中的共享文件夹规则，删除工作区字段和集合（工作区中的记录随之删除）
+		for _, name := range []string{"folders", "bookmarks"} {
+			collection, err := app.FindCollectionByNameOrId(name)
+			if err != nil {
+				continue
+			}
+			// 文件夹按自身 id 判断访问，书签按所在文件夹
+			readTarget, parentField, deleteRule := "id", "parentId", folderEditNonRootRule
+			if name == "bookmarks" {
+				readTarget, parentField, deleteRule = "folderId", "folderId", folderEditRule
+			}
+			collection.ListRule = types.Pointer("@request.auth.id != \"\" && (@request.auth.id = userId || " + fmt.Sprintf(folderAccessRule, readTarget) + ")")
+			collection.ViewRule = collection.ListRule
+			collection.CreateRule = types.Pointer("@request.auth.id != \"\" && (" + ownRecordCreateRule + " || " + fmt.Sprintf(folderEditRule, "@request.body."+parentField) + ")")
+			collection.UpdateRule = types.Pointer("@request.auth.id != \"\" && (@request.auth.id = userId || " + fmt.Sprintf(folderEditRule, readTarget) + ")")
+			collection.DeleteRule = types.Pointer("@request.auth.id != \"\" && (@request.auth.id = userId || " + fmt.Sprintf(deleteRule, readTarget) + ")")
+			if _, err := app.DB().NewQuery("DELETE FROM {{" + name + "}} WHERE [[workspaceId]] != ''").Execute(); err != nil {
+				return fmt.Errorf("failed to delete workspace %s: %w", name, err)
+			}
+			collection.RemoveIndex("idx_" + name + "_workspaceId")
+			collection.Fields.RemoveByName("workspaceId")
+			if err := app.Save(collection); err != nil {
+				return fmt.Errorf("failed to restore %s collection: %w", name, err)
+			}
+		}
+
+		for _, name := range []string{"workspace_members", "workspaces"} {
+			collection, err := app.FindCollectionByNameOrId(name)
+			if err != nil {
+				continue // 集合不存在，无需回滚
+			}
+			if err := app.Delete(collection); err != nil {
+				return fmt.Errorf("failed to delete %s collection: %w", name, err)
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,88 @@
+package migrations
+
+import (
+	"fmt"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		// --- audit_logs collection ---
+		// bookmarks、folders 和 user_settings 的新增、修改和删除记录，只由服务端写入。
+		// 个人空间的记录只有所有者可以查看，工作区的记录由工作区的 owner/admin 查看
+		auditLogsCollection := core.NewBaseCollection("audit_logs")
+		auditLogsCollection.Name = "audit_logs"
+		auditLogsRule := "@request.auth.id != \"\" && ((workspaceId = \"\" && @request.auth.id = userId) || (workspaceId != \"\" && " + fmt.Sprintf(workspaceManageRule, "workspaceId") + "))"
+		auditLogsCollection.ListRule = types.Pointer(auditLogsRule)
+		auditLogsCollection.ViewRule = types.Pointer(auditLogsRule)
+
+		// 被修改记录的所有者
+		auditLogsCollection.Fields.Add(&core.RelationField{
+			Name:          "userId",
+			Required:      true,
+			CollectionId:  "_pb_users_auth_",
+			CascadeDelete: true,
+			MinSelect:     1,
+			MaxSelect:     1,
+		})
+		// 工作区被删除后日志仍然保留到过期，因此不使用关联字段
+		auditLogsCollection.Fields.Add(&core.TextField{Name: "workspaceId"})
+		// 执行修改的用户（可能是共享文件夹的编辑者或管理员），后台任务为空
+		auditLogsCollection.Fields.Add(&core.TextField{Name: "actorId"})
+		auditLogsCollection.Fields.Add(&core.SelectField{
+			Name:      "collection",
+			Required:  true,
+			MaxSelect: 1,
+			Values:    []string{"bookmarks", "folders", "user_settings"},
+		})
+		auditLogsCollection.Fields.Add(&core.TextField{Name: "recordId", Required: true})
+		auditLogsCollection.Fields.Add(&core.SelectField{
+			Name:      "action",
+			Required:  true,
+			MaxSelect: 1,
+			Values:    []string{"create", "update", "delete"},
+		})
+		auditLogsCollection.Fields.Add(&core.SelectField{
+			Name:      "source",
+			Required:  true,
+			MaxSelect: 1,
+			Values:    []string{"api", "extension", "admin", "restore", "ai", "tags", "system"},
+		})
+		// 级联删除时引起删除的记录，如 "folders/<id>"
+		auditLogsCollection.Fields.Add(&core.TextField{Name: "cascadeFrom"})
+		// {"<字段>": {"before": ..., "after": ...}}，密钥字段只记录掩码
+		auditLogsCollection.Fields.Add(&core.JSONField{Name: "changes"})
+		auditLogsCollection.Fields.Add(&core.AutodateField{
+			Name:     "createdAt",
+			OnCreate: true,
+			OnUpdate: false,
+		})
+		auditLogsCollection.Indexes = []string{
+			"CREATE INDEX idx_audit_logs_userId_createdAt ON {{audit_logs}} (userId, createdAt)",
+			"CREATE INDEX idx_audit_logs_workspaceId_createdAt ON {{audit_logs}} (workspaceId, createdAt)",
+			"CREATE INDEX idx_audit_logs_recordId ON {{audit_logs}} (recordId)",
+			"CREATE INDEX idx_audit_logs_createdAt ON {{audit_logs}} (createdAt)",
+		}
+
+		if err := app.Save(auditLogsCollection); err != nil {
+			return fmt.Errorf("failed to create audit_logs collection: %w", err)
+		}
+
+		return nil
+	}, func(app core.App) error {
+		// --- Down migration ---
+		auditLogsCollection, err := app.FindCollectionByNameOrId("audit_logs")
+		if err != nil {
+			return nil // 集合不存在，无需回滚
+		}
+
+		if err := app.Delete(auditLogsCollection); err != nil {
+			return fmt.Errorf("failed to delete audit_logs collection: %w", err)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,69 @@
+package migrations
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// 回收站中的记录对记录接口不可见，也不能修改或再次删除；deletedAt 只由服务端设置
+const (
+	trashHiddenRule  = ` && deletedAt = ""`
+	trashNoClientSet = ` && @request.body.deletedAt:isset = false`
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		// --- folders / bookmarks: 回收站 ---
+		// deletedAt 非空表示记录在回收站中；删除文件夹时子文件夹使用相同的 deletedAt
+		for _, name := range []string{"folders", "bookmarks"} {
+			collection, err := app.FindCollectionByNameOrId(name)
+			if err != nil {
+				return fmt.Errorf("failed to find %s collection: %w", name, err)
+			}
+			collection.Fields.Add(&core.DateField{Name: "deletedAt"})
+			collection.AddIndex("idx_"+name+"_deletedAt", false, "deletedAt", "")
+
+			collection.ListRule = types.Pointer(*collection.ListRule + trashHiddenRule)
+			collection.ViewRule = types.Pointer(*collection.ViewRule + trashHiddenRule)
+			collection.CreateRule = types.Pointer(*collection.CreateRule + trashNoClientSet)
+			collection.UpdateRule = types.Pointer(*collection.UpdateRule + trashHiddenRule + trashNoClientSet)
+			collection.DeleteRule = types.Pointer(*collection.DeleteRule + trashHiddenRule)
+
+			if err := app.Save(collection); err != nil {
+				return fmt.Errorf("failed to add deletedAt to %s collection: %w", name, err)
+			}
+		}
+
+		return nil
+	}, func(app core.App) error {
+		// --- Down migration ---
+		// 回收站中的记录无法再恢复，直接删除
+		for _, name := range []string{"folders", "bookmarks"} {
+			collection, err := app.FindCollectionByNameOrId(name)
+			if err != nil {
+				continue // 集合不存在，无需回滚
+			}
+			if _, err := app.DB().NewQuery("DELETE FROM {{" + name + "}} WHERE [[deletedAt]] != ''").Execute(); err != nil {
+				return fmt.Errorf("failed to delete trashed %s: %w", name, err)
+			}
+
+			collection.ListRule = types.Pointer(strings.TrimSuffix(*collection.ListRule, trashHiddenRule))
+			collection.ViewRule = types.Pointer(strings.TrimSuffix(*collection.ViewRule, trashHiddenRule))
+			collection.CreateRule = types.Pointer(strings.TrimSuffix(*collection.CreateRule, trashNoClientSet))
+			collection.UpdateRule = types.Pointer(strings.TrimSuffix(*collection.UpdateRule, trashHiddenRule+trashNoClientSet))
+			collection.DeleteRule = types.Pointer(strings.TrimSuffix(*collection.DeleteRule, trashHiddenRule))
+			collection.RemoveIndex("idx_" + name + "_deletedAt")
+			collection.Fields.RemoveByName("deletedAt")
+
+			if err := app.Save(collection); err != nil {
+				return fmt.Errorf("failed to remove deletedAt from %s collection: %w", name, err)
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,40 @@
+package migrations
+
+import (
+	"fmt"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		bookmarksCollection, err := app.FindCollectionByNameOrId("bookmarks")
+		if err != nil {
+			return fmt.Errorf("failed to find bookmarks collection: %w", err)
+		}
+
+		// 批量生成描述失败的时间：之后的批量任务跳过这些书签，修改 URL 后清空
+		bookmarksCollection.Fields.Add(&core.DateField{Name: "descriptionFailedAt"})
+
+		if err := app.Save(bookmarksCollection); err != nil {
+			return fmt.Errorf("failed to add descriptionFailedAt field to bookmarks collection: %w", err)
+		}
+
+		return nil
+	}, func(app core.App) error {
+		// --- Down migration ---
+		bookmarksCollection, err := app.FindCollectionByNameOrId("bookmarks")
+		if err != nil {
+			return fmt.Errorf("failed to find bookmarks collection for rollback: %w", err)
+		}
+
+		bookmarksCollection.Fields.RemoveByName("descriptionFailedAt")
+
+		if err := app.Save(bookmarksCollection); err != nil {
+			return fmt.Errorf("failed to remove descriptionFailedAt field from bookmarks collection: %w", err)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+// 页面元数据提取的辅助函数：字符集解码、<meta> 标签映射、JSON-LD 文章数据以及页面图标。
+// extractMetadata（main.go）遍历 HTML 时调用这些函数，finalizePageMetadata 在遍历结束后解析相对地址并补全字段。
+
+// maxPageHTMLSize limits the HTML read from a page.
+const maxPageHTMLSize = 5 << 20
+
+// PageIcon is an icon declared by a page with <link rel="icon"> and similar.
+type PageIcon struct {
+	Href  string `json:"href"`
+	Rel   string `json:"rel"`
+	Sizes string `json:"sizes,omitempty"`
+	Type  string `json:"type,omitempty"`
+}
+
+// PageArticle holds the article data of a page's JSON-LD.
+type PageArticle struct {
+	Type          string   `json:"type"`
+	Headline      string   `json:"headline,omitempty"`
+	Description   string   `json:"description,omitempty"`
+	Author        string   `json:"author,omitempty"`
+	Publisher     string   `json:"publisher,omitempty"`
+	DatePublished string   `json:"datePublished,omitempty"`
+	DateModified  string   `json:"dateModified,omitempty"`
+	Image         string   `json:"image,omitempty"`
+	Keywords      []string `json:"keywords,omitempty"`
+	Language      string   `json:"language,omitempty"`
+}
+
+// jsonLDArticleTypes lists the schema.org types read as articles.
+var jsonLDArticleTypes = map[string]bool{
+	"Article":            true,
+	"NewsArticle":        true,
+	"BlogPosting":        true,
+	"TechArticle":        true,
+	"ScholarlyArticle":   true,
+	"Report":             true,
+	"SocialMediaPosting": true,
+}
+
+// decodePageCharset converts body to UTF-8 using the charset of the Content-Type header, a BOM
+// or a <meta charset> tag. It returns the converted body and the detected charset name.
+func decodePageCharset(body []byte, contentType string) ([]byte, string) {
+	encoding, name, _ := charset.DetermineEncoding(body, contentType)
+	if name == "utf-8" {
+		return body, name
+	}
+	decoded, err := encoding.NewDecoder().Bytes(body)
+	if err != nil {
+		log.Printf("Failed to decode page from %s, keeping raw bytes: %v", name, err)
+		return body, name
+	}
+	return decoded, name
+}
+
+// htmlAttr returns the value of attribute key of n, or "".
+func htmlAttr(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if strings.EqualFold(attr.Key, key) {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// nodeText concatenates the text nodes below n.
+func nodeText(n *html.Node) string {
+	var buf bytes.Buffer
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			buf.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(n)
+	return buf.String()
+}
+
+// isIconRel reports whether a <link rel> value declares a page icon.
+func isIconRel(rel string) bool {
+	for _, relValue := range strings.Fields(rel) {
+		switch relValue {
+		case "icon", "apple-touch-icon", "apple-touch-icon-precomposed", "mask-icon":
+			return true
+		}
+	}
+	return false
+}
+
+// applyMetaTag maps a <meta> name, property or itemprop (lower-cased) to the PageData fields.
+// Earlier tags win, except that keywords are accumulated.
+func applyMetaTag(pageData *PageData, key string, content string) {
+	if key == "" || content == "" {
+		return
+	}
+	setIfEmpty := func(field *string) {
+		if *field == "" {
+			*field = content
+		}
+	}
+	switch key {
+	case "og:url":
+		setIfEmpty(&pageData.CanonicalURL)
+	case "og:site_name", "application-name":
+		setIfEmpty(&pageData.SiteName)
+	case "author", "article:author", "dc.creator", "parsely-author":
+		setIfEmpty(&pageData.Author)
+	case "article:published_time", "datepublished", "date", "pubdate", "publishdate", "dc.date", "dc.date.issued", "parsely-pub-date":
+		setIfEmpty(&pageData.PublishedAt)
+	case "og:locale", "language", "dc.language":
+		setIfEmpty(&pageData.Language)
+	case "keywords", "news_keywords":
+		pageData.Keywords = append(pageData.Keywords, strings.Split(content, ",")...)
+	case "article:tag":
+		pageData.Keywords = append(pageData.Keywords, content)
+	}
+}
+
+// extractJSONLD reads the first article of a JSON-LD script into pageData.Article.
+func extractJSONLD(text string, pageData *PageData) {
+	if pageData.Article != nil {
+		return
+	}
+	var data interface{}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(text)), &data); err != nil {
+		return
+	}
+	if object := findJSONLDArticle(data); object != nil {
+		pageData.Article = parseJSONLDArticle(object)
+	}
+}
+
+// findJSONLDArticle searches arrays and @graph for the first object with an article type.
+func findJSONLDArticle(data interface{}) map[string]interface{} {
+	switch v := data.(type) {
+	case []interface{}:
+		for _, item := range v {
+			if object := findJSONLDArticle(item); object != nil {
+				return object
+			}
+		}
+	case map[string]interface{}:
+		for _, typeName := range jsonLDStrings(v["@type"]) {
+			if jsonLDArticleTypes[typeName] {
+				return v
+			}
+		}
+		if graph, ok := v["@graph"]; ok {
+			return findJSONLDArticle(graph)
+		}
+	}
+	return nil
+}
+
+func parseJSONLDArticle(object map[string]interface{}) *PageArticle {
+	article := &PageArticle{
+		Headline:      jsonLDName(object["headline"]),
+		Description:   jsonLDName(object["description"]),
+		Author:        strings.Join(jsonLDNames(object["author"]), ", "),
+		Publisher:     jsonLDName(object["publisher"]),
+		DatePublished: jsonLDName(object["datePublished"]),
+		DateModified:  jsonLDName(object["dateModified"]),
+		Image:         jsonLDURL(object["image"]),
+		Language:      jsonLDName(object["inLanguage"]),
+	}
+	if types := jsonLDStrings(object["@type"]); len(types) > 0 {
+		article.Type = types[0]
+	}
+	if article.Headline == "" {
+		article.Headline = jsonLDName(object["name"])
+	}
+	for _, keyword := range jsonLDStrings(object["keywords"]) {
+		article.Keywords = append(article.Keywords, strings.Split(keyword, ",")...)
+	}
+	return article
+}
+
+// jsonLDStrings returns the string values of a JSON-LD string or array.
+func jsonLDStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// jsonLDNames returns the names of a JSON-LD value that is a string, an object with a name
+// or an array of those.
+func jsonLDNames(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{strings.TrimSpace(v)}
+	case map[string]interface{}:
+		if name, ok := v["name"].(string); ok && strings.TrimSpace(name) != "" {
+			return []string{strings.TrimSpace(name)}
+		}
+	case []interface{}:
+		var names []string
+		for _, item := range v {
+			names = append(names, jsonLDNames(item)...)
+		}
+		return names
+	}
+	return nil
+}
+
+func jsonLDName(value interface{}) string {
+	if names := jsonLDNames(value); len(names) > 0 {
+		return names[0]
+	}
+	return ""
+}
+
+// jsonLDURL returns the first URL of a JSON-LD image value (string, ImageObject or array).
+func jsonLDURL(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		if u, ok := v["url"].(string); ok {
+			return u
+		}
+	case []interface{}:
+		for _, item := range v {
+			if u := jsonLDURL(item); u != "" {
+				return u
+			}
+		}
+	}
+	return ""
+}
+
+// publishedDateLayouts are tried, in order, to normalize PublishedAt to RFC 3339.
+var publishedDateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+func normalizePublishedDate(value string) string {
+	value = strings.TrimSpace(value)
+	for _, layout := range publishedDateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.Format(time.RFC3339)
+		}
+	}
+	return value
+}
+
+// finalizePageMetadata resolves the URLs of pageData against the page URL, fills missing fields
+// from the JSON-LD article and normalizes dates and keywords.
+func finalizePageMetadata(pageData *PageData, base *url.URL) {
+	if article := pageData.Article; article != nil {
+		if pageData.Author == "" {
+			pageData.Author = article.Author
+		}
+		if pageData.PublishedAt == "" {
+			pageData.PublishedAt = article.DatePublished
+		}
+		if pageData.SiteName == "" {
+			pageData.SiteName = article.Publisher
+		}
+		if pageData.Language == "" {
+			pageData.Language = article.Language
+		}
+		pageData.Keywords = append(pageData.Keywords, article.Keywords...)
+		setPageImage(pageData, "image_src", article.Image)
+		article.Image = resolvePageURL(base, article.Image)
+	}
+
+	pageData.Image = resolvePageURL(base, pageData.Image)
+	pageData.CanonicalURL = resolvePageURL(base, pageData.CanonicalURL)
+	pageData.PublishedAt = normalizePublishedDate(pageData.PublishedAt)
+
+	icons := pageData.Favicons[:0]
+	for _, icon := range pageData.Favicons {
+		if icon.Href = resolvePageURL(base, icon.Href); icon.Href != "" {
+			icons = append(icons, icon)
+		}
+	}
+	pageData.Favicons = icons
+
+	seen := map[string]bool{}
+	keywords := pageData.Keywords[:0]
+	for _, keyword := range pageData.Keywords {
+		keyword = strings.TrimSpace(keyword)
+		if keyword == "" || seen[strings.ToLower(keyword)] {
+			continue
+		}
+		seen[strings.ToLower(keyword)] = true
+		keywords = append(keywords, keyword)
+	}
+	pageData.Keywords = keywords
+}
+
+// pageMetadataHandler fetches a page and returns its metadata, e.g. for the browser extension
+// when saving a bookmark. The page text is not returned.
+// API Endpoint: POST /api/custom/page-metadata
+// Request: { "url": "string" }
+// Response: { "success": true, "url", "metadata": { "metaTitle", "canonicalUrl", "siteName", ... } }
+func pageMetadataHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		if e.Auth == nil {
+			return apis.NewUnauthorizedError("User not authenticated.", nil)
+		}
+
+		var requestData struct {
+			URL string `json:"url"`
+		}
+		if err := e.BindBody(&requestData); err != nil {
+			return e.BadRequestError("Failed to parse request data (expected 'url').", err)
+		}
+		pageURL, err := validateOutboundURL(e.Request.Context(), requestData.URL)
+		if errors.Is(err, errOutboundURLBlocked) {
+			return e.BadRequestError("A public http(s) URL is required.", err)
+		} else if err != nil {
+			return e.Error(http.StatusBadGateway, "Failed to resolve the page host.", err)
+		}
+
+		pageData, err := fetchPageHTML(pageURL.String())
+		if errors.Is(err, errOutboundURLBlocked) {
+			// 重定向到了不允许的地址
+			return e.BadRequestError("A public http(s) URL is required.", err)
+		} else if err != nil {
+			return e.Error(http.StatusBadGateway, "Failed to fetch the page.", err)
+		}
+		pageData.Content = ""
+
+		return e.JSON(http.StatusOK, map[string]interface{}{
+			"success":  true,
+			"url":      pageURL.String(),
+			"metadata": pageData,
+		})
+	}
+}
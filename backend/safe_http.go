@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// 服务端按用户提供的 URL 发起请求（抓取页面、预览图片、图标、用户自定义的 AI 地址、WebDAV 服务器）时使用的 HTTP 客户端：
+// 只允许 http/https，在建立连接时检查解析出的 IP，拒绝回环、内网、链路本地（含 169.254.169.254 云元数据）等地址。
+// 检查发生在每次拨号时，重定向到新主机以及 DNS 重绑定都会重新检查。
+// 管理员可以用 OUTBOUND_ALLOWED_HOSTS 放行可信的内部主机，逗号分隔，支持主机名、*.后缀、IP 和 CIDR。
+
+// maxOutboundRedirects limits the redirects followed by the outbound client.
+const maxOutboundRedirects = 5
+
+// errOutboundURLBlocked is returned when a URL or the address it resolves to is not allowed.
+var errOutboundURLBlocked = errors.New("URL is not allowed")
+
+// errResponseTooLarge is returned by readLimitedBody when a response exceeds its size cap.
+var errResponseTooLarge = errors.New("response is too large")
+
+// blockedOutboundNetworks are special-purpose ranges not covered by the net.IP helpers.
+var blockedOutboundNetworks = mustParseCIDRs(
+	"0.0.0.0/8",       // "this" network
+	"100.64.0.0/10",   // carrier-grade NAT
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // TEST-NET-1
+	"198.18.0.0/15",   // benchmarking
+	"198.51.100.0/24", // TEST-NET-2
+	"203.0.113.0/24",  // TEST-NET-3
+	"240.0.0.0/4",     // reserved
+	"64:ff9b::/96",    // NAT64, may map to internal IPv4 addresses
+	"64:ff9b:1::/48",  // local-use NAT64
+	"2001:db8::/32",   // documentation
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// outboundAllowList is the parsed OUTBOUND_ALLOWED_HOSTS.
+type outboundAllowList struct {
+	hosts    map[string]bool
+	suffixes []string
+	networks []*net.IPNet
+}
+
+func loadOutboundAllowList() outboundAllowList {
+	allowList := outboundAllowList{hosts: map[string]bool{}}
+	for _, entry := range strings.Split(os.Getenv("OUTBOUND_ALLOWED_HOSTS"), ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+		case strings.Contains(entry, "/"):
+			if _, network, err := net.ParseCIDR(entry); err == nil {
+				allowList.networks = append(allowList.networks, network)
+			}
+		case strings.HasPrefix(entry, "*."):
+			allowList.suffixes = append(allowList.suffixes, entry[1:])
+		default:
+			if ip := net.ParseIP(strings.Trim(entry, "[]")); ip != nil {
+				allowList.networks = append(allowList.networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+				continue
+			}
+			allowList.hosts[strings.TrimSuffix(entry, ".")] = true
+		}
+	}
+	return allowList
+}
+
+// allowsHost reports whether host was allowed by name; its addresses are not checked then.
+func (l outboundAllowList) allowsHost(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if l.hosts[host] {
+		return true
+	}
+	for _, suffix := range l.suffixes {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l outboundAllowList) allowsIP(ip net.IP) bool {
+	for _, network := range l.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return !isBlockedOutboundIP(ip)
+}
+
+// isBlockedOutboundIP reports whether ip is a loopback, private, link-local or otherwise
+// non-public address.
+func isBlockedOutboundIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return true
+	}
+	for _, network := range blockedOutboundNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkOutboundScheme accepts absolute http(s) URLs only.
+func checkOutboundScheme(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: scheme %q is not supported", errOutboundURLBlocked, u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("%w: missing host", errOutboundURLBlocked)
+	}
+	return nil
+}
+
+// validateOutboundURL parses rawURL and checks its scheme and resolved addresses, so that handlers
+// can reject a URL before fetching it. The outbound client checks again when connecting.
+func validateOutboundURL(ctx context.Context, rawURL string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errOutboundURLBlocked, err)
+	}
+	if err := checkOutboundScheme(u); err != nil {
+		return nil, err
+	}
+	if _, err := resolveOutboundHost(ctx, u.Hostname()); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// resolveOutboundHost returns the allowed addresses of host, failing with errOutboundURLBlocked
+// when none are left.
+func resolveOutboundHost(ctx context.Context, host string) ([]net.IP, error) {
+	allowList := loadOutboundAllowList()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	hostAllowed := allowList.allowsHost(host)
+	var ips []net.IP
+	for _, addr := range addrs {
+		if hostAllowed || allowList.allowsIP(addr.IP) {
+			ips = append(ips, addr.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("%w: %s resolves to a private or reserved address", errOutboundURLBlocked, host)
+	}
+	return ips, nil
+}
+
+var outboundDialer = &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+
+// dialOutbound connects to the first allowed address of the host in addr.
+func dialOutbound(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := resolveOutboundHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var lastErr error
+	for _, ip := range ips {
+		conn, err := outboundDialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// outboundTransport is shared by the outbound clients. It ignores HTTP(S)_PROXY, since a
+// proxy would connect to the addresses on our behalf without the checks above.
+var outboundTransport = &http.Transport{
+	DialContext:           dialOutbound,
+	ForceAttemptHTTP2:     true,
+	MaxIdleConns:          50,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ResponseHeaderTimeout: 30 * time.Second,
+}
+
+// newOutboundHTTPClient returns a client for requests to user-supplied URLs.
+func newOutboundHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: outboundTransport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxOutboundRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxOutboundRedirects)
+			}
+			// 新地址的 IP 在拨号时检查
+			return checkOutboundScheme(req.URL)
+		},
+	}
+}
+
+// readLimitedBody reads r, failing with errResponseTooLarge when it is longer than limit bytes.
+func readLimitedBody(r io.Reader, limit int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%w (more than %d bytes)", errResponseTooLarge, limit)
+	}
+	return data, nil
+}
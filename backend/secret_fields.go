@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// 只写的密钥字段：读取 user_settings 时只返回掩码和 "<字段>Configured" 标志，
+// 服务端在需要时自行解密。客户端原样提交的掩码值会被还原为已保存的密文。
+
+// secretMask prefixes every masked secret returned to clients.
+const secretMask = "********"
+
+// writeOnlySecretFields lists the plain user_settings fields that are never returned in cleartext.
+// geminiApiBaseUrl is stored encrypted as well but stays readable.
+var writeOnlySecretFields = []string{"geminiApiKey"}
+
+// maskSecret returns the masked form of a plaintext API key, keeping the last characters of long
+// values so users can tell which key is configured. Passwords are always fully masked.
+func maskSecret(plaintext string) string {
+	if len(plaintext) >= 12 {
+		return secretMask + plaintext[len(plaintext)-4:]
+	}
+	return secretMask
+}
+
+// isMaskedSecret reports whether value is exactly the mask returned for the stored secret, i.e. a
+// client sent it back unchanged. Any other value, including a real secret that happens to start
+// with asterisks, is a new secret.
+func isMaskedSecret(value string, stored string) bool {
+	return stored != "" && value == maskedSecretValue(stored)
+}
+
+// isMaskedJSONSecret is isMaskedSecret for the secrets inside JSON fields, which are always fully
+// masked (see maskJSONFieldSecrets).
+func isMaskedJSONSecret(value string, stored string) bool {
+	return stored != "" && value == secretMask
+}
+
+// maskedSecretValue masks a stored (encrypted) secret, returning "" when it is empty.
+func maskedSecretValue(stored string) string {
+	if stored == "" {
+		return ""
+	}
+	plaintext, err := decryptSensitiveData(stored)
+	if err != nil {
+		if !errors.Is(err, errSecretNotEncrypted) {
+			log.Printf("Error decrypting secret for masking: %v", err)
+			return secretMask
+		}
+		plaintext = stored
+	}
+	return maskSecret(plaintext)
+}
+
+// maskUserSettingsSecrets prepares a user_settings record for a client response.
+func maskUserSettingsSecrets(record *core.Record) {
+	record.WithCustomData(true)
+
+	for _, field := range userSettingsSecretFields {
+		stored := record.GetString(field)
+		if isWriteOnlySecretField(field) {
+			record.Set(field, maskedSecretValue(stored))
+			record.Set(field+"Configured", stored != "")
+			continue
+		}
+		if stored == "" {
+			continue
+		}
+		decrypted, err := decryptUserSetting(record, field)
+		if err != nil {
+			log.Printf("Error decrypting %s: %v (keeping encrypted value)", field, err)
+			continue
+		}
+		record.Set(field, decrypted)
+	}
+
+	maskJSONFieldSecrets(record, "webdav_config", webdavSecretFields)
+	maskJSONFieldSecrets(record, "backup_target", backupTargetSecretFields)
+}
+
+func isWriteOnlySecretField(field string) bool {
+	for _, name := range writeOnlySecretFields {
+		if name == field {
+			return true
+		}
+	}
+	return false
+}
+
+// restoreMaskedSecrets replaces masked values submitted in a create/update request with the
+// stored ones, so that saving the settings form does not overwrite secrets with their mask.
+func restoreMaskedSecrets(record *core.Record) {
+	original := record.Original()
+	for _, field := range writeOnlySecretFields {
+		if isMaskedSecret(record.GetString(field), original.GetString(field)) {
+			record.Set(field, original.GetString(field))
+		}
+	}
+}
+
+// --- Dedicated endpoints ---
+
+// secretJSONFields maps the JSON fields of user_settings to their secret keys.
+var secretJSONFields = map[string][]string{
+	"webdav_config": webdavSecretFields,
+	"backup_target": backupTargetSecretFields,
+}
+
+// parseSecretName splits a secret name ("geminiApiKey" or "webdav_config.Password") into
+// its field and JSON key.
+func parseSecretName(name string) (field string, key string, ok bool) {
+	field, key, nested := strings.Cut(name, ".")
+	if !nested {
+		return field, "", isWriteOnlySecretField(field)
+	}
+	for _, secretKey := range secretJSONFields[field] {
+		if secretKey == key {
+			return field, key, true
+		}
+	}
+	return "", "", false
+}
+
+// SecretStatus describes one secret without revealing it.
+type SecretStatus struct {
+	Name       string `json:"name"`
+	Configured bool   `json:"configured"`
+	Masked     string `json:"masked"`
+}
+
+func storedSecret(record *core.Record, field string, key string) (string, error) {
+	if key == "" {
+		return record.GetString(field), nil
+	}
+	var config map[string]interface{}
+	if _, err := decodeJSONField(record, field, &config); err != nil {
+		return "", err
+	}
+	value, _ := config[key].(string)
+	return value, nil
+}
+
+func setStoredSecret(record *core.Record, field string, key string, encrypted string) error {
+	if key == "" {
+		record.Set(field, encrypted)
+		return nil
+	}
+	var config map[string]interface{}
+	if _, err := decodeJSONField(record, field, &config); err != nil {
+		return err
+	}
+	if config == nil {
+		config = map[string]interface{}{}
+	}
+	if encrypted == "" {
+		delete(config, key)
+	} else {
+		config[key] = encrypted
+	}
+	updatedConfig, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", field, err)
+	}
+	record.Set(field, string(updatedConfig))
+	return nil
+}
+
+func secretStatus(record *core.Record, name string) (SecretStatus, error) {
+	field, key, _ := parseSecretName(name)
+	stored, err := storedSecret(record, field, key)
+	if err != nil {
+		return SecretStatus{}, err
+	}
+	status := SecretStatus{Name: name, Configured: stored != ""}
+	if key == "" {
+		status.Masked = maskedSecretValue(stored)
+	} else if stored != "" {
+		status.Masked = secretMask
+	}
+	return status, nil
+}
+
+// allSecretNames returns the names accepted by the secret endpoints.
+func allSecretNames() []string {
+	names := append([]string{}, writeOnlySecretFields...)
+	for _, field := range []string{"webdav_config", "backup_target"} {
+		for _, key := range secretJSONFields[field] {
+			names = append(names, field+"."+key)
+		}
+	}
+	return names
+}
+
+// secretsListHandler reports which secrets are configured.
+// API Endpoint: GET /api/custom/settings/secrets
+// Response: { "success": true, "secrets": [{ "name", "configured", "masked" }] }
+func secretsListHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		authRecord := e.Auth
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("User not authenticated.", nil)
+		}
+
+		userSettings, err := app.FindFirstRecordByFilter(
+			"user_settings",
+			"userId = {:userId}",
+			dbx.Params{"userId": authRecord.Id},
+		)
+		if err != nil {
+			return e.NotFoundError("User settings not found.", err)
+		}
+
+		secrets := []SecretStatus{}
+		for _, name := range allSecretNames() {
+			status, err := secretStatus(userSettings, name)
+			if err != nil {
+				return e.InternalServerError(fmt.Sprintf("Failed to read secret %s.", name), err)
+			}
+			secrets = append(secrets, status)
+		}
+
+		return e.JSON(http.StatusOK, map[string]interface{}{
+			"success": true,
+			"secrets": secrets,
+		})
+	}
+}
+
+// secretUpdateHandler sets (POST, body { "value": string }) or clears (DELETE) one secret.
+// API Endpoint: POST|DELETE /api/custom/settings/secrets/{name}
+// Response: { "success": true, "secret": { "name", "configured", "masked" } }
+func secretUpdateHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		authRecord := e.Auth
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("User not authenticated.", nil)
+		}
+		userId := authRecord.Id
+
+		name := e.Request.PathValue("name")
+		field, key, ok := parseSecretName(name)
+		if !ok {
+			return e.BadRequestError(fmt.Sprintf("Unknown secret: %s", name), nil)
+		}
+
+		value := ""
+		if e.Request.Method != http.MethodDelete {
+			var requestData struct {
+				Value string `json:"value"`
+			}
+			if err := e.BindBody(&requestData); err != nil {
+				return e.BadRequestError("Failed to parse request data (expected 'value').", err)
+			}
+			if requestData.Value == "" {
+				return e.BadRequestError("Secret value is required; use DELETE to clear it.", nil)
+			}
+			value = requestData.Value
+		}
+
+		userSettings, err := app.FindFirstRecordByFilter(
+			"user_settings",
+			"userId = {:userId}",
+			dbx.Params{"userId": userId},
+		)
+		if err != nil {
+			return e.NotFoundError("User settings not found.", err)
+		}
+
+		// 拒绝原样提交的掩码，避免把掩码当作新密钥保存
+		if value != "" {
+			stored, err := storedSecret(userSettings, field, key)
+			if err != nil {
+				return e.InternalServerError("Failed to read secret.", err)
+			}
+			if (key == "" && isMaskedSecret(value, stored)) || (key != "" && isMaskedJSONSecret(value, stored)) {
+				return e.BadRequestError("Secret value must not be a masked value.", nil)
+			}
+		}
+
+		encrypted, err := encryptSensitiveData(value)
+		if err != nil {
+			return e.InternalServerError("Failed to encrypt secret.", err)
+		}
+		if err := setStoredSecret(userSettings, field, key, encrypted); err != nil {
+			return e.InternalServerError("Failed to update secret.", err)
+		}
+		if err := app.Save(userSettings); err != nil {
+			return e.InternalServerError("Failed to save user settings.", err)
+		}
+
+		if value == "" {
+			log.Printf("Secret %s cleared for user %s", name, userId)
+		} else {
+			log.Printf("Secret %s updated for user %s", name, userId)
+		}
+
+		status, err := secretStatus(userSettings, name)
+		if err != nil {
+			return e.InternalServerError("Failed to read secret.", err)
+		}
+		return e.JSON(http.StatusOK, map[string]interface{}{
+			"success": true,
+			"secret":  status,
+		})
+	}
+}
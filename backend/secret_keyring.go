@@ -0,0 +1,445 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/spf13/cobra"
+)
+
+// 密文格式: "enc:v1:<keyId>:<base64(nonce||ciphertext)>"。keyId 由密钥本身派生，
+// 因此轮换密钥时只需把旧密钥加入 ENCRYPTION_OLD_KEYS，无需额外配置编号。
+// 旧版本保存的值（没有前缀或只有 "enc:" 前缀的密文，或明文）由 reencryptSecret 转换为该格式。
+
+const (
+	secretEnvelopePrefix = "enc:v1:"
+	// legacyWebDAVSecretPrefix prefixed the webdav_config secrets of versions without key ids.
+	legacyWebDAVSecretPrefix = "enc:"
+	encryptionKeyIDLength    = 8
+	// legacyCiphertextMinLength is the decoded length of an empty AES-GCM ciphertext (nonce and tag).
+	legacyCiphertextMinLength = 12 + 16
+	// generatedEncryptionKeyFile stores the key generated when ENCRYPTION_KEY is not set,
+	// so that secrets stay readable across restarts.
+	generatedEncryptionKeyFile = ".encryption_key"
+)
+
+var (
+	errUnknownEncryptionKey = errors.New("ciphertext was encrypted with an unknown key")
+	errSecretNotEncrypted   = errors.New("value is not an encrypted secret")
+	errUndecryptableSecret  = errors.New("value looks like a ciphertext of an older version but cannot be decrypted")
+)
+
+// encryptionKeyEntry is one key of the key ring.
+type encryptionKeyEntry struct {
+	id string
+	// key is the AES-256 key used for prefixed ciphertexts.
+	key []byte
+	// legacyKey is the key derived the way older versions did, for ciphertexts without a key id.
+	legacyKey []byte
+}
+
+// encryptionKeyRing holds the current key first, followed by the old keys that are only used for decryption.
+var encryptionKeyRing []encryptionKeyEntry
+
+func newEncryptionKeyEntry(rawKey string) encryptionKeyEntry {
+	key := sha256.Sum256([]byte(rawKey))
+	fingerprint := sha256.Sum256(append([]byte("markhub-key-id:"), key[:]...))
+	return encryptionKeyEntry{
+		id:        hex.EncodeToString(fingerprint[:])[:encryptionKeyIDLength],
+		key:       key[:],
+		legacyKey: []byte((rawKey + "00000000000000000000000000000000")[:32]),
+	}
+}
+
+// loadEncryptionKeyRing builds the key ring from the current key and the comma separated
+// ENCRYPTION_OLD_KEYS environment variable.
+func loadEncryptionKeyRing(currentKey string) {
+	encryptionKeyRing = []encryptionKeyEntry{newEncryptionKeyEntry(currentKey)}
+	seen := map[string]bool{encryptionKeyRing[0].id: true}
+
+	for _, oldKey := range strings.Split(os.Getenv("ENCRYPTION_OLD_KEYS"), ",") {
+		oldKey = strings.TrimSpace(oldKey)
+		if oldKey == "" {
+			continue
+		}
+		entry := newEncryptionKeyEntry(oldKey)
+		if seen[entry.id] {
+			continue
+		}
+		seen[entry.id] = true
+		encryptionKeyRing = append(encryptionKeyRing, entry)
+	}
+
+	log.Printf("Info: Encryption key ring loaded (current key id: %s, old keys: %d)", encryptionKeyRing[0].id, len(encryptionKeyRing)-1)
+}
+
+// loadOrCreateGeneratedEncryptionKey returns the key persisted in dataDir, generating it on first use.
+func loadOrCreateGeneratedEncryptionKey(dataDir string) (string, error) {
+	keyPath := filepath.Join(dataDir, generatedEncryptionKeyFile)
+
+	if data, err := os.ReadFile(keyPath); err == nil {
+		if key := strings.TrimSpace(string(data)); key != "" {
+			return key, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read %s: %w", keyPath, err)
+	}
+
+	randomKeyBytes := make([]byte, 32)
+	if _, err := rand.Read(randomKeyBytes); err != nil {
+		return "", fmt.Errorf("failed to generate encryption key: %w", err)
+	}
+	key := hex.EncodeToString(randomKeyBytes)
+
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", dataDir, err)
+	}
+	if err := os.WriteFile(keyPath, []byte(key+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", keyPath, err)
+	}
+	log.Printf("Warning: ENCRYPTION_KEY not set, generated a key and stored it in %s. Back up this file or set ENCRYPTION_KEY.", keyPath)
+	return key, nil
+}
+
+// isEncryptedSecret reports whether value was produced by encryptSensitiveData.
+func isEncryptedSecret(value string) bool {
+	return strings.HasPrefix(value, secretEnvelopePrefix)
+}
+
+// splitEncryptionKeyID splits "<keyId>:<payload>" into its parts. ok is false when there is no key id.
+func splitEncryptionKeyID(ciphertext string) (keyID string, payload string, ok bool) {
+	// ":" is not part of the base64 alphabet, so only prefixed ciphertexts contain it
+	keyID, payload, ok = strings.Cut(ciphertext, ":")
+	if !ok || len(keyID) != encryptionKeyIDLength {
+		return "", ciphertext, false
+	}
+	if _, err := hex.DecodeString(keyID); err != nil {
+		return "", ciphertext, false
+	}
+	return keyID, payload, true
+}
+
+// isEncryptedWithCurrentKey reports whether ciphertext carries the id of the current key.
+func isEncryptedWithCurrentKey(ciphertext string) bool {
+	if !isEncryptedSecret(ciphertext) {
+		return false
+	}
+	keyID, _, ok := splitEncryptionKeyID(strings.TrimPrefix(ciphertext, secretEnvelopePrefix))
+	return ok && len(encryptionKeyRing) > 0 && keyID == encryptionKeyRing[0].id
+}
+
+// decryptLegacySecret decrypts values written before the "enc:v1:" envelope existed:
+// "<keyId>:<base64>" or bare base64 (optionally prefixed with "enc:") encrypted with the
+// padded/truncated raw key.
+func decryptLegacySecret(ciphertext string) (string, error) {
+	ciphertext = strings.TrimPrefix(ciphertext, legacyWebDAVSecretPrefix)
+	keyID, payload, prefixed := splitEncryptionKeyID(ciphertext)
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode base64: %w", err)
+	}
+
+	err = errUnknownEncryptionKey
+	for _, entry := range encryptionKeyRing {
+		key := entry.legacyKey
+		if prefixed {
+			if entry.id != keyID {
+				continue
+			}
+			key = entry.key
+		}
+		plaintext, openErr := openWithKey(key, data)
+		if openErr == nil {
+			return string(plaintext), nil
+		}
+		err = openErr
+	}
+	return "", err
+}
+
+// looksLikeLegacyCiphertext reports whether a value without the "enc:v1:" envelope has the shape
+// of a ciphertext written by an older version rather than of a plaintext secret.
+func looksLikeLegacyCiphertext(value string) bool {
+	if strings.HasPrefix(value, legacyWebDAVSecretPrefix) {
+		return true
+	}
+	if _, _, prefixed := splitEncryptionKeyID(value); prefixed {
+		return true
+	}
+	data, err := base64.StdEncoding.DecodeString(value)
+	return err == nil && len(data) >= legacyCiphertextMinLength
+}
+
+func sealWithKey(key []byte, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func openWithKey(key []byte, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	nonceSize := gcm.NonceSize()
+	if len(data) < nonceSize {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+
+	nonce, ciphertextBytes := data[:nonceSize], data[nonceSize:]
+	plaintext, err := gcm.Open(nil, nonce, ciphertextBytes, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return plaintext, nil
+}
+
+// --- Re-encryption command ---
+
+// userSettingsSecretFields lists the plain user_settings fields that are stored encrypted.
+var userSettingsSecretFields = []string{"geminiApiKey", "geminiApiBaseUrl"}
+
+// reencryptSecret brings a stored secret into the current format: values using an old key are
+// re-encrypted, ciphertexts from older versions are decrypted with the legacy scheme and other
+// values are encrypted as plaintext. Ciphertexts that cannot be decrypted (e.g. because their key
+// is missing from ENCRYPTION_OLD_KEYS) are left unchanged and reported as an error.
+// changed is false when nothing had to be done.
+func reencryptSecret(value string) (string, bool, error) {
+	if value == "" || isEncryptedWithCurrentKey(value) {
+		return value, false, nil
+	}
+
+	var plaintext string
+	if isEncryptedSecret(value) {
+		var err error
+		plaintext, err = decryptSensitiveData(value)
+		if err != nil {
+			return value, false, err
+		}
+	} else if legacyPlaintext, err := decryptLegacySecret(value); err == nil {
+		plaintext = legacyPlaintext
+	} else if looksLikeLegacyCiphertext(value) {
+		// 当作明文重新加密会使原值无法再恢复
+		return value, false, fmt.Errorf("%w: %v", errUndecryptableSecret, err)
+	} else {
+		plaintext = value
+	}
+
+	encrypted, err := encryptSensitiveData(plaintext)
+	if err != nil {
+		return value, false, err
+	}
+	return encrypted, true, nil
+}
+
+// reencryptJSONFieldSecrets re-encrypts the given keys of a JSON object field of record.
+func reencryptJSONFieldSecrets(record *core.Record, field string, secretKeys []string) (int, error) {
+	configBytes, err := jsonFieldBytes(record.Get(field))
+	if err != nil || len(configBytes) == 0 {
+		return 0, err
+	}
+
+	var config map[string]interface{}
+	if err := json.Unmarshal(configBytes, &config); err != nil || config == nil {
+		return 0, nil
+	}
+
+	changed := 0
+	for _, secretKey := range secretKeys {
+		secretStr, ok := config[secretKey].(string)
+		if !ok || secretStr == "" {
+			continue
+		}
+		encrypted, didChange, err := reencryptSecret(secretStr)
+		if err != nil {
+			return changed, fmt.Errorf("%s.%s: %w", field, secretKey, err)
+		}
+		if didChange {
+			config[secretKey] = encrypted
+			changed++
+		}
+	}
+
+	if changed > 0 {
+		updatedConfig, err := json.Marshal(config)
+		if err != nil {
+			return 0, fmt.Errorf("failed to marshal %s: %w", field, err)
+		}
+		record.Set(field, string(updatedConfig))
+	}
+	return changed, nil
+}
+
+// reencryptUserSettingsSecrets re-encrypts every secret of every user_settings record (and the
+// shared AI key in ai_settings) under the current key. Records with secrets that cannot be
+// decrypted are reported and left untouched.
+func reencryptUserSettingsSecrets(app core.App, dryRun bool) (updated int, failed int, err error) {
+	records, err := app.FindAllRecords("user_settings")
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to load user_settings: %w", err)
+	}
+
+	for _, record := range records {
+		changed := 0
+		var recordErr error
+
+		for _, field := range userSettingsSecretFields {
+			encrypted, didChange, err := reencryptSecret(record.GetString(field))
+			if err != nil {
+				recordErr = fmt.Errorf("%s: %w", field, err)
+				break
+			}
+			if didChange {
+				record.Set(field, encrypted)
+				changed++
+			}
+		}
+
+		jsonFields := []struct {
+			name string
+			keys []string
+		}{
+			{"webdav_config", webdavSecretFields},
+			{"backup_target", backupTargetSecretFields},
+		}
+		for _, jsonField := range jsonFields {
+			if recordErr != nil {
+				break
+			}
+			n, err := reencryptJSONFieldSecrets(record, jsonField.name, jsonField.keys)
+			if err != nil {
+				recordErr = err
+			}
+			changed += n
+		}
+
+		if recordErr != nil {
+			log.Printf("Re-encrypt: skipping user_settings %s (user %s): %v", record.Id, record.GetString("userId"), recordErr)
+			failed++
+			continue
+		}
+		if changed == 0 {
+			continue
+		}
+
+		if !dryRun {
+			if err := app.Save(record); err != nil {
+				log.Printf("Re-encrypt: failed to save user_settings %s: %v", record.Id, err)
+				failed++
+				continue
+			}
+		}
+		log.Printf("Re-encrypt: %d secret(s) of user_settings %s moved to key %s", changed, record.Id, encryptionKeyRing[0].id)
+		updated++
+	}
+
+	// 实例级共享 AI 密钥（集合不存在时跳过）
+	aiSettingsRecords, err := app.FindAllRecords("ai_settings")
+	if err != nil {
+		return updated, failed, nil
+	}
+	for _, record := range aiSettingsRecords {
+		encrypted, didChange, err := reencryptSecret(record.GetString("apiKey"))
+		if err != nil {
+			log.Printf("Re-encrypt: skipping ai_settings %s: %v", record.Id, err)
+			failed++
+			continue
+		}
+		if !didChange {
+			continue
+		}
+		record.Set("apiKey", encrypted)
+		if !dryRun {
+			if err := app.Save(record); err != nil {
+				log.Printf("Re-encrypt: failed to save ai_settings %s: %v", record.Id, err)
+				failed++
+				continue
+			}
+		}
+		updated++
+	}
+
+	return updated, failed, nil
+}
+
+// convertLegacySecrets converts plaintext and legacy-format secrets to the current encrypted format
+// on startup. Unlike the reencrypt-secrets command it never fails: secrets that cannot be decrypted
+// with any configured key (for example those written by versions that generated a random key on
+// every start) are left untouched and the users have to enter them again.
+func convertLegacySecrets(app core.App) {
+	updated, failed, err := reencryptUserSettingsSecrets(app, false)
+	if err != nil {
+		log.Printf("Warning: failed to convert stored secrets: %v", err)
+		return
+	}
+	if updated > 0 {
+		log.Printf("Info: converted the secrets of %d record(s) to the encrypted format", updated)
+	}
+	if failed > 0 {
+		log.Printf("Warning: %d record(s) have secrets that cannot be decrypted and were skipped; add the missing key to ENCRYPTION_OLD_KEYS, or ask the users to enter these secrets again", failed)
+	}
+}
+
+// newReencryptSecretsCommand returns the "reencrypt-secrets" command, which moves all stored
+// secrets to the current ENCRYPTION_KEY. Run it after rotating the key while the previous key
+// is still listed in ENCRYPTION_OLD_KEYS.
+func newReencryptSecretsCommand(app *pocketbase.PocketBase) *cobra.Command {
+	var dryRun bool
+
+	command := &cobra.Command{
+		Use:          "reencrypt-secrets",
+		Short:        "Re-encrypts all stored user secrets with the current ENCRYPTION_KEY",
+		SilenceUsage: true,
+		RunE: func(command *cobra.Command, args []string) error {
+			updated, failed, err := reencryptUserSettingsSecrets(app, dryRun)
+			if err != nil {
+				return err
+			}
+			action := "Re-encrypted"
+			if dryRun {
+				action = "Would re-encrypt"
+			}
+			fmt.Printf("%s %d user_settings record(s) with key %s, %d failed.\n", action, updated, encryptionKeyRing[0].id, failed)
+			if failed > 0 {
+				return fmt.Errorf("%d record(s) could not be re-encrypted; add the missing key to ENCRYPTION_OLD_KEYS", failed)
+			}
+			return nil
+		},
+	}
+	command.Flags().BoolVar(&dryRun, "dry-run", false, "only report the records that would be re-encrypted")
+
+	return command
+}
@@ -0,0 +1,568 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// 书签和文件夹的回收站：通过记录接口删除时只设置 deletedAt，记录规则隐藏回收站中的记录。
+// 删除文件夹时，子文件夹使用相同的 deletedAt 一起移入回收站，恢复和永久删除时也一起处理；
+// 其中的书签与之前一样移到顶层，不会随文件夹进入回收站。
+// 回收站中的记录超过 TRASH_RETENTION_DAYS 天后每天自动永久删除。
+
+// defaultTrashRetentionDays is used when TRASH_RETENTION_DAYS is not set.
+const defaultTrashRetentionDays = 30
+
+// trashRetentionDays returns how long records stay in the trash; 0 keeps them until purged.
+func trashRetentionDays() int {
+	value := strings.TrimSpace(os.Getenv("TRASH_RETENTION_DAYS"))
+	if value == "" {
+		return defaultTrashRetentionDays
+	}
+	days, err := strconv.Atoi(value)
+	if err != nil || days < 0 {
+		log.Printf("Warning: invalid TRASH_RETENTION_DAYS %q, using %d", value, defaultTrashRetentionDays)
+		return defaultTrashRetentionDays
+	}
+	return days
+}
+
+// isTrashed reports whether a folder or bookmark is in the trash.
+func isTrashed(record *core.Record) bool {
+	return !record.GetDateTime("deletedAt").IsZero()
+}
+
+// allRecordsExp selects all folders or bookmarks of s, including the ones in the trash.
+func (s spaceContext) allRecordsExp() dbx.Expression {
+	if s.isWorkspace() {
+		return dbx.HashExp{"workspaceId": s.workspaceId}
+	}
+	return dbx.HashExp{"userId": s.userId, "workspaceId": ""}
+}
+
+// trashExp selects the folders or bookmarks of s that are in the trash.
+func (s spaceContext) trashExp() dbx.Expression {
+	return dbx.And(s.allRecordsExp(), dbx.NewExp("deletedAt != ''"))
+}
+
+// trashRecord moves a single folder or bookmark to the trash.
+func trashRecord(ctx context.Context, app core.App, record *core.Record, deletedAt types.DateTime) error {
+	record.Set("deletedAt", deletedAt)
+	return app.SaveWithContext(ctx, record)
+}
+
+// folderSubtree returns folder with its descendant folders (parents first) and their bookmarks
+// whose deletedAt equals deletedAt: "" for the live subtree, or the time the folder was moved to
+// the trash for everything trashed together with it.
+func folderSubtree(app core.App, folder *core.Record, deletedAt string) ([]*core.Record, []*core.Record, error) {
+	folders := []*core.Record{folder}
+	seen := map[string]bool{folder.Id: true}
+	for i := 0; i < len(folders); i++ {
+		children, err := app.FindAllRecords("folders", dbx.HashExp{"parentId": folders[i].Id, "deletedAt": deletedAt})
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, child := range children {
+			if !seen[child.Id] {
+				seen[child.Id] = true
+				folders = append(folders, child)
+			}
+		}
+	}
+
+	folderIds := make([]interface{}, 0, len(folders))
+	for _, f := range folders {
+		folderIds = append(folderIds, f.Id)
+	}
+	bookmarks, err := app.FindAllRecords("bookmarks", dbx.In("folderId", folderIds...), dbx.HashExp{"deletedAt": deletedAt})
+	if err != nil {
+		return nil, nil, err
+	}
+	return folders, bookmarks, nil
+}
+
+// trashFolder moves folder and its descendant folders to the trash. Their bookmarks stay out of
+// the trash and are moved to the top level.
+func trashFolder(ctx context.Context, app core.App, folder *core.Record, deletedAt types.DateTime) error {
+	return app.RunInTransaction(func(txApp core.App) error {
+		folders, bookmarks, err := folderSubtree(txApp, folder, "")
+		if err != nil {
+			return err
+		}
+		for _, bookmark := range bookmarks {
+			bookmark.Set("folderId", "")
+			if err := txApp.SaveWithContext(ctx, bookmark); err != nil {
+				return err
+			}
+		}
+		for _, f := range folders {
+			if err := trashRecord(ctx, txApp, f, deletedAt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// parentInTrash reports whether the parent folder (parentField) of record is in the trash or gone.
+func parentInTrash(app core.App, record *core.Record, parentField string) bool {
+	parentId := record.GetString(parentField)
+	if parentId == "" {
+		return false
+	}
+	parent, err := app.FindRecordById("folders", parentId)
+	return err != nil || isTrashed(parent)
+}
+
+// sortFoldersDeepestFirst orders folders so that subfolders come before their parents.
+func sortFoldersDeepestFirst(folders []*core.Record) {
+	parents := make(map[string]string, len(folders))
+	for _, f := range folders {
+		parents[f.Id] = f.GetString("parentId")
+	}
+	depth := make(map[string]int, len(folders))
+	for _, f := range folders {
+		id := f.Id
+		for d := 0; d <= len(folders); d++ {
+			parentId, ok := parents[id]
+			if !ok || parentId == "" {
+				break
+			}
+			depth[f.Id]++
+			id = parentId
+		}
+	}
+	sort.SliceStable(folders, func(i, j int) bool {
+		return depth[folders[i].Id] > depth[folders[j].Id]
+	})
+}
+
+// purgeRecords permanently deletes bookmarks and folders. Subfolders are deleted before their
+// parents so that nothing is unlinked from a folder that is about to be deleted anyway.
+func purgeRecords(ctx context.Context, app core.App, folders []*core.Record, bookmarks []*core.Record) error {
+	for _, bookmark := range bookmarks {
+		if err := app.DeleteWithContext(ctx, bookmark); err != nil {
+			return err
+		}
+	}
+	sortFoldersDeepestFirst(folders)
+	for _, folder := range folders {
+		if err := app.DeleteWithContext(ctx, folder); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgeExpiredTrash permanently deletes the records that have been in the trash longer than the
+// retention period.
+func purgeExpiredTrash(app core.App) {
+	days := trashRetentionDays()
+	if days == 0 {
+		return
+	}
+	expired := dbx.NewExp("deletedAt != '' AND deletedAt < {:before}", dbx.Params{
+		"before": types.NowDateTime().AddDate(0, 0, -days).String(),
+	})
+	folders, err := app.FindAllRecords("folders", expired)
+	if err != nil {
+		log.Printf("[Trash] Failed to load expired folders: %v", err)
+		return
+	}
+	bookmarks, err := app.FindAllRecords("bookmarks", expired)
+	if err != nil {
+		log.Printf("[Trash] Failed to load expired bookmarks: %v", err)
+		return
+	}
+	if len(folders) == 0 && len(bookmarks) == 0 {
+		return
+	}
+
+	ctx := withAuditActor(context.Background(), "", auditSourceSystem)
+	if err := purgeRecords(ctx, app, folders, bookmarks); err != nil {
+		log.Printf("[Trash] Failed to purge expired records: %v", err)
+		return
+	}
+	log.Printf("[Trash] Purged %d folders and %d bookmarks deleted more than %d days ago", len(folders), len(bookmarks), days)
+}
+
+// registerTrashHooks turns deletes through the record API into moves to the trash, keeps new
+// records out of trashed folders and purges expired records daily.
+func registerTrashHooks(app *pocketbase.PocketBase) {
+	app.OnRecordDeleteRequest("folders", "bookmarks").BindFunc(func(e *core.RecordRequestEvent) error {
+		// 超级用户不受记录规则限制，删除回收站中的记录时直接永久删除
+		if isTrashed(e.Record) {
+			return e.Next()
+		}
+		ctx := auditContext(e.RequestEvent, "")
+		deletedAt := types.NowDateTime()
+		var err error
+		if e.Record.Collection().Name == "folders" {
+			err = trashFolder(ctx, e.App, e.Record, deletedAt)
+		} else {
+			err = trashRecord(ctx, e.App, e.Record, deletedAt)
+		}
+		if err != nil {
+			return e.BadRequestError("Failed to move the record to the trash.", err)
+		}
+		return e.NoContent(http.StatusNoContent)
+	})
+
+	// 回收站中的文件夹对客户端不可见，不能在其中新建或移入记录
+	checkParent := func(e *core.RecordRequestEvent, parentField string) error {
+		parentId := e.Record.GetString(parentField)
+		if parentId == "" || (!e.Record.IsNew() && parentId == e.Record.Original().GetString(parentField)) {
+			return e.Next()
+		}
+		if parentInTrash(e.App, e.Record, parentField) {
+			return e.BadRequestError("The folder is in the trash.", nil)
+		}
+		return e.Next()
+	}
+	app.OnRecordCreateRequest("folders").BindFunc(func(e *core.RecordRequestEvent) error {
+		return checkParent(e, "parentId")
+	})
+	app.OnRecordUpdateRequest("folders").BindFunc(func(e *core.RecordRequestEvent) error {
+		return checkParent(e, "parentId")
+	})
+	app.OnRecordCreateRequest("bookmarks").BindFunc(func(e *core.RecordRequestEvent) error {
+		return checkParent(e, "folderId")
+	})
+	app.OnRecordUpdateRequest("bookmarks").BindFunc(func(e *core.RecordRequestEvent) error {
+		return checkParent(e, "folderId")
+	})
+
+	app.Cron().MustAdd("purgeExpiredTrash", "0 4 * * *", func() {
+		purgeExpiredTrash(app)
+	})
+}
+
+// loadTrashRecords returns the records of collection in the trash of space, either all of them or
+// only the ones in ids.
+func loadTrashRecords(app core.App, space spaceContext, collection string, ids []string) ([]*core.Record, error) {
+	query := app.RecordQuery(collection).AndWhere(space.trashExp())
+	if ids != nil {
+		idValues := make([]interface{}, 0, len(ids))
+		for _, id := range ids {
+			idValues = append(idValues, id)
+		}
+		query = query.AndWhere(dbx.In("id", idValues...))
+	}
+	records := []*core.Record{}
+	err := query.OrderBy("deletedAt DESC").All(&records)
+	return records, err
+}
+
+// trashSelection expands the folders and bookmarks picked by the user to everything that was moved
+// to the trash together with the picked folders, without duplicates.
+func trashSelection(app core.App, space spaceContext, folderIds []string, bookmarkIds []string) ([]*core.Record, []*core.Record, []*core.Record, error) {
+	var roots, pickedBookmarks []*core.Record
+	var err error
+	if len(folderIds) > 0 {
+		if roots, err = loadTrashRecords(app, space, "folders", folderIds); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if len(bookmarkIds) > 0 {
+		if pickedBookmarks, err = loadTrashRecords(app, space, "bookmarks", bookmarkIds); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	var folders, bookmarks []*core.Record
+	seen := map[string]bool{}
+	add := func(list []*core.Record, records []*core.Record) []*core.Record {
+		for _, record := range records {
+			if !seen[record.Id] {
+				seen[record.Id] = true
+				list = append(list, record)
+			}
+		}
+		return list
+	}
+	for _, root := range roots {
+		subFolders, subBookmarks, err := folderSubtree(app, root, root.GetString("deletedAt"))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		folders = add(folders, subFolders)
+		bookmarks = add(bookmarks, subBookmarks)
+	}
+	bookmarks = add(bookmarks, pickedBookmarks)
+	return roots, folders, bookmarks, nil
+}
+
+// trashRequest is the body of the restore and purge endpoints.
+type trashRequest struct {
+	FolderIds   []string `json:"folderIds"`
+	BookmarkIds []string `json:"bookmarkIds"`
+	All         bool     `json:"all"`
+}
+
+// listTrashHandler lists the trash of the current space (personal, or the workspace of the
+// X-Workspace-Id header). Only the items deleted by the user are listed: the subfolders and
+// bookmarks deleted together with a folder (bookmarks only when all data was cleared at once) are
+// counted in folderCount and bookmarkCount.
+// API Endpoint: GET /api/custom/trash
+// Response: { "success": true, "retentionDays": 30, "folders": [{ "id", "name", "parentId", "deletedAt", "purgeAt", "folderCount", "bookmarkCount" }], "bookmarks": [{ "id", "title", "url", "folderId", "tags", "faviconUrl", "deletedAt", "purgeAt" }] }
+func listTrashHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		authRecord := e.Auth
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("User not authenticated.", nil)
+		}
+
+		space, err := resolveSpaceContext(app, e)
+		if err != nil {
+			return err
+		}
+
+		folders, err := loadTrashRecords(app, space, "folders", nil)
+		if err != nil {
+			return e.InternalServerError("Failed to load trashed folders.", err)
+		}
+		bookmarks, err := loadTrashRecords(app, space, "bookmarks", nil)
+		if err != nil {
+			return e.InternalServerError("Failed to load trashed bookmarks.", err)
+		}
+
+		// 与父文件夹同时删除的记录归入父文件夹，沿父文件夹向上找到用户删除的那一个
+		trashedFolders := make(map[string]*core.Record, len(folders))
+		for _, folder := range folders {
+			trashedFolders[folder.Id] = folder
+		}
+		deletedWith := func(parentId string, deletedAt string) *core.Record {
+			var root *core.Record
+			for i := 0; i <= len(folders); i++ {
+				parent, ok := trashedFolders[parentId]
+				if !ok || parent.GetString("deletedAt") != deletedAt {
+					break
+				}
+				root = parent
+				parentId = parent.GetString("parentId")
+			}
+			return root
+		}
+
+		retentionDays := trashRetentionDays()
+		purgeAt := func(record *core.Record) interface{} {
+			if retentionDays == 0 {
+				return nil
+			}
+			return record.GetDateTime("deletedAt").AddDate(0, 0, retentionDays)
+		}
+
+		folderItems := []map[string]interface{}{}
+		folderItemsById := map[string]map[string]interface{}{}
+		for _, folder := range folders {
+			if deletedWith(folder.GetString("parentId"), folder.GetString("deletedAt")) != nil {
+				continue
+			}
+			item := map[string]interface{}{
+				"id":            folder.Id,
+				"name":          folder.GetString("name"),
+				"parentId":      folder.GetString("parentId"),
+				"deletedAt":     folder.GetDateTime("deletedAt"),
+				"purgeAt":       purgeAt(folder),
+				"folderCount":   0,
+				"bookmarkCount": 0,
+			}
+			folderItems = append(folderItems, item)
+			folderItemsById[folder.Id] = item
+		}
+		for _, folder := range folders {
+			if root := deletedWith(folder.GetString("parentId"), folder.GetString("deletedAt")); root != nil {
+				if item, ok := folderItemsById[root.Id]; ok {
+					item["folderCount"] = item["folderCount"].(int) + 1
+				}
+			}
+		}
+
+		bookmarkItems := []map[string]interface{}{}
+		for _, bookmark := range bookmarks {
+			if root := deletedWith(bookmark.GetString("folderId"), bookmark.GetString("deletedAt")); root != nil {
+				if item, ok := folderItemsById[root.Id]; ok {
+					item["bookmarkCount"] = item["bookmarkCount"].(int) + 1
+				}
+				continue
+			}
+			bookmarkItems = append(bookmarkItems, map[string]interface{}{
+				"id":         bookmark.Id,
+				"title":      bookmark.GetString("title"),
+				"url":        bookmark.GetString("url"),
+				"folderId":   bookmark.GetString("folderId"),
+				"tags":       bookmark.GetStringSlice("tags"),
+				"faviconUrl": bookmark.GetString("faviconUrl"),
+				"deletedAt":  bookmark.GetDateTime("deletedAt"),
+				"purgeAt":    purgeAt(bookmark),
+			})
+		}
+
+		return e.JSON(http.StatusOK, map[string]interface{}{
+			"success":       true,
+			"retentionDays": retentionDays,
+			"folders":       folderItems,
+			"bookmarks":     bookmarkItems,
+		})
+	}
+}
+
+// restoreTrashHandler takes folders and bookmarks out of the trash. A folder is restored with
+// everything deleted together with it; items whose parent folder is still in the trash are moved
+// to the top level. The tags of restored bookmarks are added back to the tag list.
+// API Endpoint: POST /api/custom/trash/restore
+// Request: { "folderIds": ["..."], "bookmarkIds": ["..."] }
+// Response: { "success": true, "restoredFolders": 3, "restoredBookmarks": 12 }
+func restoreTrashHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		authRecord := e.Auth
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("User not authenticated.", nil)
+		}
+
+		space, err := resolveSpaceContext(app, e)
+		if err != nil {
+			return err
+		}
+		if !space.canEdit() {
+			return apis.NewForbiddenError("You need editor access to this workspace.", nil)
+		}
+
+		var requestBody trashRequest
+		if err := e.BindBody(&requestBody); err != nil {
+			return e.BadRequestError("Invalid request body.", err)
+		}
+		if len(requestBody.FolderIds) == 0 && len(requestBody.BookmarkIds) == 0 {
+			return e.BadRequestError("folderIds or bookmarkIds is required.", nil)
+		}
+
+		roots, folders, bookmarks, err := trashSelection(app, space, requestBody.FolderIds, requestBody.BookmarkIds)
+		if err != nil {
+			return e.InternalServerError("Failed to load trashed records.", err)
+		}
+		if len(folders) == 0 && len(bookmarks) == 0 {
+			return e.NotFoundError("No matching items in the trash.", nil)
+		}
+
+		auditCtx := auditContext(e, "")
+		err = app.RunInTransaction(func(txApp core.App) error {
+			// 先恢复文件夹，再检查书签所在的文件夹是否仍在回收站中
+			for _, root := range roots {
+				if parentInTrash(txApp, root, "parentId") {
+					root.Set("parentId", "")
+				}
+			}
+			for _, folder := range folders {
+				folder.Set("deletedAt", "")
+				if err := txApp.SaveWithContext(auditCtx, folder); err != nil {
+					return err
+				}
+			}
+
+			var tags []string
+			for _, bookmark := range bookmarks {
+				if parentInTrash(txApp, bookmark, "folderId") {
+					bookmark.Set("folderId", "")
+				}
+				bookmark.Set("deletedAt", "")
+				if err := txApp.SaveWithContext(auditCtx, bookmark); err != nil {
+					return err
+				}
+				tags = append(tags, bookmark.GetStringSlice("tags")...)
+			}
+
+			if tagRecord, err := space.tagListRecord(txApp); err == nil && addToTagList(tagRecord, tags) {
+				if err := txApp.SaveWithContext(auditCtx, tagRecord); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return e.InternalServerError("Failed to restore from the trash.", err)
+		}
+
+		return e.JSON(http.StatusOK, map[string]interface{}{
+			"success":           true,
+			"restoredFolders":   len(folders),
+			"restoredBookmarks": len(bookmarks),
+		})
+	}
+}
+
+// purgeTrashHandler permanently deletes folders and bookmarks from the trash, a folder together
+// with everything deleted with it. "all": true empties the trash, which in a workspace needs the
+// owner or admin role.
+// API Endpoint: POST /api/custom/trash/purge
+// Request: { "folderIds": ["..."], "bookmarkIds": ["..."] } or { "all": true }
+// Response: { "success": true, "purgedFolders": 3, "purgedBookmarks": 12 }
+func purgeTrashHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		authRecord := e.Auth
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("User not authenticated.", nil)
+		}
+
+		space, err := resolveSpaceContext(app, e)
+		if err != nil {
+			return err
+		}
+
+		var requestBody trashRequest
+		if err := e.BindBody(&requestBody); err != nil {
+			return e.BadRequestError("Invalid request body.", err)
+		}
+
+		var folders, bookmarks []*core.Record
+		if requestBody.All {
+			if !space.canManage() {
+				return apis.NewForbiddenError("Only workspace owners and admins can empty the trash.", nil)
+			}
+			if folders, err = loadTrashRecords(app, space, "folders", nil); err != nil {
+				return e.InternalServerError("Failed to load trashed folders.", err)
+			}
+			if bookmarks, err = loadTrashRecords(app, space, "bookmarks", nil); err != nil {
+				return e.InternalServerError("Failed to load trashed bookmarks.", err)
+			}
+		} else {
+			if !space.canEdit() {
+				return apis.NewForbiddenError("You need editor access to this workspace.", nil)
+			}
+			if len(requestBody.FolderIds) == 0 && len(requestBody.BookmarkIds) == 0 {
+				return e.BadRequestError("folderIds, bookmarkIds or all is required.", nil)
+			}
+			_, folders, bookmarks, err = trashSelection(app, space, requestBody.FolderIds, requestBody.BookmarkIds)
+			if err != nil {
+				return e.InternalServerError("Failed to load trashed records.", err)
+			}
+			if len(folders) == 0 && len(bookmarks) == 0 {
+				return e.NotFoundError("No matching items in the trash.", nil)
+			}
+		}
+
+		auditCtx := auditContext(e, "")
+		err = app.RunInTransaction(func(txApp core.App) error {
+			return purgeRecords(auditCtx, txApp, folders, bookmarks)
+		})
+		if err != nil {
+			return e.InternalServerError("Failed to purge the trash.", err)
+		}
+
+		return e.JSON(http.StatusOK, map[string]interface{}{
+			"success":         true,
+			"purgedFolders":   len(folders),
+			"purgedBookmarks": len(bookmarks),
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/studio-b12/gowebdav"
+)
+
+const webdavCheckTimeout = 15 * time.Second
+
+// Diagnosis codes returned by webdavTestConnectionHandler.
+const (
+	webdavDiagnosisOK                = "ok"
+	webdavDiagnosisInvalidConfig     = "invalid_config"
+	webdavDiagnosisAuthFailed        = "auth_failed"
+	webdavDiagnosisPathNotFound      = "path_not_found"
+	webdavDiagnosisPathNotWritable   = "path_not_writable"
+	webdavDiagnosisTLSError          = "tls_error"
+	webdavDiagnosisTimeout           = "timeout"
+	webdavDiagnosisDNSError          = "dns_error"
+	webdavDiagnosisConnectionRefused = "connection_refused"
+	webdavDiagnosisURLBlocked        = "url_blocked"
+	webdavDiagnosisReadMismatch      = "read_mismatch"
+	webdavDiagnosisServerError       = "server_error"
+	webdavDiagnosisUnknown           = "unknown"
+)
+
+// WebDAVCheckStep is the outcome of one step of a connection test.
+type WebDAVCheckStep struct {
+	Step       string `json:"step"`
+	OK         bool   `json:"ok"`
+	DurationMs int64  `json:"durationMs"`
+	Error      string `json:"error,omitempty"`
+}
+
+// WebDAVDiagnosis summarises why a connection test failed.
+type WebDAVDiagnosis struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	FailedStep string `json:"failedStep,omitempty"`
+}
+
+// diagnoseWebDAVError maps an error from the given step to a diagnosis code and message.
+func diagnoseWebDAVError(step string, err error) (string, string) {
+	if errors.Is(err, errOutboundURLBlocked) {
+		return webdavDiagnosisURLBlocked, "The WebDAV URL points to a private or reserved address; ask the administrator to add the host to OUTBOUND_ALLOWED_HOSTS."
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return webdavDiagnosisTimeout, "The WebDAV server did not respond in time."
+	}
+
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var certInvalid x509.CertificateInvalidError
+	var certVerification *tls.CertificateVerificationError
+	var recordHeader tls.RecordHeaderError
+	if errors.As(err, &unknownAuthority) || errors.As(err, &hostnameErr) || errors.As(err, &certInvalid) ||
+		errors.As(err, &certVerification) || errors.As(err, &recordHeader) {
+		return webdavDiagnosisTLSError, fmt.Sprintf("TLS handshake with the WebDAV server failed: %v", err)
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return webdavDiagnosisDNSError, fmt.Sprintf("The WebDAV host could not be resolved: %s", dnsErr.Name)
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return webdavDiagnosisConnectionRefused, "The WebDAV server refused the connection."
+	}
+	if errors.Is(err, gowebdav.ErrAuthChanged) {
+		return webdavDiagnosisAuthFailed, "The WebDAV server rejected the username or password."
+	}
+
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		var statusErr gowebdav.StatusError
+		if errors.As(pathErr.Err, &statusErr) {
+			switch {
+			case statusErr.Status == http.StatusUnauthorized:
+				return webdavDiagnosisAuthFailed, "The WebDAV server rejected the username or password."
+			case statusErr.Status == http.StatusForbidden && step == "connect":
+				return webdavDiagnosisAuthFailed, "The WebDAV account is not allowed to access this server."
+			case statusErr.Status == http.StatusNotFound && (step == "connect" || step == "mkdir"):
+				return webdavDiagnosisPathNotFound, fmt.Sprintf("The WebDAV URL or path was not found: %s", pathErr.Path)
+			case statusErr.Status == http.StatusForbidden, statusErr.Status == http.StatusMethodNotAllowed,
+				statusErr.Status == http.StatusConflict, statusErr.Status == http.StatusInsufficientStorage:
+				return webdavDiagnosisPathNotWritable, fmt.Sprintf("The WebDAV path is not writable (HTTP %d at %s).", statusErr.Status, step)
+			case statusErr.Status >= 500:
+				return webdavDiagnosisServerError, fmt.Sprintf("The WebDAV server returned HTTP %d at %s.", statusErr.Status, step)
+			}
+		}
+	}
+
+	if step == "mkdir" || step == "write" || step == "delete" {
+		return webdavDiagnosisPathNotWritable, fmt.Sprintf("The WebDAV path is not writable: %v", err)
+	}
+	return webdavDiagnosisUnknown, err.Error()
+}
+
+// runWebDAVCheck performs connect, mkdir and a write/read/delete round trip against config.
+// The password in config must be in plaintext.
+func runWebDAVCheck(config WebDAVConfig) ([]WebDAVCheckStep, *WebDAVDiagnosis) {
+	steps := []WebDAVCheckStep{}
+
+	client := newWebDAVClient(config.Url, config.Username, config.Password)
+	client.SetTimeout(webdavCheckTimeout)
+
+	testFileName := fmt.Sprintf(".markhub_connection_test_%d.txt", time.Now().UnixNano())
+	testFilePath := path.Join(config.Path, testFileName)
+	testContent := []byte("MarkHub WebDAV connection test")
+
+	run := func(step string, fn func() error) *WebDAVDiagnosis {
+		start := time.Now()
+		err := fn()
+		result := WebDAVCheckStep{Step: step, OK: err == nil, DurationMs: time.Since(start).Milliseconds()}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		steps = append(steps, result)
+		if err == nil {
+			return nil
+		}
+		code, message := diagnoseWebDAVError(step, err)
+		return &WebDAVDiagnosis{Code: code, Message: message, FailedStep: step}
+	}
+
+	if d := run("connect", client.Connect); d != nil {
+		return steps, d
+	}
+	if d := run("mkdir", func() error { return client.MkdirAll(config.Path, 0755) }); d != nil {
+		return steps, d
+	}
+	if d := run("write", func() error { return client.Write(testFilePath, testContent, 0644) }); d != nil {
+		return steps, d
+	}
+
+	readDiagnosis := run("read", func() error {
+		data, err := client.Read(testFilePath)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(data, testContent) {
+			return fmt.Errorf("read back %d bytes that differ from the written test file", len(data))
+		}
+		return nil
+	})
+	if readDiagnosis != nil && readDiagnosis.Code == webdavDiagnosisUnknown {
+		readDiagnosis.Code = webdavDiagnosisReadMismatch
+	}
+
+	// Always try to clean up the test file, even when reading it back failed
+	deleteDiagnosis := run("delete", func() error { return client.Remove(testFilePath) })
+
+	if readDiagnosis != nil {
+		return steps, readDiagnosis
+	}
+	return steps, deleteDiagnosis
+}
+
+// webdavTestConnectionHandler checks a candidate WebDAV configuration (or the saved one)
+// without saving anything.
+// API Endpoint: POST /api/custom/webdav/test-connection
+// Request Body: { "config": WebDAVConfig | null }
+// Response: { "success": bool, "diagnosis": { "code", "message", "failedStep" }, "steps": [...] }
+func webdavTestConnectionHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		authRecord := e.Auth
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("User not authenticated.", nil)
+		}
+		userId := authRecord.Id
+
+		var requestData struct {
+			Config *WebDAVConfig `json:"config"`
+		}
+		if err := e.BindBody(&requestData); err != nil {
+			return e.BadRequestError("Failed to parse request data (expected optional 'config').", err)
+		}
+
+		// The saved configuration is used as-is when no candidate is given, and to
+		// fill in the password when the candidate leaves it empty or masked.
+		var savedConfig WebDAVConfig
+		hasSaved := false
+		userSettings, err := app.FindFirstRecordByFilter(
+			"user_settings",
+			"userId = {:userId}",
+			dbx.Params{"userId": userId},
+		)
+		if err == nil {
+			hasSaved, err = decodeJSONField(userSettings, "webdav_config", &savedConfig)
+			if err != nil {
+				log.Printf("WebDAV test: failed to read saved webdav_config for user %s: %v", userId, err)
+				hasSaved = false
+			}
+		}
+		if hasSaved && savedConfig.Password != "" {
+			savedConfig.Password, err = decryptPassword(savedConfig.Password)
+			if err != nil {
+				return e.InternalServerError("Failed to decrypt saved WebDAV password.", err)
+			}
+		}
+
+		var config WebDAVConfig
+		switch {
+		case requestData.Config != nil:
+			config = *requestData.Config
+			if (config.Password == "" || isMaskedJSONSecret(config.Password, savedConfig.Password)) && hasSaved && strings.EqualFold(config.Url, savedConfig.Url) {
+				config.Password = savedConfig.Password
+			}
+		case hasSaved:
+			config = savedConfig
+		default:
+			return e.BadRequestError("No WebDAV configuration provided or saved.", nil)
+		}
+
+		if config.Url == "" || config.Username == "" {
+			return e.JSON(http.StatusOK, map[string]interface{}{
+				"success": false,
+				"diagnosis": WebDAVDiagnosis{
+					Code:    webdavDiagnosisInvalidConfig,
+					Message: "WebDAV URL and username are required.",
+				},
+				"steps": []WebDAVCheckStep{},
+			})
+		}
+
+		steps, diagnosis := runWebDAVCheck(config)
+		if diagnosis == nil {
+			diagnosis = &WebDAVDiagnosis{Code: webdavDiagnosisOK, Message: "WebDAV connection is working."}
+		} else {
+			log.Printf("WebDAV test for user %s failed at %s: %s", userId, diagnosis.FailedStep, diagnosis.Code)
+		}
+
+		return e.JSON(http.StatusOK, map[string]interface{}{
+			"success":   diagnosis.Code == webdavDiagnosisOK,
+			"diagnosis": diagnosis,
+			"steps":     steps,
+		})
+	}
+}
@@ -0,0 +1,503 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// 团队工作区：工作区拥有自己的文件夹、书签（workspaceId 不为空，userId 记录创建者）和标签列表（workspaces.tagList）。
+// 成员角色：owner 拥有工作区（唯一），admin 管理成员和设置，editor 可以增删改文件夹和书签，viewer 只读。
+// 自定义接口通过 X-Workspace-Id 请求头（或 workspaceId 查询参数）指定工作区，不指定时使用个人空间（userId）。
+
+// Workspace member roles, as in the workspace_members collection.
+const (
+	workspaceRoleOwner  = "owner"
+	workspaceRoleAdmin  = "admin"
+	workspaceRoleEditor = "editor"
+	workspaceRoleViewer = "viewer"
+)
+
+// workspaceHeader selects the workspace of a custom API request.
+const workspaceHeader = "X-Workspace-Id"
+
+// workspaceMemberRole returns the role of userId in workspaceId, or "" if not a member.
+func workspaceMemberRole(app core.App, workspaceId string, userId string) string {
+	if workspaceId == "" || userId == "" {
+		return ""
+	}
+	member, err := app.FindFirstRecordByFilter(
+		"workspace_members",
+		"workspaceId = {:workspaceId} && userId = {:userId}",
+		dbx.Params{"workspaceId": workspaceId, "userId": userId},
+	)
+	if err != nil {
+		return ""
+	}
+	return member.GetString("role")
+}
+
+// workspaceRoleCanEdit reports whether role may create and modify folders and bookmarks.
+func workspaceRoleCanEdit(role string) bool {
+	return role == workspaceRoleOwner || role == workspaceRoleAdmin || role == workspaceRoleEditor
+}
+
+// workspaceRoleCanManage reports whether role may manage members and workspace settings.
+func workspaceRoleCanManage(role string) bool {
+	return role == workspaceRoleOwner || role == workspaceRoleAdmin
+}
+
+// spaceContext is the space a request works in: the user's personal space, or a workspace the
+// user is a member of.
+type spaceContext struct {
+	userId      string
+	workspaceId string // 为空表示个人空间
+	role        string // 个人空间中为 owner
+}
+
+// personalSpace returns the personal space of userId.
+func personalSpace(userId string) spaceContext {
+	return spaceContext{userId: userId, role: workspaceRoleOwner}
+}
+
+// tagListRecordOf returns the record holding the tag list for the tags of a folder or bookmark:
+// its workspace, or the user_settings of its owner. In a folder shared with an editor the owner is
+// not the acting user.
+func tagListRecordOf(app core.App, record *core.Record) (*core.Record, error) {
+	if workspaceId := record.GetString("workspaceId"); workspaceId != "" {
+		return spaceContext{workspaceId: workspaceId}.tagListRecord(app)
+	}
+	return personalSpace(record.GetString("userId")).tagListRecord(app)
+}
+
+// resolveSpaceContext returns the space selected by the request's X-Workspace-Id header or
+// workspaceId query parameter, checking that the user is a member of the workspace.
+func resolveSpaceContext(app core.App, e *core.RequestEvent) (spaceContext, error) {
+	workspaceId := strings.TrimSpace(e.Request.Header.Get(workspaceHeader))
+	if workspaceId == "" {
+		workspaceId = strings.TrimSpace(e.Request.URL.Query().Get("workspaceId"))
+	}
+	if workspaceId == "" {
+		return personalSpace(e.Auth.Id), nil
+	}
+	role := workspaceMemberRole(app, workspaceId, e.Auth.Id)
+	if role == "" {
+		return spaceContext{}, e.NotFoundError("Workspace not found or you are not a member.", nil)
+	}
+	return spaceContext{userId: e.Auth.Id, workspaceId: workspaceId, role: role}, nil
+}
+
+// isWorkspace reports whether s is a workspace rather than the personal space.
+func (s spaceContext) isWorkspace() bool {
+	return s.workspaceId != ""
+}
+
+// canEdit reports whether the user may create and modify folders and bookmarks in s.
+func (s spaceContext) canEdit() bool {
+	return workspaceRoleCanEdit(s.role)
+}
+
+// canManage reports whether the user may manage s (members, tag list, clearing data).
+func (s spaceContext) canManage() bool {
+	return workspaceRoleCanManage(s.role)
+}
+
+// recordFilter returns the filter and params selecting the folders or bookmarks of s that are not
+// in the trash.
+func (s spaceContext) recordFilter() (string, dbx.Params) {
+	if s.isWorkspace() {
+		return "workspaceId = {:workspaceId} && deletedAt = ''", dbx.Params{"userId": s.userId, "workspaceId": s.workspaceId}
+	}
+	return "userId = {:userId} && workspaceId = '' && deletedAt = ''", dbx.Params{"userId": s.userId, "workspaceId": ""}
+}
+
+// recordExp is recordFilter as a query expression, for RecordQuery and CountRecords.
+func (s spaceContext) recordExp() dbx.Expression {
+	if s.isWorkspace() {
+		return dbx.HashExp{"workspaceId": s.workspaceId, "deletedAt": ""}
+	}
+	return dbx.HashExp{"userId": s.userId, "workspaceId": "", "deletedAt": ""}
+}
+
+// tagListRecord returns the record holding the tag list of s: the user's user_settings, or the
+// workspace.
+func (s spaceContext) tagListRecord(app core.App) (*core.Record, error) {
+	if s.isWorkspace() {
+		return app.FindRecordById("workspaces", s.workspaceId)
+	}
+	return app.FindFirstRecordByFilter("user_settings", "userId = {:userId}", dbx.Params{"userId": s.userId})
+}
+
+// addToTagList appends the tags of tags that are not yet in the tagList of record, reporting
+// whether anything was added.
+func addToTagList(record *core.Record, tags []string) bool {
+	tagList := record.GetStringSlice("tagList")
+	known := make(map[string]bool, len(tagList))
+	for _, tag := range tagList {
+		known[strings.TrimSpace(tag)] = true
+	}
+	added := false
+	for _, tag := range tags {
+		if tag = strings.TrimSpace(tag); tag != "" && !known[tag] {
+			tagList = append(tagList, tag)
+			known[tag] = true
+			added = true
+		}
+	}
+	if added {
+		record.Set("tagList", tagList)
+	}
+	return added
+}
+
+// checkWorkspaceWrite validates a create or update of a folder (parentField "parentId") or
+// bookmark (parentField "folderId"): records stay in their space, the target folder must be in
+// the same space, and workspace records need an editing role.
+func checkWorkspaceWrite(e *core.RecordRequestEvent, parentField string, isCreate bool) error {
+	if e.Auth == nil || e.HasSuperuserAuth() {
+		return nil
+	}
+	record := e.Record
+	workspaceId := record.GetString("workspaceId")
+
+	if !isCreate && workspaceId != record.Original().GetString("workspaceId") {
+		return apis.NewBadRequestError("Items can't be moved between spaces.", nil)
+	}
+	if workspaceId != "" && !workspaceRoleCanEdit(workspaceMemberRole(e.App, workspaceId, e.Auth.Id)) {
+		return apis.NewForbiddenError("You need editor access to this workspace.", nil)
+	}
+
+	if targetId := record.GetString(parentField); targetId != "" {
+		target, err := e.App.FindRecordById("folders", targetId)
+		if err != nil {
+			return apis.NewBadRequestError("Folder not found.", err)
+		}
+		if target.GetString("workspaceId") != workspaceId {
+			return apis.NewBadRequestError("The folder belongs to another space.", nil)
+		}
+	}
+	return nil
+}
+
+// registerWorkspaceHooks checks writes to workspace folders and bookmarks.
+func registerWorkspaceHooks(app *pocketbase.PocketBase) {
+	for _, binding := range []struct{ collection, parentField string }{
+		{"folders", "parentId"},
+		{"bookmarks", "folderId"},
+	} {
+		parentField := binding.parentField
+		app.OnRecordCreateRequest(binding.collection).BindFunc(func(e *core.RecordRequestEvent) error {
+			if err := checkWorkspaceWrite(e, parentField, true); err != nil {
+				return err
+			}
+			return e.Next()
+		})
+		app.OnRecordUpdateRequest(binding.collection).BindFunc(func(e *core.RecordRequestEvent) error {
+			if err := checkWorkspaceWrite(e, parentField, false); err != nil {
+				return err
+			}
+			return e.Next()
+		})
+	}
+}
+
+// workspaceResponse is the JSON representation of a workspace for one of its members.
+func workspaceResponse(workspace *core.Record, role string) map[string]interface{} {
+	tagList := workspace.GetStringSlice("tagList")
+	if tagList == nil {
+		tagList = []string{}
+	}
+	return map[string]interface{}{
+		"id":        workspace.Id,
+		"name":      workspace.GetString("name"),
+		"ownerId":   workspace.GetString("ownerId"),
+		"tagList":   tagList,
+		"role":      role,
+		"createdAt": workspace.GetDateTime("createdAt"),
+		"updatedAt": workspace.GetDateTime("updatedAt"),
+	}
+}
+
+// workspaceMemberResponse is the JSON representation of a workspace member.
+func workspaceMemberResponse(app core.App, member *core.Record) map[string]interface{} {
+	response := map[string]interface{}{
+		"id":          member.Id,
+		"workspaceId": member.GetString("workspaceId"),
+		"userId":      member.GetString("userId"),
+		"role":        member.GetString("role"),
+		"createdAt":   member.GetDateTime("createdAt"),
+	}
+	if user, err := app.FindRecordById("users", member.GetString("userId")); err == nil {
+		response["email"] = user.Email()
+		response["name"] = user.GetString("name")
+	}
+	return response
+}
+
+// findWorkspaceMembership returns the workspace of the path parameter workspaceId and the role of
+// the current user in it.
+func findWorkspaceMembership(app core.App, e *core.RequestEvent) (*core.Record, string, error) {
+	workspace, err := app.FindRecordById("workspaces", e.Request.PathValue("workspaceId"))
+	if err != nil {
+		return nil, "", e.NotFoundError("Workspace not found or you are not a member.", err)
+	}
+	role := workspaceMemberRole(app, workspace.Id, e.Auth.Id)
+	if role == "" {
+		return nil, "", e.NotFoundError("Workspace not found or you are not a member.", nil)
+	}
+	return workspace, role, nil
+}
+
+// createWorkspaceHandler creates a workspace owned by the current user.
+// API Endpoint: POST /api/custom/workspaces
+// Request: { "name": "string" }
+// Response (201): { "id", "name", "ownerId", "tagList", "role", "createdAt", "updatedAt" }
+func createWorkspaceHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		authRecord := e.Auth
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("User not authenticated.", nil)
+		}
+
+		var requestData struct {
+			Name string `json:"name"`
+		}
+		if err := e.BindBody(&requestData); err != nil {
+			return e.BadRequestError("Failed to parse request data.", err)
+		}
+		name := strings.TrimSpace(requestData.Name)
+		if name == "" {
+			return e.BadRequestError("Workspace name is required.", nil)
+		}
+
+		var workspace *core.Record
+		err := app.RunInTransaction(func(txApp core.App) error {
+			workspacesCollection, err := txApp.FindCollectionByNameOrId("workspaces")
+			if err != nil {
+				return err
+			}
+			membersCollection, err := txApp.FindCollectionByNameOrId("workspace_members")
+			if err != nil {
+				return err
+			}
+
+			workspace = core.NewRecord(workspacesCollection)
+			workspace.Set("name", name)
+			workspace.Set("ownerId", authRecord.Id)
+			workspace.Set("tagList", []string{})
+			if err := txApp.Save(workspace); err != nil {
+				return err
+			}
+
+			member := core.NewRecord(membersCollection)
+			member.Set("workspaceId", workspace.Id)
+			member.Set("userId", authRecord.Id)
+			member.Set("role", workspaceRoleOwner)
+			return txApp.Save(member)
+		})
+		if err != nil {
+			return e.InternalServerError("Failed to create workspace", err)
+		}
+
+		return e.JSON(http.StatusCreated, workspaceResponse(workspace, workspaceRoleOwner))
+	}
+}
+
+// listWorkspacesHandler lists the workspaces the current user is a member of.
+// API Endpoint: GET /api/custom/workspaces
+// Response: { "items": [workspace, ...] }
+func listWorkspacesHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		authRecord := e.Auth
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("User not authenticated.", nil)
+		}
+
+		memberships, err := app.FindRecordsByFilter("workspace_members", "userId = {:userId}", "createdAt", 0, 0, dbx.Params{"userId": authRecord.Id})
+		if err != nil {
+			return e.InternalServerError("Failed to fetch workspaces", err)
+		}
+		items := make([]map[string]interface{}, 0, len(memberships))
+		for _, membership := range memberships {
+			workspace, err := app.FindRecordById("workspaces", membership.GetString("workspaceId"))
+			if err != nil {
+				continue
+			}
+			items = append(items, workspaceResponse(workspace, membership.GetString("role")))
+		}
+		return e.JSON(http.StatusOK, map[string]interface{}{"items": items})
+	}
+}
+
+// deleteWorkspaceHandler deletes a workspace with all its folders and bookmarks. Only the owner
+// can delete it.
+// API Endpoint: DELETE /api/custom/workspaces/{workspaceId}
+func deleteWorkspaceHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		authRecord := e.Auth
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("User not authenticated.", nil)
+		}
+
+		workspace, role, err := findWorkspaceMembership(app, e)
+		if err != nil {
+			return err
+		}
+		if role != workspaceRoleOwner {
+			return apis.NewForbiddenError("Only the owner can delete the workspace.", nil)
+		}
+		if err := app.Delete(workspace); err != nil {
+			return e.InternalServerError("Failed to delete workspace", err)
+		}
+		return e.NoContent(http.StatusNoContent)
+	}
+}
+
+// listWorkspaceMembersHandler lists the members of a workspace.
+// API Endpoint: GET /api/custom/workspaces/{workspaceId}/members
+// Response: { "items": [{ "id", "workspaceId", "userId", "email", "name", "role", "createdAt" }, ...] }
+func listWorkspaceMembersHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		authRecord := e.Auth
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("User not authenticated.", nil)
+		}
+
+		workspace, _, err := findWorkspaceMembership(app, e)
+		if err != nil {
+			return err
+		}
+		members, err := app.FindRecordsByFilter("workspace_members", "workspaceId = {:workspaceId}", "createdAt", 0, 0, dbx.Params{"workspaceId": workspace.Id})
+		if err != nil {
+			return e.InternalServerError("Failed to fetch workspace members", err)
+		}
+		items := make([]map[string]interface{}, 0, len(members))
+		for _, member := range members {
+			items = append(items, workspaceMemberResponse(app, member))
+		}
+		return e.JSON(http.StatusOK, map[string]interface{}{"items": items})
+	}
+}
+
+// setWorkspaceMemberHandler adds a user to a workspace or changes the role of a member. Owners
+// and admins can manage editors and viewers; only the owner can add or change admins. The owner
+// role can't be assigned.
+// API Endpoint: POST /api/custom/workspaces/{workspaceId}/members
+// Request: { "email": "string", "role": "admin" | "editor" | "viewer" }
+// Response: the member, see listWorkspaceMembersHandler
+func setWorkspaceMemberHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		authRecord := e.Auth
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("User not authenticated.", nil)
+		}
+
+		var requestData struct {
+			Email string `json:"email"`
+			Role  string `json:"role"`
+		}
+		if err := e.BindBody(&requestData); err != nil {
+			return e.BadRequestError("Failed to parse request data.", err)
+		}
+		role := requestData.Role
+		if role == "" {
+			role = workspaceRoleViewer
+		}
+		if role != workspaceRoleAdmin && role != workspaceRoleEditor && role != workspaceRoleViewer {
+			return e.BadRequestError("role must be 'admin', 'editor' or 'viewer'.", nil)
+		}
+
+		workspace, actorRole, err := findWorkspaceMembership(app, e)
+		if err != nil {
+			return err
+		}
+		if !workspaceRoleCanManage(actorRole) {
+			return apis.NewForbiddenError("Only owners and admins can manage members.", nil)
+		}
+
+		user, err := app.FindAuthRecordByEmail("users", strings.TrimSpace(requestData.Email))
+		if err != nil {
+			return e.NotFoundError("No user with this email address.", err)
+		}
+
+		member, err := app.FindFirstRecordByFilter(
+			"workspace_members",
+			"workspaceId = {:workspaceId} && userId = {:userId}",
+			dbx.Params{"workspaceId": workspace.Id, "userId": user.Id},
+		)
+		status := http.StatusOK
+		if err != nil {
+			collection, err := app.FindCollectionByNameOrId("workspace_members")
+			if err != nil {
+				return e.InternalServerError("Failed to find workspace_members collection", err)
+			}
+			member = core.NewRecord(collection)
+			member.Set("workspaceId", workspace.Id)
+			member.Set("userId", user.Id)
+			status = http.StatusCreated
+		}
+
+		currentRole := member.GetString("role")
+		if currentRole == workspaceRoleOwner {
+			return e.BadRequestError("The owner's role can't be changed.", nil)
+		}
+		if actorRole != workspaceRoleOwner && (role == workspaceRoleAdmin || currentRole == workspaceRoleAdmin) {
+			return apis.NewForbiddenError("Only the owner can add or change admins.", nil)
+		}
+
+		member.Set("role", role)
+		if err := app.Save(member); err != nil {
+			return e.InternalServerError("Failed to save workspace member", err)
+		}
+		return e.JSON(status, workspaceMemberResponse(app, member))
+	}
+}
+
+// removeWorkspaceMemberHandler removes a member from a workspace. Owners and admins can remove
+// editors and viewers, the owner can also remove admins, and every member except the owner can
+// leave. Folders and bookmarks the member created stay in the workspace.
+// API Endpoint: DELETE /api/custom/workspaces/{workspaceId}/members/{userId}
+func removeWorkspaceMemberHandler(app *pocketbase.PocketBase) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		authRecord := e.Auth
+		if authRecord == nil {
+			return apis.NewUnauthorizedError("User not authenticated.", nil)
+		}
+
+		workspace, actorRole, err := findWorkspaceMembership(app, e)
+		if err != nil {
+			return err
+		}
+		member, err := app.FindFirstRecordByFilter(
+			"workspace_members",
+			"workspaceId = {:workspaceId} && userId = {:userId}",
+			dbx.Params{"workspaceId": workspace.Id, "userId": e.Request.PathValue("userId")},
+		)
+		if err != nil {
+			return e.NotFoundError("Workspace member not found.", err)
+		}
+
+		memberRole := member.GetString("role")
+		switch {
+		case memberRole == workspaceRoleOwner:
+			return e.BadRequestError("The owner can't leave the workspace; delete it instead.", nil)
+		case member.GetString("userId") == authRecord.Id:
+			// 成员退出工作区
+		case !workspaceRoleCanManage(actorRole):
+			return apis.NewForbiddenError("Only owners and admins can remove members.", nil)
+		case memberRole == workspaceRoleAdmin && actorRole != workspaceRoleOwner:
+			return apis.NewForbiddenError("Only the owner can remove admins.", nil)
+		}
+
+		if err := app.Delete(member); err != nil {
+			return e.InternalServerError("Failed to remove workspace member", err)
+		}
+		log.Printf("Workspace %s: user %s removed member %s", workspace.Id, authRecord.Id, member.GetString("userId"))
+		return e.NoContent(http.StatusNoContent)
+	}
+}
@@ -100,6 +100,8 @@ export class MarkhubAPIClient {
 
     const headers: Record<string, string> = {
       'Content-Type': 'application/json',
+      // 服务端审计日志据此把修改的来源记为 extension
+      'X-Client-Source': 'extension',
     };
 
     if (useAuth && config.authToken) {